@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	defaultCorrelationDays   = 7
+	defaultCorrelationBucket = time.Hour
+	minCorrelationBucket     = time.Minute
+)
+
+// bucketPrices keeps the last price seen in each bucket of the given size,
+// keyed by the bucket's start
+func bucketPrices(points []pricePoint, bucket time.Duration) map[int64]float64 {
+	buckets := make(map[int64]float64)
+	for _, p := range points {
+		// points are oldest first, so later prices overwrite earlier ones
+		buckets[p.Time.Truncate(bucket).Unix()] = p.Price
+	}
+	return buckets
+}
+
+// alignedReturns returns the paired returns of a and b between consecutive
+// buckets where both have a price
+func alignedReturns(a, b map[int64]float64) (ra, rb []float64) {
+	var common []int64
+	for t := range a {
+		if _, ok := b[t]; ok {
+			common = append(common, t)
+		}
+	}
+	sort.Slice(common, func(i, j int) bool { return common[i] < common[j] })
+	for i := 1; i < len(common); i++ {
+		prevA, prevB := a[common[i-1]], b[common[i-1]]
+		if prevA <= 0 || prevB <= 0 {
+			continue
+		}
+		ra = append(ra, a[common[i]]/prevA-1)
+		rb = append(rb, b[common[i]]/prevB-1)
+	}
+	return ra, rb
+}
+
+// pearson returns the Pearson correlation of x and y, or false when it is
+// undefined because there are fewer than two pairs or a series is flat
+func pearson(x, y []float64) (float64, bool) {
+	n := len(x)
+	if n < 2 || n != len(y) {
+		return 0, false
+	}
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= float64(n)
+	meanY /= float64(n)
+
+	var cov, varX, varY float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return 0, false
+	}
+	return cov / math.Sqrt(varX*varY), true
+}
+
+// handleCorrelation returns the Pearson correlation of the returns of two
+// symbols over the price history recorded by the monitors. Prices are
+// aligned into ?bucket sized intervals (default 1h) using the last price
+// of each, and only intervals where both symbols have a price are used.
+func (s *Server) handleCorrelation(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	a, b := normalizeSymbol(query.Get("a")), normalizeSymbol(query.Get("b"))
+	if a == "" || b == "" {
+		http.Error(w, "Missing a or b", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -defaultCorrelationDays)
+	var err error
+	if query.Get("from") != "" {
+		if from, err = parseDateParam(query.Get("from")); err != nil {
+			http.Error(w, "Invalid from date", http.StatusBadRequest)
+			return
+		}
+	}
+	if query.Get("to") != "" {
+		if to, err = parseDateParam(query.Get("to")); err != nil {
+			http.Error(w, "Invalid to date", http.StatusBadRequest)
+			return
+		}
+	}
+	if !to.After(from) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+	bucket := defaultCorrelationBucket
+	if value := query.Get("bucket"); value != "" {
+		bucket, err = time.ParseDuration(value)
+		if err != nil || bucket < minCorrelationBucket {
+			http.Error(w, "Invalid bucket, expected a duration of at least 1m", http.StatusBadRequest)
+			return
+		}
+	}
+
+	historyA, err := s.store.PriceHistory(a, from, to)
+	if err != nil {
+		serverError(w, r, "Error fetching price history", err)
+		return
+	}
+	historyB, err := s.store.PriceHistory(b, from, to)
+	if err != nil {
+		serverError(w, r, "Error fetching price history", err)
+		return
+	}
+	returnsA, returnsB := alignedReturns(bucketPrices(historyA, bucket), bucketPrices(historyB, bucket))
+	correlation, ok := pearson(returnsA, returnsB)
+
+	response := struct {
+		A           string    `json:"a"`
+		B           string    `json:"b"`
+		From        time.Time `json:"from"`
+		To          time.Time `json:"to"`
+		Bucket      string    `json:"bucket"`
+		Samples     int       `json:"samples"`     // Paired returns used
+		Correlation *float64  `json:"correlation"` // Null without enough overlapping history
+	}{
+		A:       a,
+		B:       b,
+		From:    from,
+		To:      to,
+		Bucket:  bucket.String(),
+		Samples: len(returnsA),
+	}
+	if ok {
+		response.Correlation = &correlation
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
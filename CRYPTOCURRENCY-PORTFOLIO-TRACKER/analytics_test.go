@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPearson(t *testing.T) {
+	cases := []struct {
+		name string
+		x, y []float64
+		want float64
+		ok   bool
+	}{
+		{"identical", []float64{1, 2, 3}, []float64{1, 2, 3}, 1, true},
+		{"opposite", []float64{1, 2, 3}, []float64{3, 2, 1}, -1, true},
+		{"flat", []float64{1, 2, 3}, []float64{5, 5, 5}, 0, false},
+		{"one pair", []float64{1}, []float64{2}, 0, false},
+	}
+	for _, c := range cases {
+		got, ok := pearson(c.x, c.y)
+		if ok != c.ok || math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s: got %v, %v; want %v, %v", c.name, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestAlignedReturnsSkipsUnsharedBuckets(t *testing.T) {
+	a := map[int64]float64{0: 100, 60: 110, 120: 121}
+	b := map[int64]float64{0: 10, 30: 99, 120: 12}
+	ra, rb := alignedReturns(a, b)
+	if len(ra) != 1 || math.Abs(ra[0]-0.21) > 1e-9 || math.Abs(rb[0]-0.2) > 1e-9 {
+		t.Errorf("got %v and %v, want one pair of returns from bucket 0 to 120", ra, rb)
+	}
+}
+
+func TestCorrelationFromRecordedHistory(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{})
+
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i, price := range []float64{100, 110, 99, 120, 90} {
+		at := start.Add(time.Duration(i) * time.Hour)
+		// ETH moves in step with BTC, SOL against it
+		for symbol, p := range map[string]float64{"BTC": price, "ETH": price / 10, "SOL": 1000 / price} {
+			if err := store.RecordPrice(symbol, p, at.Add(time.Duration(len(symbol))*time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, c := range []struct {
+		b      string
+		lo, hi float64
+	}{{"ETH", 1 - 1e-9, 1 + 1e-9}, {"SOL", -1, -0.9}} {
+		req := httptest.NewRequest(http.MethodGet, "/analytics/correlation?a=btc&b="+c.b+"&from=2026-03-01&to=2026-03-02", nil)
+		rec := serve(mux, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+		}
+		var got struct {
+			Samples     int      `json:"samples"`
+			Correlation *float64 `json:"correlation"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Samples != 4 || got.Correlation == nil {
+			t.Fatalf("BTC/%s: got %+v, want 4 samples and a correlation", c.b, got)
+		}
+		if *got.Correlation < c.lo || *got.Correlation > c.hi {
+			t.Errorf("BTC/%s correlation = %v, want between %v and %v", c.b, *got.Correlation, c.lo, c.hi)
+		}
+	}
+}
+
+func TestCorrelationWithoutOverlapIsNull(t *testing.T) {
+	_, mux := newTestServer(t, newSQLStore(t), fakePrices{})
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/analytics/correlation?a=BTC&b=ETH", nil))
+	var got struct {
+		Samples     int      `json:"samples"`
+		Correlation *float64 `json:"correlation"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || got.Samples != 0 || got.Correlation != nil {
+		t.Errorf("status %d, got %+v; want no samples and a null correlation", rec.Code, got)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// authConfig protects the API with a shared key
+type authConfig struct {
+	// APIKey must be sent as "Authorization: Bearer <key>" or "X-API-Key:
+	// <key>" on requests that modify data. The API_KEY environment variable
+	// overrides it. Empty leaves the API open.
+	APIKey string `json:"api_key,omitempty"`
+	// RequireForReads demands the key on every request except /health
+	RequireForReads bool `json:"require_for_reads"`
+
+	// RequireLogin makes the portfolio endpoints reject requests without a
+	// session from /login. Without it, requests that aren't logged in can
+	// still name any user_id, as before accounts existed.
+	RequireLogin bool `json:"require_login"`
+	// SessionHours is how long a login lasts, 24 hours if zero
+	SessionHours int `json:"session_hours,omitempty"`
+}
+
+// apiKey returns the configured key, API_KEY taking precedence
+func (c authConfig) apiKey() string {
+	if key := os.Getenv("API_KEY"); key != "" {
+		return key
+	}
+	return c.APIKey
+}
+
+// requestAPIKey returns the key a request carries, if any
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// keysEqual compares keys in constant time. Both are hashed first so the
+// comparison doesn't reveal the key's length either.
+func keysEqual(got, want string) bool {
+	g, w := sha256.Sum256([]byte(got)), sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(g[:], w[:]) == 1
+}
+
+// sessionRoutes are the per-user routes a logged-in user may call without
+// the API key, since they are scoped to that user's own data. Everything
+// else, including /admin/* and /config/*, acts on the whole service and
+// still needs the key.
+var sessionRoutes = map[string]bool{
+	"/portfolio":             true,
+	"/portfolio/add":         true,
+	"/portfolio/remove":      true,
+	"/portfolio/update":      true,
+	"/portfolio/value":       true,
+	"/portfolio/export":      true,
+	"/portfolio/import":      true,
+	"/portfolio/preview-add": true,
+	"/portfolio/performers":  true,
+	"/portfolio/pnl":         true,
+	"/portfolio/target":      true,
+	"/transactions/import":   true,
+	"/transactions/pnl":      true,
+	"/users/settings":        true,
+}
+
+// keyedReadRoutes need the API key even to read, without RequireForReads
+var keyedReadRoutes = map[string]bool{
+	"/config/export": true,
+}
+
+// keyedRead reports whether reading path needs the API key regardless of
+// RequireForReads: keyedReadRoutes and everything under /admin/, whose
+// reads can expose service internals such as notifier errors
+func keyedRead(path string) bool {
+	return keyedReadRoutes[path] || strings.HasPrefix(path, "/admin/")
+}
+
+// requireAPIKey rejects requests that modify data, or every request with
+// RequireForReads, with 401 Unauthorized unless they carry the API key.
+// keyedReadRoutes and /admin/* always need it. /login doesn't, nor do
+// logged-in users on sessionRoutes; /register does, so only key holders
+// can create accounts.
+func requireAPIKey(c authConfig, next http.Handler) http.Handler {
+	key := c.apiKey()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, loggedIn := sessionUserID(r.Context())
+		switch {
+		case r.Method == http.MethodOptions, r.URL.Path == "/health", r.URL.Path == "/login":
+		case loggedIn && sessionRoutes[r.URL.Path]:
+		case !c.RequireForReads && !keyedRead(r.URL.Path) && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		default:
+			got := requestAPIKey(r)
+			if got == "" || !keysEqual(got, key) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="portfolio"`)
+				http.Error(w, "Missing or invalid API key", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireAPIKeySessionBypass(t *testing.T) {
+	t.Setenv("API_KEY", "")
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := requireAPIKey(authConfig{APIKey: "secret"}, next)
+
+	cases := []struct {
+		method, path string
+		loggedIn     bool
+		key          string
+		want         int
+	}{
+		{http.MethodPost, "/portfolio/add", true, "", http.StatusOK},
+		{http.MethodPost, "/users/settings", true, "", http.StatusOK},
+		{http.MethodPost, "/portfolio/add", false, "", http.StatusUnauthorized},
+		{http.MethodPost, "/admin/backup", true, "", http.StatusUnauthorized},
+		{http.MethodPost, "/admin/monitor/pause", true, "", http.StatusUnauthorized},
+		{http.MethodPost, "/config/thresholds", true, "", http.StatusUnauthorized},
+		{http.MethodPost, "/portfolio/thresholds", true, "", http.StatusUnauthorized},
+		{http.MethodPost, "/admin/backup", false, "secret", http.StatusOK},
+		{http.MethodPost, "/admin/backup", false, "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/portfolio/value", false, "", http.StatusOK},
+		{http.MethodGet, "/config/export", false, "", http.StatusUnauthorized},
+		{http.MethodGet, "/config/export", true, "", http.StatusUnauthorized},
+		{http.MethodGet, "/config/export", false, "secret", http.StatusOK},
+		{http.MethodGet, "/admin/errors", false, "", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/errors", true, "", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/errors", false, "secret", http.StatusOK},
+		{http.MethodGet, "/admin/backup", false, "", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		if c.key != "" {
+			req.Header.Set("X-API-Key", c.key)
+		}
+		if c.loggedIn {
+			req = asUser(req, 1)
+		}
+		rec := serve(handler, req)
+		if rec.Code != c.want {
+			t.Errorf("%s %s logged in %v key %q: status = %d, want %d", c.method, c.path, c.loggedIn, c.key, rec.Code, c.want)
+		}
+	}
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	t.Setenv("API_KEY", "")
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := requireAPIKey(authConfig{APIKey: "secret"}, next)
+
+	for _, c := range []struct {
+		name          string
+		header, value string
+		want          int
+	}{
+		{"missing key", "", "", http.StatusUnauthorized},
+		{"wrong key", "X-API-Key", "secreT", http.StatusUnauthorized},
+		{"wrong bearer token", "Authorization", "Bearer nope", http.StatusUnauthorized},
+		{"other scheme", "Authorization", "Basic secret", http.StatusUnauthorized},
+		{"correct key", "X-API-Key", "secret", http.StatusOK},
+		{"correct bearer token", "Authorization", "bearer secret", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodDelete, "/portfolio/remove?id=1", nil)
+		if c.header != "" {
+			req.Header.Set(c.header, c.value)
+		}
+		rec := serve(handler, req)
+		if rec.Code != c.want {
+			t.Errorf("%s: status = %d, want %d", c.name, rec.Code, c.want)
+		}
+		if c.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no WWW-Authenticate challenge", c.name)
+		}
+	}
+
+	// Reads need the key too once RequireForReads is set, except /health
+	strict := requireAPIKey(authConfig{APIKey: "secret", RequireForReads: true}, next)
+	if rec := serve(strict, httptest.NewRequest(http.MethodGet, "/portfolio/value", nil)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("read without key: status = %d, want 401", rec.Code)
+	}
+	if rec := serve(strict, httptest.NewRequest(http.MethodGet, "/health", nil)); rec.Code != http.StatusOK {
+		t.Errorf("/health without key: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestAPIKeyFromEnvironment(t *testing.T) {
+	t.Setenv("API_KEY", "from-env")
+	handler := requireAPIKey(authConfig{APIKey: "from-config"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for key, want := range map[string]int{"from-env": http.StatusOK, "from-config": http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodPost, "/portfolio/add", nil)
+		req.Header.Set("X-API-Key", key)
+		if rec := serve(handler, req); rec.Code != want {
+			t.Errorf("key %q: status = %d, want %d", key, rec.Code, want)
+		}
+	}
+}
+
+func TestRecentErrorsNeedTheAPIKey(t *testing.T) {
+	t.Setenv("API_KEY", "")
+	handler := requireAPIKey(authConfig{APIKey: "secret"}, http.HandlerFunc(handleRecentErrors))
+	reportError("notify", "Error sending notification", "err", "Post \"https://hooks.slack.com/services/T0/B0/XYZ\": refused")
+
+	rec := serve(handler, httptest.NewRequest(http.MethodGet, "/admin/errors", nil))
+	if rec.Code != http.StatusUnauthorized || strings.Contains(rec.Body.String(), "hooks.slack.com") {
+		t.Errorf("without the key: status = %d, body %q; want 401 revealing nothing", rec.Code, rec.Body)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/admin/errors", nil)
+	req.Header.Set("X-API-Key", "secret")
+	if rec := serve(handler, req); rec.Code != http.StatusOK {
+		t.Errorf("with the key: status = %d, want 200", rec.Code)
+	}
+}
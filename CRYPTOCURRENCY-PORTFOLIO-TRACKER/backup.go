@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBackupDir    = "backups"
+	defaultBackupRetain = 7
+	backupFilePrefix    = "portfolio-"
+	backupFileSuffix    = ".db"
+
+	// backupTimeFormat is fixed width so names sort chronologically, and
+	// has nanoseconds so backups in the same second don't collide
+	backupTimeFormat = "20060102-150405.000000000"
+)
+
+type backupConfig struct {
+	Dir             string `json:"dir"`              // Directory backups are written to
+	IntervalMinutes int    `json:"interval_minutes"` // 0 disables scheduled backups
+	Retain          int    `json:"retain"`           // Number of backups to keep
+}
+
+// backupMu prevents a manual backup from racing a scheduled one
+var backupMu sync.Mutex
+
+// runBackup is the scheduled backup job
+func (s *Server) runBackup(ctx context.Context) error {
+	path, err := s.backupDatabase(cfg.Backup)
+	if err != nil {
+		return err
+	}
+	slog.Info("Database backed up", "component", "backup", "path", path)
+	return nil
+}
+
+// backupDatabase writes a consistent copy of the database to a timestamped
+// file using VACUUM INTO and removes backups beyond the retention count
+func (s *Server) backupDatabase(bc backupConfig) (string, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	dir := bc.Dir
+	if dir == "" {
+		dir = defaultBackupDir
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	path, err := newBackupPath(dir)
+	if err != nil {
+		return "", err
+	}
+	if err := s.store.Backup(path); err != nil {
+		return "", err
+	}
+
+	retain := bc.Retain
+	if retain <= 0 {
+		retain = defaultBackupRetain
+	}
+	if err := pruneBackups(dir, retain); err != nil {
+		reportError("backup", "Error pruning old backups", "err", err)
+	}
+	return path, nil
+}
+
+// newBackupPath returns a timestamped backup path in dir that doesn't exist
+// yet, trying a later timestamp if it does
+func newBackupPath(dir string) (string, error) {
+	for {
+		name := backupFilePrefix + time.Now().UTC().Format(backupTimeFormat) + backupFileSuffix
+		path := filepath.Join(dir, name)
+		_, err := os.Lstat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return path, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+func (s *SQLStore) Backup(path string) error {
+	_, err := s.db.Exec("VACUUM INTO ?", path)
+	return err
+}
+
+// pruneBackups deletes the oldest backups so that at most retain remain.
+// The timestamped names sort chronologically.
+func pruneBackups(dir string, retain int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasPrefix(name, backupFilePrefix) && strings.HasSuffix(name, backupFileSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	sort.Strings(backups)
+
+	for len(backups) > retain {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// handleBackup triggers a database backup on demand
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path, err := s.backupDatabase(cfg.Backup)
+	if err != nil {
+		reportError("backup", "Error backing up database", "err", err)
+		http.Error(w, "Error backing up database", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Path string `json:"path"`
+	}{
+		Path: path,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// fileBackupStore is a Store whose backups are empty files
+type fileBackupStore struct {
+	Store
+}
+
+func (fileBackupStore) Backup(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func TestBackupsInTheSameSecondGetDistinctNames(t *testing.T) {
+	s, _ := newTestServer(t, fileBackupStore{}, fakePrices{})
+	bc := backupConfig{Dir: t.TempDir(), Retain: 10}
+
+	var paths []string
+	for i := 0; i < 5; i++ {
+		path, err := s.backupDatabase(bc)
+		if err != nil {
+			t.Fatalf("backup %d: %v", i, err)
+		}
+		paths = append(paths, path)
+	}
+	entries, err := os.ReadDir(bc.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(paths) {
+		t.Fatalf("%d backups on disk, want %d", len(entries), len(paths))
+	}
+	if !sort.StringsAreSorted(paths) {
+		t.Errorf("backup names don't sort in the order they were taken: %v", paths)
+	}
+}
+
+func TestNewBackupPathSkipsExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	first, err := newBackupPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(first, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	second, err := newBackupPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first || filepath.Dir(second) != dir {
+		t.Errorf("got %s after %s was taken", second, first)
+	}
+}
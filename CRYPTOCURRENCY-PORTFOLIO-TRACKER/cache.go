@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ttlCache is a small concurrency-safe cache whose entries expire after ttl
+type ttlCache[T any] struct {
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]ttlEntry[T]
+}
+
+type ttlEntry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+// clearable is implemented by every cache flushed by /admin/cache/clear
+type clearable interface {
+	clear() int
+}
+
+var (
+	cachesMu sync.Mutex
+	caches   []clearable
+)
+
+// registerCache adds c to the set of caches flushed by clearCaches
+func registerCache(c clearable) {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+	caches = append(caches, c)
+}
+
+// clearCaches empties every registered cache and returns the number of
+// entries removed
+func clearCaches() int {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+	cleared := 0
+	for _, c := range caches {
+		cleared += c.clear()
+	}
+	return cleared
+}
+
+func newTTLCache[T any](ttl time.Duration) *ttlCache[T] {
+	c := &ttlCache[T]{ttl: ttl, entries: make(map[string]ttlEntry[T])}
+	registerCache(c)
+	return c
+}
+
+// get returns the cached value for key if it hasn't expired
+func (c *ttlCache[T]) get(key string) (T, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		var zero T
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// set stores value under key for the cache's ttl
+func (c *ttlCache[T]) set(key string, value T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = ttlEntry[T]{value: value, expiresAt: time.Now().Add(c.ttl)}
+}
+
+// clear removes all entries and returns how many there were
+func (c *ttlCache[T]) clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.entries)
+	c.entries = make(map[string]ttlEntry[T])
+	return n
+}
+
+// handleClearCache flushes all in-memory caches so the next reads fetch fresh data
+func handleClearCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cleared := clearCaches()
+	slog.Info("Cleared caches", "component", "cache", "entries", cleared)
+
+	response := struct {
+		Cleared int `json:"cleared"`
+	}{
+		Cleared: cleared,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// coinCapHistory is the response of /v2/assets/{id}/history
+type coinCapHistory struct {
+	Data []struct {
+		PriceUsd string `json:"priceUsd"`
+		Time     int64  `json:"time"` // Unix milliseconds
+	} `json:"data"`
+}
+
+// pricePoint is a price observed at a point in time
+type pricePoint struct {
+	Time  time.Time `json:"time"`
+	Price float64   `json:"price"`
+}
+
+const (
+	coinCapDebugLogLimit  = 2048 // How much of each response body is logged in debug mode
+	coinCapErrorBodyLimit = 512  // How much of an error response is kept in the error
+)
+
+// coinCapDebug logs every raw CoinCap response when COINCAP_DEBUG is set
+var coinCapDebug = os.Getenv("COINCAP_DEBUG") != ""
+
+const (
+	rateLimitRetries      = 3                // Retries after a 429 before giving up
+	rateLimitDefaultDelay = 5 * time.Second  // Wait when a 429 has no usable Retry-After
+	rateLimitMaxDelay     = 60 * time.Second // Cap on how long a Retry-After is honored
+)
+
+// coinCapClient makes every CoinCap request. Its timeout is set from the
+// config at startup.
+var coinCapClient = &http.Client{Timeout: defaultHTTPTimeoutSeconds * time.Second}
+
+// coinCapGet fetches rawURL from CoinCap and decodes the JSON response into v.
+// Rate limited (429) responses are retried after the Retry-After delay.
+// The request is abandoned when ctx is cancelled or the client times out.
+func coinCapGet(ctx context.Context, rawURL string, v any) error {
+	resp, err := coinCapDo(ctx, rawURL)
+	for retry := 1; err == nil && resp.StatusCode == http.StatusTooManyRequests && retry <= rateLimitRetries; retry++ {
+		resp.Body.Close()
+		delay := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		slog.Warn("CoinCap rate limited, retrying", "component", "coincap", "delay", delay.String(), "retry", retry, "max_retries", rateLimitRetries)
+		if !sleepContext(ctx, delay) {
+			return ctx.Err()
+		}
+		resp, err = coinCapDo(ctx, rawURL)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Error bodies are short explanations, worth keeping in the error
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, coinCapErrorBodyLimit))
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return fmt.Errorf("CoinCap rate limit exceeded: %s", bytes.TrimSpace(body))
+		}
+		return fmt.Errorf("CoinCap responded %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	if !coinCapDebug {
+		return json.NewDecoder(resp.Body).Decode(v)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	logged, suffix := body, ""
+	if len(logged) > coinCapDebugLogLimit {
+		logged, suffix = logged[:coinCapDebugLogLimit], fmt.Sprintf("... (%d bytes)", len(body))
+	}
+	// The query string is left out in case it ever carries credentials
+	endpoint := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		u.RawQuery = ""
+		endpoint = u.String()
+	}
+	slog.Info("CoinCap response", "component", "coincap", "endpoint", endpoint, "status", resp.Status, "body", string(logged)+suffix)
+	return json.Unmarshal(body, v)
+}
+
+// coinCapDo sends a GET for rawURL with coinCapClient, recording how long
+// CoinCap took to respond
+func coinCapDo(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := coinCapClient.Do(req)
+	coinCapLatency.observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// getCoinCapAssetID resolves a ticker symbol to CoinCap's asset id
+// (e.g. BTC -> bitcoin), which the per-asset endpoints require
+func getCoinCapAssetID(ctx context.Context, symbol string) (string, error) {
+	assetData, err := getCoinCapAssets(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for _, asset := range assetData.Data {
+		if asset.Symbol == symbol {
+			return asset.ID, nil
+		}
+	}
+	return "", fmt.Errorf("asset not found for symbol %s", symbol)
+}
+
+// getCoinCapHistory fetches daily prices for symbol between from and to
+func getCoinCapHistory(ctx context.Context, symbol string, from, to time.Time) ([]pricePoint, error) {
+	id, err := getCoinCapAssetID(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("interval", "d1")
+	query.Set("start", strconv.FormatInt(from.UnixMilli(), 10))
+	query.Set("end", strconv.FormatInt(to.UnixMilli(), 10))
+	var history coinCapHistory
+	err = coinCapGet(ctx, coinCapURL("/assets/"+url.PathEscape(id)+"/history?"+query.Encode()), &history)
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]pricePoint, 0, len(history.Data))
+	for _, h := range history.Data {
+		if h.PriceUsd == "" {
+			// No trades in that interval
+			continue
+		}
+		price, err := strconv.ParseFloat(h.PriceUsd, 64)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, pricePoint{Time: time.UnixMilli(h.Time).UTC(), Price: price})
+	}
+	return points, nil
+}
+
+// retryAfter parses a Retry-After header, given either in seconds or as an
+// HTTP date, into a delay no longer than rateLimitMaxDelay
+func retryAfter(header string, now time.Time) time.Duration {
+	delay := rateLimitDefaultDelay
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		delay = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(header); err == nil {
+		delay = at.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+	}
+	return min(delay, rateLimitMaxDelay)
+}
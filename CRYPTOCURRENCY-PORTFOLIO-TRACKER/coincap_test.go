@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// coinCapStub is a fake CoinCap API serving an asset list and, once rates
+// is set, a rate list, counting the requests made for each path
+type coinCapStub struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	prices   map[string]string // priceUsd by symbol
+	rates    map[string]string // rateUsd by fiat currency symbol
+	requests map[string]int
+}
+
+// newCoinCapStub starts a stub listing prices and points cfg, which the
+// test must have set, at it. The price cache is emptied before and after.
+func newCoinCapStub(t testing.TB, prices map[string]string) *coinCapStub {
+	t.Helper()
+	s := &coinCapStub{prices: prices, requests: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	cfg.APIBaseURL = s.URL
+	clearCaches()
+	t.Cleanup(func() {
+		s.Close()
+		clearCaches()
+	})
+	return s
+}
+
+func (s *coinCapStub) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[r.URL.Path]++
+	switch r.URL.Path {
+	case "/assets":
+	case "/rates":
+		s.serveRates(w)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	type asset struct {
+		ID       string `json:"id"`
+		Symbol   string `json:"symbol"`
+		PriceUsd string `json:"priceUsd"`
+	}
+	list := struct {
+		Data      []asset `json:"data"`
+		Timestamp int64   `json:"timestamp"`
+	}{Timestamp: 1760000000000}
+	for symbol, price := range s.prices {
+		list.Data = append(list.Data, asset{ID: symbol, Symbol: symbol, PriceUsd: price})
+	}
+	sort.Slice(list.Data, func(i, j int) bool { return list.Data[i].Symbol < list.Data[j].Symbol })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (s *coinCapStub) serveRates(w http.ResponseWriter) {
+	type rate struct {
+		ID      string `json:"id"`
+		Symbol  string `json:"symbol"`
+		Type    string `json:"type"`
+		RateUsd string `json:"rateUsd"`
+	}
+	var list struct {
+		Data []rate `json:"data"`
+	}
+	for symbol, usd := range s.rates {
+		list.Data = append(list.Data, rate{ID: strings.ToLower(symbol), Symbol: symbol, Type: "fiat", RateUsd: usd})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// calls returns how many requests path has had
+func (s *coinCapStub) calls(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func TestPriceCacheFetchesOnceWithinTTL(t *testing.T) {
+	useConfig(t, &config{})
+	stub := newCoinCapStub(t, map[string]string{"BTC": "65000", "ETH": "3000", "SOL": "150"})
+
+	var group sync.WaitGroup
+	for _, symbol := range []string{"BTC", "ETH", "SOL", "BTC", "eth"} {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			if _, err := getCoinCapPrice(context.Background(), symbol); err != nil {
+				t.Errorf("%s: %v", symbol, err)
+			}
+		}()
+	}
+	group.Wait()
+
+	if n := stub.calls("/assets"); n != 1 {
+		t.Errorf("asset list fetched %d times, want 1", n)
+	}
+	price, err := getCoinCapPrice(context.Background(), "ETH")
+	if err != nil || price != 3000 {
+		t.Errorf("cached ETH price = %v, %v; want 3000", price, err)
+	}
+}
+
+func TestPriceCacheRefetchesWhenDisabled(t *testing.T) {
+	useConfig(t, &config{PriceCacheSeconds: -1})
+	stub := newCoinCapStub(t, map[string]string{"BTC": "65000"})
+
+	for i := 0; i < 3; i++ {
+		if _, err := getCoinCapPrice(context.Background(), "BTC"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := stub.calls("/assets"); n != 3 {
+		t.Errorf("asset list fetched %d times, want 3", n)
+	}
+}
+
+// hungCoinCap starts a CoinCap that never answers, pointing cfg at it
+func hungCoinCap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	t.Cleanup(server.Close)
+	cfg.APIBaseURL = server.URL
+	clearCaches()
+	t.Cleanup(func() { clearCaches() })
+}
+
+func TestCoinCapRequestTimesOut(t *testing.T) {
+	useConfig(t, &config{})
+	hungCoinCap(t)
+	saved := coinCapClient.Timeout
+	coinCapClient.Timeout = 100 * time.Millisecond
+	t.Cleanup(func() { coinCapClient.Timeout = saved })
+
+	start := time.Now()
+	_, err := getCoinCapPrice(context.Background(), "BTC")
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %v to time out", elapsed)
+	}
+}
+
+func TestCoinCapRequestStopsWithContext(t *testing.T) {
+	useConfig(t, &config{})
+	hungCoinCap(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := getCoinCapPrice(ctx, "BTC"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context's deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %v to stop", elapsed)
+	}
+}
+
+func TestHTTPTimeoutDefault(t *testing.T) {
+	if got := (&config{}).httpTimeout(); got != defaultHTTPTimeoutSeconds*time.Second {
+		t.Errorf("default = %v", got)
+	}
+	if got := (&config{HTTPTimeoutSeconds: 3}).httpTimeout(); got != 3*time.Second {
+		t.Errorf("configured = %v, want 3s", got)
+	}
+}
+
+func TestAssetWithoutPriceIsSkipped(t *testing.T) {
+	useConfig(t, &config{})
+	newCoinCapStub(t, map[string]string{"BTC": "65000", "ILLQ": ""})
+	useProvider(t, CoinCapProvider{})
+
+	_, err := getCoinCapPrice(context.Background(), "ILLQ")
+	if !errors.Is(err, ErrPriceUnavailable) || err.Error() != "no price available for symbol ILLQ" {
+		t.Errorf("err = %v, want ErrPriceUnavailable naming ILLQ", err)
+	}
+	v := valueHoldings(context.Background(), map[string]float64{"BTC": 1, "ILLQ": 100})
+	if v.TotalValue != 65000 || len(v.Unpriced) != 1 || v.Unpriced[0] != "ILLQ" || len(v.FailedSymbols) != 0 {
+		t.Errorf("got total %v, unpriced %v, failed %v; want 65000 with ILLQ skipped as unpriced", v.TotalValue, v.Unpriced, v.FailedSymbols)
+	}
+}
+
+func TestAssetWithNullPrice(t *testing.T) {
+	var assets coinCapAsset
+	if err := json.Unmarshal([]byte(`{"data": [{"id": "illiquid", "symbol": "ILLQ", "priceUsd": null}]}`), &assets); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := assets.quote("ILLQ"); !errors.Is(err, ErrPriceUnavailable) {
+		t.Errorf("err = %v, want ErrPriceUnavailable", err)
+	}
+	if _, err := parseAssetPrice("BAD", "12abc"); err == nil || errors.Is(err, ErrPriceUnavailable) {
+		t.Errorf("malformed price: err = %v, want a parse error", err)
+	}
+}
+
+func TestCoinCapErrorStatus(t *testing.T) {
+	useConfig(t, &config{})
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Query().Get("fail") == "500" {
+			http.Error(w, "database exploded", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	var v coinCapAsset
+	err := coinCapGet(context.Background(), server.URL+"/assets", &v)
+	if err == nil || err.Error() != "CoinCap rate limit exceeded: slow down" {
+		t.Errorf("429: err = %v", err)
+	}
+	if requests != rateLimitRetries+1 {
+		t.Errorf("429: %d requests, want %d", requests, rateLimitRetries+1)
+	}
+
+	err = coinCapGet(context.Background(), server.URL+"/assets?fail=500", &v)
+	if err == nil || err.Error() != "CoinCap responded 500 Internal Server Error: database exploded" {
+		t.Errorf("500: err = %v", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", rateLimitDefaultDelay},
+		{"7", 7 * time.Second},
+		{"3600", rateLimitMaxDelay},
+		{now.Add(20 * time.Second).Format(http.TimeFormat), 20 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"soon", rateLimitDefaultDelay},
+	}
+	for _, c := range cases {
+		if got := retryAfter(c.header, now); got != c.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", c.header, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultCoinGeckoBaseURL = "https://api.coingecko.com/api/v3"
+
+// coinGeckoConfig configures the "coingecko" price provider
+type coinGeckoConfig struct {
+	// BaseURL is the API root, the public API if empty
+	BaseURL string `json:"base_url,omitempty"`
+	// APIKey is sent as a demo API key when set
+	APIKey string `json:"api_key,omitempty"`
+	// IDs pin symbols to CoinGecko coin ids, e.g. {"BTC": "bitcoin"}, for
+	// symbols several coins share
+	IDs map[string]string `json:"ids,omitempty"`
+}
+
+// CoinGeckoProvider prices symbols with CoinGecko's /simple/price. CoinGecko
+// identifies coins by id, so symbols are mapped through /coins/list, which is
+// fetched once.
+type CoinGeckoProvider struct {
+	BaseURL string
+	APIKey  string
+	IDs     map[string]string // Overrides of the symbol to id mapping
+	Client  *http.Client
+
+	mu           sync.Mutex
+	ids          map[string]string // Symbol to id, once /coins/list is fetched
+	limitedUntil time.Time         // No requests until then after a 429
+}
+
+// newCoinGeckoProvider returns a provider configured by c
+func newCoinGeckoProvider(c coinGeckoConfig) *CoinGeckoProvider {
+	p := &CoinGeckoProvider{
+		BaseURL: strings.TrimRight(c.BaseURL, "/"),
+		APIKey:  c.APIKey,
+		IDs:     make(map[string]string, len(c.IDs)),
+		Client:  coinCapClient,
+	}
+	if p.BaseURL == "" {
+		p.BaseURL = defaultCoinGeckoBaseURL
+	}
+	for symbol, id := range c.IDs {
+		p.IDs[normalizeSymbol(symbol)] = id
+	}
+	return p
+}
+
+// coinGeckoCoin is an entry of /coins/list
+type coinGeckoCoin struct {
+	ID     string `json:"id"`
+	Symbol string `json:"symbol"`
+	Name   string `json:"name"`
+}
+
+func (p *CoinGeckoProvider) Price(ctx context.Context, symbol string) (float64, error) {
+	prices, err := p.Prices(ctx, []string{symbol})
+	if err != nil {
+		var failures PriceErrors
+		if errors.As(err, &failures) {
+			return 0, failures[normalizeSymbol(symbol)]
+		}
+		return 0, err
+	}
+	return prices[normalizeSymbol(symbol)], nil
+}
+
+func (p *CoinGeckoProvider) Prices(ctx context.Context, symbols []string) (map[string]float64, error) {
+	quotes, err := p.Quotes(ctx, symbols)
+	prices := make(map[string]float64, len(quotes))
+	for symbol, q := range quotes {
+		prices[symbol] = q.Price
+	}
+	return prices, err
+}
+
+// Quotes prices every symbol with a single /simple/price request
+func (p *CoinGeckoProvider) Quotes(ctx context.Context, symbols []string) (map[string]priceQuote, error) {
+	ids, err := p.coinIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	failures := PriceErrors{}
+	bySymbol := make(map[string]string, len(symbols))
+	var wanted []string
+	for _, symbol := range symbols {
+		symbol = normalizeSymbol(symbol)
+		id, ok := ids[symbol]
+		if !ok {
+			failures[symbol] = fmt.Errorf("%w %s on CoinGecko", ErrSymbolNotFound, symbol)
+			continue
+		}
+		bySymbol[symbol] = id
+		wanted = append(wanted, id)
+	}
+
+	quotes := make(map[string]priceQuote, len(bySymbol))
+	if len(wanted) > 0 {
+		query := url.Values{}
+		query.Set("ids", strings.Join(wanted, ","))
+		query.Set("vs_currencies", "usd")
+		query.Set("include_last_updated_at", "true")
+		var prices map[string]struct {
+			USD           *float64 `json:"usd"`
+			LastUpdatedAt int64    `json:"last_updated_at"` // Unix seconds
+		}
+		if err := p.get(ctx, "/simple/price?"+query.Encode(), &prices); err != nil {
+			return nil, err
+		}
+		for symbol, id := range bySymbol {
+			price, ok := prices[id]
+			if !ok || price.USD == nil {
+				failures[symbol] = fmt.Errorf("%w for symbol %s", ErrPriceUnavailable, symbol)
+				continue
+			}
+			observedAt := time.Now().UTC()
+			if price.LastUpdatedAt > 0 {
+				observedAt = time.Unix(price.LastUpdatedAt, 0).UTC()
+			}
+			quotes[symbol] = priceQuote{Provider: "coingecko", Price: *price.USD, ObservedAt: observedAt}
+		}
+	}
+
+	if len(failures) > 0 {
+		return quotes, failures
+	}
+	return quotes, nil
+}
+
+// coinIDs returns the symbol to id mapping, fetching /coins/list the first
+// time. A failed fetch is retried on the next call.
+func (p *CoinGeckoProvider) coinIDs(ctx context.Context) (map[string]string, error) {
+	p.mu.Lock()
+	ids := p.ids
+	p.mu.Unlock()
+	if ids != nil {
+		return ids, nil
+	}
+
+	var coins []coinGeckoCoin
+	if err := p.get(ctx, "/coins/list", &coins); err != nil {
+		return nil, err
+	}
+	ids = coinGeckoIDs(coins)
+	for symbol, id := range p.IDs {
+		ids[symbol] = id
+	}
+
+	p.mu.Lock()
+	p.ids = ids
+	p.mu.Unlock()
+	return ids, nil
+}
+
+// coinGeckoIDs maps each symbol to a coin id. Many symbols are shared by
+// wrapped or copycat tokens, so the coin whose id is its own name (bitcoin
+// for "Bitcoin") is preferred, then the shortest id.
+func coinGeckoIDs(coins []coinGeckoCoin) map[string]string {
+	candidates := make(map[string][]coinGeckoCoin)
+	for _, coin := range coins {
+		symbol := normalizeSymbol(coin.Symbol)
+		candidates[symbol] = append(candidates[symbol], coin)
+	}
+
+	ids := make(map[string]string, len(candidates))
+	for symbol, coins := range candidates {
+		sort.Slice(coins, func(i, j int) bool {
+			ni, nj := coins[i].ID == coinGeckoSlug(coins[i].Name), coins[j].ID == coinGeckoSlug(coins[j].Name)
+			if ni != nj {
+				return ni
+			}
+			if len(coins[i].ID) != len(coins[j].ID) {
+				return len(coins[i].ID) < len(coins[j].ID)
+			}
+			return coins[i].ID < coins[j].ID
+		})
+		ids[symbol] = coins[0].ID
+	}
+	return ids
+}
+
+// coinGeckoSlug is the id CoinGecko usually derives from a coin's name
+func coinGeckoSlug(name string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "-")
+}
+
+// get fetches path from CoinGecko and decodes the JSON response into v.
+// After a 429 no requests are made until the Retry-After delay has passed,
+// so a rate-limited CoinGecko fails fast and a FallbackProvider moves on.
+func (p *CoinGeckoProvider) get(ctx context.Context, path string, v any) error {
+	p.mu.Lock()
+	limitedUntil := p.limitedUntil
+	p.mu.Unlock()
+	if wait := time.Until(limitedUntil); wait > 0 {
+		return fmt.Errorf("CoinGecko rate limit exceeded, retrying in %s", wait.Round(time.Second))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.APIKey != "" {
+		req.Header.Set("x-cg-demo-api-key", p.APIKey)
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, coinCapErrorBodyLimit))
+		if resp.StatusCode == http.StatusTooManyRequests {
+			delay := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+			p.mu.Lock()
+			p.limitedUntil = time.Now().Add(delay)
+			p.mu.Unlock()
+			slog.Warn("CoinGecko rate limited", "component", "coingecko", "delay", delay.String())
+			return fmt.Errorf("CoinGecko rate limit exceeded: %s", bytes.TrimSpace(body))
+		}
+		return fmt.Errorf("CoinGecko responded %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
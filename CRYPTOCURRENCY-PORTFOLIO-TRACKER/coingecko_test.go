@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// coinGeckoStub is a fake CoinGecko API with canned responses, counting the
+// requests made for each path
+type coinGeckoStub struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	limited  bool // Answer everything with 429
+	apiKeys  []string
+	requests map[string]int
+}
+
+const (
+	cannedCoinList = `[
+		{"id": "wrapped-bitcoin", "symbol": "btc", "name": "Wrapped Bitcoin"},
+		{"id": "bitcoin", "symbol": "btc", "name": "Bitcoin"},
+		{"id": "ethereum", "symbol": "eth", "name": "Ethereum"},
+		{"id": "delisted-coin", "symbol": "gone", "name": "Delisted Coin"}
+	]`
+	cannedSimplePrice = `{
+		"bitcoin": {"usd": 65000, "last_updated_at": 1760000000},
+		"ethereum": {"usd": 3000.5, "last_updated_at": 1760000000},
+		"wrapped-bitcoin": {"usd": 64000, "last_updated_at": 1760000000}
+	}`
+)
+
+func newCoinGeckoStub(t *testing.T) (*coinGeckoStub, *CoinGeckoProvider) {
+	t.Helper()
+	s := &coinGeckoStub{requests: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s, newCoinGeckoProvider(coinGeckoConfig{BaseURL: s.URL + "/", APIKey: "demo-key"})
+}
+
+func (s *coinGeckoStub) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[r.URL.Path]++
+	s.apiKeys = append(s.apiKeys, r.Header.Get("x-cg-demo-api-key"))
+	if s.limited {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, `{"status": {"error_code": 429}}`, http.StatusTooManyRequests)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/coins/list":
+		w.Write([]byte(cannedCoinList))
+	case "/simple/price":
+		if r.URL.Query().Get("vs_currencies") != "usd" {
+			http.Error(w, "vs_currencies missing", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(cannedSimplePrice))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// calls returns how many requests path has had
+func (s *coinGeckoStub) calls(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func TestCoinGeckoMapsSymbolsToIDs(t *testing.T) {
+	stub, p := newCoinGeckoStub(t)
+
+	// bitcoin, not wrapped-bitcoin, is BTC
+	price, err := p.Price(context.Background(), "btc")
+	if err != nil || price != 65000 {
+		t.Fatalf("Price(btc) = %v, %v; want 65000", price, err)
+	}
+	quotes, err := p.Quotes(context.Background(), []string{"BTC", "ETH"})
+	if err != nil || quotes["ETH"].Price != 3000.5 || quotes["ETH"].Provider != "coingecko" || quotes["ETH"].ObservedAt.Unix() != 1760000000 {
+		t.Errorf("Quotes = %+v, %v", quotes, err)
+	}
+	if n := stub.calls("/coins/list"); n != 1 {
+		t.Errorf("/coins/list fetched %d times, want once", n)
+	}
+	if n := stub.calls("/simple/price"); n != 2 {
+		t.Errorf("/simple/price fetched %d times, want once per call", n)
+	}
+	for _, key := range stub.apiKeys {
+		if key != "demo-key" {
+			t.Errorf("API key header = %q", key)
+		}
+	}
+
+	// Configured ids override the mapping
+	pinned := newCoinGeckoProvider(coinGeckoConfig{BaseURL: stub.URL, IDs: map[string]string{"btc": "wrapped-bitcoin"}})
+	if price, err := pinned.Price(context.Background(), "BTC"); err != nil || price != 64000 {
+		t.Errorf("pinned Price(BTC) = %v, %v; want 64000", price, err)
+	}
+}
+
+func TestCoinGeckoMissingCoin(t *testing.T) {
+	_, p := newCoinGeckoStub(t)
+
+	prices, err := p.Prices(context.Background(), []string{"BTC", "NOPE", "GONE"})
+	var failures PriceErrors
+	if !errors.As(err, &failures) || len(failures) != 2 {
+		t.Fatalf("err = %v, want NOPE and GONE failing", err)
+	}
+	if !errors.Is(failures["NOPE"], ErrSymbolNotFound) {
+		t.Errorf("unlisted coin: err = %v, want ErrSymbolNotFound", failures["NOPE"])
+	}
+	if !errors.Is(failures["GONE"], ErrPriceUnavailable) {
+		t.Errorf("listed coin without a price: err = %v, want ErrPriceUnavailable", failures["GONE"])
+	}
+	if len(prices) != 1 || prices["BTC"] != 65000 {
+		t.Errorf("prices = %v, want BTC alone", prices)
+	}
+	if _, err := p.Price(context.Background(), "NOPE"); !errors.Is(err, ErrSymbolNotFound) {
+		t.Errorf("Price(NOPE): err = %v, want ErrSymbolNotFound", err)
+	}
+}
+
+func TestCoinGeckoRateLimited(t *testing.T) {
+	stub, p := newCoinGeckoStub(t)
+	stub.limited = true
+
+	_, err := p.Price(context.Background(), "BTC")
+	if err == nil || !strings.Contains(err.Error(), "rate limit") {
+		t.Fatalf("err = %v, want a rate limit error", err)
+	}
+	// Until Retry-After passes nothing more is sent
+	if _, err := p.Price(context.Background(), "BTC"); err == nil || !strings.Contains(err.Error(), "retrying in") {
+		t.Errorf("while limited: err = %v", err)
+	}
+	if n := stub.calls("/coins/list"); n != 1 {
+		t.Errorf("/coins/list requested %d times while limited, want 1", n)
+	}
+}
+
+func TestCoinGeckoSelectableFromConfig(t *testing.T) {
+	p, err := newPriceProvider(&config{CoinGecko: coinGeckoConfig{BaseURL: "https://gecko.example.com/api/"}}, "coingecko")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gecko, ok := p.(*CoinGeckoProvider)
+	if !ok || gecko.BaseURL != "https://gecko.example.com/api" || providerName(p) != "coingecko" {
+		t.Errorf("got %T %+v, want a CoinGeckoProvider at the configured URL", p, p)
+	}
+	if p, _ := newPriceProvider(&config{}, "coingecko"); p.(*CoinGeckoProvider).BaseURL != defaultCoinGeckoBaseURL {
+		t.Errorf("default base URL = %q", p.(*CoinGeckoProvider).BaseURL)
+	}
+	if _, err := newPriceProvider(&config{}, "kraken"); err == nil {
+		t.Error("unknown provider accepted")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// mergeTokenFiles appends the tokens from every *.json file in TokenDir,
+// in file name order, so a large watchlist can be split by category. A
+// symbol defined more than once, in any file, is an error. Symbols are
+// normalized as they are read, so duplicates differing in case are caught.
+func (c *config) mergeTokenFiles(configFile string) error {
+	// Track where each symbol came from to report duplicates usefully
+	sources := make(map[string]string, len(c.Tokens))
+	for i := range c.Tokens {
+		c.Tokens[i].Symbol = normalizeSymbol(c.Tokens[i].Symbol)
+		token := c.Tokens[i]
+		if prev, ok := sources[token.Symbol]; ok {
+			return fmt.Errorf("token %s is defined twice in %s", token.Symbol, prev)
+		}
+		sources[token.Symbol] = configFile
+	}
+	if c.TokenDir == "" {
+		return nil
+	}
+
+	dir := c.TokenDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(configFile), dir)
+	}
+	// Glob returns the matches sorted, which keeps the merge deterministic
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var part struct {
+			Tokens []tokenConfig `json:"tokens"`
+		}
+		if err := json.Unmarshal(data, &part); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		for _, token := range part.Tokens {
+			token.Symbol = normalizeSymbol(token.Symbol)
+			if prev, ok := sources[token.Symbol]; ok {
+				return fmt.Errorf("token %s is defined in both %s and %s", token.Symbol, prev, file)
+			}
+			sources[token.Symbol] = file
+			c.Tokens = append(c.Tokens, token)
+		}
+	}
+	return nil
+}
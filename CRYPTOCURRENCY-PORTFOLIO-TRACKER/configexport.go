@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// handleConfigExport returns the effective running config, including any
+// watchlist changes made at runtime, in the same format as config.json.
+// Secrets are blanked, so they have to be filled back in before the
+// export can be loaded.
+func handleConfigExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Only the tokens change after startup, so copy the rest as is. The
+	// lock keeps /config/thresholds from swapping them mid-copy.
+	cfgMu.RLock()
+	exported := *cfg
+	exported.Tokens = make([]tokenConfig, len(cfg.Tokens))
+	for i, token := range cfg.Tokens {
+		exported.Tokens[i] = token.clone()
+	}
+	cfgMu.RUnlock()
+	// The tokens from TokenDir are already merged in; keeping the directory
+	// would define them twice when the export is loaded back
+	exported.TokenDir = ""
+	redactSecrets(&exported)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="config.json"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(exported)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
+
+// redactSecrets blanks the API keys, webhook URLs and SMTP passwords in c,
+// copying the notifiers so the running config keeps its own
+func redactSecrets(c *config) {
+	c.Auth.APIKey = ""
+	c.CoinGecko.APIKey = ""
+	c.Notifiers = append([]notifierConfig(nil), c.Notifiers...)
+	for i := range c.Notifiers {
+		c.Notifiers[i].URL = ""
+		c.Notifiers[i].Password = ""
+	}
+}
+
+// clone returns a copy of t that shares nothing with it
+func (t tokenConfig) clone() tokenConfig {
+	if t.UpperThreshold != nil {
+		upper := *t.UpperThreshold
+		t.UpperThreshold = &upper
+	}
+	if t.LowerThreshold != nil {
+		lower := *t.LowerThreshold
+		t.LowerThreshold = &lower
+	}
+	if t.MarketHours != nil {
+		hours := *t.MarketHours
+		hours.Days = append([]string(nil), hours.Days...)
+		t.MarketHours = &hours
+	}
+	return t
+}
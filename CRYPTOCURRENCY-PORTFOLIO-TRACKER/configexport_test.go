@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConfigExportRedactsSecrets(t *testing.T) {
+	useConfig(t, &config{
+		Auth:      authConfig{APIKey: "api-secret"},
+		CoinGecko: coinGeckoConfig{APIKey: "gecko-secret"},
+		Notifiers: []notifierConfig{
+			{Type: notifierWebhook, URL: "https://hooks.example.com/webhook-secret"},
+			{Type: notifierEmail, smtpConfig: smtpConfig{Host: "smtp.example.com", Username: "alerts", Password: "smtp-secret"}},
+		},
+		Tokens: []tokenConfig{{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(60000)}},
+	})
+
+	rec := serve(http.HandlerFunc(handleConfigExport), httptest.NewRequest(http.MethodGet, "/config/export", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	for _, secret := range []string{"api-secret", "gecko-secret", "webhook-secret", "smtp-secret"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("export leaks %s: %s", secret, body)
+		}
+	}
+	if !strings.Contains(body, `"smtp.example.com"`) || !strings.Contains(body, `"BTC"`) {
+		t.Errorf("export is missing non-secret settings: %s", body)
+	}
+	if cfg.Auth.APIKey != "api-secret" || cfg.Notifiers[0].URL == "" || cfg.Notifiers[1].Password != "smtp-secret" {
+		t.Error("redacting the export changed the running config")
+	}
+}
+
+// TestConfigExportDuringThresholdUpdates is meant for -race: exports must
+// not read the tokens while /config/thresholds replaces them
+func TestConfigExportDuringThresholdUpdates(t *testing.T) {
+	s, _ := newTestServer(t, &fakeStore{}, fakePrices{})
+	cfg.Tokens = []tokenConfig{{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(60000)}}
+	stopMonitors(t)
+
+	var group sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		group.Add(2)
+		go func() {
+			defer group.Done()
+			body := bytes.NewBufferString(fmt.Sprintf(`{"BTC": {"threshold": %d}}`, 60000+i))
+			req := httptest.NewRequest(http.MethodPost, "/config/thresholds", body)
+			if rec := serve(http.HandlerFunc(s.handleBulkThresholds), req); rec.Code != http.StatusOK {
+				t.Errorf("update status = %d, body %s", rec.Code, rec.Body)
+			}
+		}()
+		go func() {
+			defer group.Done()
+			rec := serve(http.HandlerFunc(handleConfigExport), httptest.NewRequest(http.MethodGet, "/config/export", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("export status = %d", rec.Code)
+			}
+		}()
+	}
+	group.Wait()
+}
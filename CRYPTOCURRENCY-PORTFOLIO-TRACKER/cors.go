@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// defaultCORSMethods are allowed cross-origin when cors.allowed_methods is
+// empty
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete}
+
+// corsConfig lets browser frontends on other origins call the API. With no
+// allowed origins no CORS headers are sent, so only same-origin pages can
+// read responses.
+type corsConfig struct {
+	// AllowedOrigins are origins such as "https://dash.example.com", or "*"
+	// for any
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+	AllowedHeaders []string `json:"allowed_headers,omitempty"` // Content-Type and the auth headers if empty
+}
+
+// validate upper-cases the allowed methods and checks the origins look like
+// scheme://host
+func (c *corsConfig) validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin != "*" && (!strings.Contains(origin, "://") || strings.HasSuffix(origin, "/")) {
+			return fmt.Errorf("cors: invalid origin %q", origin)
+		}
+	}
+	for i, method := range c.AllowedMethods {
+		c.AllowedMethods[i] = strings.ToUpper(strings.TrimSpace(method))
+		if c.AllowedMethods[i] == "" {
+			return fmt.Errorf("cors: empty method")
+		}
+	}
+	return nil
+}
+
+// allows reports whether responses may be shared with origin
+func (c corsConfig) allows(origin string) bool {
+	return slices.Contains(c.AllowedOrigins, "*") || slices.Contains(c.AllowedOrigins, origin)
+}
+
+func (c corsConfig) methods() []string {
+	if len(c.AllowedMethods) > 0 {
+		return c.AllowedMethods
+	}
+	return defaultCORSMethods
+}
+
+func (c corsConfig) headers() []string {
+	if len(c.AllowedHeaders) > 0 {
+		return c.AllowedHeaders
+	}
+	return []string{"Content-Type", "Authorization", "X-API-Key"}
+}
+
+// cors adds Access-Control-* headers for allowed origins and answers
+// preflight requests itself, so they never reach a handler or the database
+func cors(c corsConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowed := c.allows(origin)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		if allowed && slices.Contains(c.methods(), r.Header.Get("Access-Control-Request-Method")) {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.methods(), ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.headers(), ", "))
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
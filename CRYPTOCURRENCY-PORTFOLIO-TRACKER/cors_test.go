@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// corsRequest sends a request from origin through cors with c, to a
+// handler that answers 200
+func corsRequest(c corsConfig, method, origin string, headers map[string]string) (*httptest.ResponseRecorder, bool) {
+	reached := false
+	handler := cors(c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	req := httptest.NewRequest(method, "/portfolio/value", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return serve(handler, req), reached
+}
+
+func TestCORSAllowedOrigin(t *testing.T) {
+	c := corsConfig{AllowedOrigins: []string{"https://dash.example.com"}}
+	rec, reached := corsRequest(c, http.MethodGet, "https://dash.example.com", nil)
+	if !reached || rec.Header().Get("Access-Control-Allow-Origin") != "https://dash.example.com" {
+		t.Errorf("reached %v, headers %v; want the origin allowed", reached, rec.Header())
+	}
+	if rec.Header().Get("Vary") != "Origin" {
+		t.Errorf("Vary = %q, want Origin", rec.Header().Get("Vary"))
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	for _, c := range []corsConfig{{}, {AllowedOrigins: []string{"https://dash.example.com"}}} {
+		rec, reached := corsRequest(c, http.MethodGet, "https://evil.example.com", nil)
+		if !reached {
+			t.Errorf("%+v: request not passed on", c)
+		}
+		for _, h := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "Access-Control-Allow-Headers"} {
+			if v := rec.Header().Get(h); v != "" {
+				t.Errorf("%+v: %s = %q, want none", c, h, v)
+			}
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	c := corsConfig{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET", "POST"}}
+	preflight := map[string]string{"Access-Control-Request-Method": "POST"}
+
+	rec, reached := corsRequest(c, http.MethodOptions, "https://dash.example.com", preflight)
+	if reached {
+		t.Error("preflight reached the handler")
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":  "https://dash.example.com",
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Allow-Headers": "Content-Type, Authorization, X-API-Key",
+		"Access-Control-Max-Age":       "600",
+	}
+	for h, v := range want {
+		if got := rec.Header().Get(h); got != v {
+			t.Errorf("%s = %q, want %q", h, got, v)
+		}
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", rec.Code)
+	}
+
+	// A method that isn't allowed gets no allow headers
+	rec, _ = corsRequest(c, http.MethodOptions, "https://dash.example.com", map[string]string{"Access-Control-Request-Method": "DELETE"})
+	if v := rec.Header().Get("Access-Control-Allow-Methods"); v != "" {
+		t.Errorf("disallowed method: Access-Control-Allow-Methods = %q", v)
+	}
+}
+
+func TestCORSValidate(t *testing.T) {
+	for _, c := range []corsConfig{
+		{AllowedOrigins: []string{"dash.example.com"}},
+		{AllowedOrigins: []string{"https://dash.example.com/"}},
+		{AllowedMethods: []string{" "}},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("%+v: accepted", c)
+		}
+	}
+	c := corsConfig{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"get"}}
+	if err := c.validate(); err != nil || c.AllowedMethods[0] != "GET" {
+		t.Errorf("err = %v, methods %v; want GET", err, c.AllowedMethods)
+	}
+}
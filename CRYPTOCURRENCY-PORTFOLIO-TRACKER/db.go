@@ -0,0 +1,48 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+)
+
+// memoryDSN selects a database that lives only as long as the process
+const memoryDSN = ":memory:"
+
+// databaseDSN picks the SQLite DSN: DB_DSN if set, else the config's
+// database, else ./portfolio.db
+func databaseDSN(c *config) string {
+	if dsn := os.Getenv("DB_DSN"); dsn != "" {
+		return dsn
+	}
+	if c != nil && c.Database != "" {
+		return c.Database
+	}
+	return defaultDSN
+}
+
+// openDB opens and pings the SQLite database at dsn, which is passed to the
+// driver verbatim so options like _busy_timeout or _journal_mode can be set.
+// ":memory:" gives an ephemeral database shared by all of the pool's
+// connections.
+func openDB(dsn string) (*sql.DB, error) {
+	memory := dsn == memoryDSN || strings.HasPrefix(dsn, memoryDSN+"?")
+	if memory {
+		// Each connection to plain :memory: would get its own empty database
+		dsn = "file::memory:?cache=shared" + strings.Replace(strings.TrimPrefix(dsn, memoryDSN), "?", "&", 1)
+	}
+	handle, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if memory {
+		// The database is dropped when its last connection closes
+		handle.SetConnMaxIdleTime(0)
+		handle.SetConnMaxLifetime(0)
+	}
+	if err := handle.Ping(); err != nil {
+		handle.Close()
+		return nil, err
+	}
+	return handle, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInMemoryDatabaseRoundTrip(t *testing.T) {
+	handle, err := openDB(memoryDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handle.Close()
+	if err := migrate(handle); err != nil {
+		t.Fatal(err)
+	}
+	store := NewSQLStore(handle)
+
+	cost := 100.0
+	if err := store.AddHolding(Portfolio{UserID: 1, Symbol: "BTC", Amount: 1.5, CostBasis: &cost}); err != nil {
+		t.Fatal(err)
+	}
+	rows, total, err := store.ListPortfolio(1, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || rows[0].Symbol != "BTC" || rows[0].Amount != 1.5 || rows[0].CostBasis == nil || *rows[0].CostBasis != 100 {
+		t.Fatalf("got %+v of %d, want the BTC holding back", rows, total)
+	}
+
+	// Every connection in the pool sees the same database
+	conns := make([]int, 2)
+	for i := range conns {
+		conn, err := handle.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if err := conn.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM portfolio").Scan(&conns[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conns[0] != 1 || conns[1] != 1 {
+		t.Errorf("connections counted %v rows, want 1 each", conns)
+	}
+}
+
+func TestDatabaseDSN(t *testing.T) {
+	t.Setenv("DB_DSN", "")
+	if got := databaseDSN(&config{}); got != defaultDSN {
+		t.Errorf("default = %q, want %q", got, defaultDSN)
+	}
+	if got := databaseDSN(&config{Database: "/var/lib/tracker.db"}); got != "/var/lib/tracker.db" {
+		t.Errorf("from config = %q", got)
+	}
+	t.Setenv("DB_DSN", memoryDSN)
+	if got := databaseDSN(&config{Database: "/var/lib/tracker.db"}); got != memoryDSN {
+		t.Errorf("DB_DSN = %q, want it to override the config", got)
+	}
+}
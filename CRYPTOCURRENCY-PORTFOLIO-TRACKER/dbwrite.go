@@ -0,0 +1,45 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// writeMu serializes all database writes. SQLite allows a single writer,
+// and concurrent writers from monitors, jobs and handlers otherwise fail
+// with "database is locked". Reads don't take the lock.
+var writeMu sync.Mutex
+
+// execWriteOn runs a single write statement against handle while holding
+// the write lock
+func execWriteOn(handle *sql.DB, query string, args ...any) (sql.Result, error) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	return handle.Exec(query, args...)
+}
+
+// withTxOn runs fn in a transaction on handle while holding the write
+// lock. The transaction is committed if fn succeeds and rolled back if it
+// fails, so writes spanning several statements or tables apply all or
+// nothing.
+func withTxOn(handle *sql.DB, fn func(tx *sql.Tx) error) error {
+	return withWriteLock(func() error {
+		tx, err := handle.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// withWriteLock runs fn while holding the write lock, for writes that span
+// several statements or a transaction
+func withWriteLock(fn func() error) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	return fn()
+}
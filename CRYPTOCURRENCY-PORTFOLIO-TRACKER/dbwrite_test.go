@@ -0,0 +1,62 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestWithTxRollsBackOnError(t *testing.T) {
+	store := newSQLStore(t)
+	errFailed := errors.New("failed midway")
+
+	err := withTxOn(store.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("INSERT INTO portfolio (user_id, symbol, amount) VALUES (1, 'BTC', 1)"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO value_history (total_value, recorded_at) VALUES (100, CURRENT_TIMESTAMP)"); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("err = %v, want the callback's error", err)
+	}
+	for _, table := range []string{"portfolio", "value_history"} {
+		if got := columnValues(t, store.db, "SELECT COUNT(*) FROM "+table); got != "[0]" {
+			t.Errorf("%s has %s rows after the rollback, want 0", table, got)
+		}
+	}
+}
+
+func TestWithTxRollsBackOnFailedStatement(t *testing.T) {
+	store := newSQLStore(t)
+	err := withTxOn(store.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("INSERT INTO user_settings (user_id, preferred_currency) VALUES (1, 'EUR')"); err != nil {
+			return err
+		}
+		// Violates the primary key
+		_, err := tx.Exec("INSERT INTO user_settings (user_id, preferred_currency) VALUES (1, 'GBP')")
+		return err
+	})
+	if err == nil {
+		t.Fatal("duplicate key committed")
+	}
+	if got := columnValues(t, store.db, "SELECT COUNT(*) FROM user_settings"); got != "[0]" {
+		t.Errorf("user_settings has %s rows, want the first insert rolled back", got)
+	}
+}
+
+func TestWithTxCommits(t *testing.T) {
+	store := newSQLStore(t)
+	err := withTxOn(store.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO portfolio (user_id, symbol, amount) VALUES (1, 'BTC', 1), (1, 'ETH', 2)")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := columnValues(t, store.db, "SELECT symbol FROM portfolio ORDER BY id"); got != "[BTC ETH]" {
+		t.Errorf("portfolio = %s, want [BTC ETH]", got)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const maxDCAPurchases = 5000 // Guards against absurd ranges like daily over decades
+
+// dcaPurchase is one simulated recurring purchase
+type dcaPurchase struct {
+	Date          time.Time `json:"date"`
+	Price         float64   `json:"price"`
+	Units         float64   `json:"units"`
+	TotalUnits    float64   `json:"total_units"`
+	TotalInvested float64   `json:"total_invested"`
+	AverageCost   float64   `json:"average_cost"`
+	Value         float64   `json:"value"`
+}
+
+// handleDCA simulates investing a fixed USD amount in a symbol on a recurring
+// schedule over a historical date range
+func handleDCA(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	symbol := normalizeSymbol(query.Get("symbol"))
+	if symbol == "" {
+		http.Error(w, "Missing symbol", http.StatusBadRequest)
+		return
+	}
+	amount, err := strconv.ParseFloat(query.Get("amount"), 64)
+	if err != nil || amount <= 0 {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+	frequency := query.Get("frequency")
+	if frequency == "" {
+		frequency = "weekly"
+	}
+	step, ok := dcaStep(frequency)
+	if !ok {
+		http.Error(w, "Invalid frequency, expected daily, weekly or monthly", http.StatusBadRequest)
+		return
+	}
+	from, err := parseDateParam(query.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from date", http.StatusBadRequest)
+		return
+	}
+	to := time.Now().UTC()
+	if query.Get("to") != "" {
+		if to, err = parseDateParam(query.Get("to")); err != nil {
+			http.Error(w, "Invalid to date", http.StatusBadRequest)
+			return
+		}
+	}
+	if !to.After(from) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	history, err := getCoinCapHistory(r.Context(), symbol, from.Add(-24*time.Hour), to)
+	if err != nil {
+		serverError(w, r, "Error fetching price history", err)
+		return
+	}
+
+	purchases := simulateDCA(history, amount, from, to, step)
+	if len(purchases) == 0 {
+		http.Error(w, "No price history available for the range", http.StatusNotFound)
+		return
+	}
+	last := purchases[len(purchases)-1]
+
+	response := struct {
+		Symbol        string        `json:"symbol"`
+		Amount        float64       `json:"amount"`
+		Frequency     string        `json:"frequency"`
+		TotalInvested float64       `json:"total_invested"`
+		TotalUnits    float64       `json:"total_units"`
+		AverageCost   float64       `json:"average_cost"`
+		FinalValue    float64       `json:"final_value"`
+		Purchases     []dcaPurchase `json:"purchases"`
+	}{
+		Symbol:        symbol,
+		Amount:        amount,
+		Frequency:     frequency,
+		TotalInvested: last.TotalInvested,
+		TotalUnits:    last.TotalUnits,
+		AverageCost:   last.AverageCost,
+		FinalValue:    last.Value,
+		Purchases:     purchases,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
+
+// dcaStep returns the function advancing a purchase date by one period
+func dcaStep(frequency string) (func(time.Time) time.Time, bool) {
+	switch frequency {
+	case "daily":
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }, true
+	case "weekly":
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }, true
+	case "monthly":
+		return func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }, true
+	}
+	return nil, false
+}
+
+// simulateDCA buys amount worth of the asset at each scheduled date using
+// the most recent price at or before that date. history must be sorted by time.
+func simulateDCA(history []pricePoint, amount float64, from, to time.Time, step func(time.Time) time.Time) []dcaPurchase {
+	var purchases []dcaPurchase
+	var totalUnits, totalInvested float64
+	i := -1
+	for date := from; !date.After(to) && len(purchases) < maxDCAPurchases; date = step(date) {
+		for i+1 < len(history) && !history[i+1].Time.After(date) {
+			i++
+		}
+		if i < 0 || history[i].Price <= 0 {
+			continue
+		}
+		price := history[i].Price
+		units := amount / price
+		totalUnits += units
+		totalInvested += amount
+		purchases = append(purchases, dcaPurchase{
+			Date:          date,
+			Price:         price,
+			Units:         units,
+			TotalUnits:    totalUnits,
+			TotalInvested: totalInvested,
+			AverageCost:   totalInvested / totalUnits,
+			Value:         totalUnits * price,
+		})
+	}
+	return purchases
+}
+
+// parseDateParam parses a query parameter given as a date or an RFC 3339 timestamp
+func parseDateParam(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// decimal is an exact decimal number. It unmarshals from either a JSON
+// string ("0.000012") or a JSON number, parsing the literal text so values
+// like 0.1 don't pick up float64 representation error.
+type decimal struct {
+	r    *big.Rat
+	text string
+}
+
+// parseDecimal parses a plain or exponent-form decimal like "0.000012"
+func parseDecimal(s string) (decimal, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.Contains(s, "/") {
+		return decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	return decimal{r: r, text: s}, nil
+}
+
+// decimalFromFloat converts a float using its shortest round-trip form,
+// which recovers the decimal CoinCap sent for any price float64 can
+// represent to 15 significant digits
+func decimalFromFloat(f float64) decimal {
+	d, _ := parseDecimal(strconv.FormatFloat(f, 'g', -1, 64))
+	return d
+}
+
+func (d decimal) rat() *big.Rat {
+	if d.r == nil {
+		return new(big.Rat)
+	}
+	return d.r
+}
+
+// Cmp compares d and o, returning -1, 0 or +1
+func (d decimal) Cmp(o decimal) int {
+	return d.rat().Cmp(o.rat())
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of d
+func (d decimal) Sign() int {
+	return d.rat().Sign()
+}
+
+// IsZero reports whether d is zero or unset
+func (d decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+// Float64 returns the nearest float64 to d
+func (d decimal) Float64() float64 {
+	f, _ := d.rat().Float64()
+	return f
+}
+
+// String returns the decimal as it was written
+func (d decimal) String() string {
+	if d.r == nil {
+		return "0"
+	}
+	return d.text
+}
+
+func (d *decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		*d = decimal{}
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	parsed, err := parseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalJSON writes the decimal as a string so it round-trips exactly
+func (d decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSMTPPort = 587
+	smtpTimeout     = 30 * time.Second
+	emailSubject    = notificationTitle + " alert"
+)
+
+// SMTP TLS modes
+const (
+	smtpTLSStartTLS = "starttls" // Upgrade a plain connection, required
+	smtpTLSImplicit = "tls"      // TLS from the start, usually port 465
+	smtpTLSNone     = "none"     // Plain text, for local relays only
+)
+
+// smtpConfig configures the "email" notifier. It is embedded in
+// notifierConfig, so its keys sit beside "type".
+type smtpConfig struct {
+	Host     string   `json:"host,omitempty"`
+	Port     int      `json:"port,omitempty"`     // 587 if unset
+	Username string   `json:"username,omitempty"` // Enables PLAIN auth when set
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	TLS      string   `json:"tls,omitempty"` // "starttls" (default), "tls" or "none"
+}
+
+// newEmailNotifier validates c and builds an EmailNotifier from it
+func newEmailNotifier(c smtpConfig) (*EmailNotifier, error) {
+	if c.Host == "" {
+		return nil, fmt.Errorf("email notifier requires a host")
+	}
+	if c.From == "" {
+		return nil, fmt.Errorf("email notifier requires a from address")
+	}
+	if len(c.To) == 0 {
+		return nil, fmt.Errorf("email notifier requires at least one to address")
+	}
+	switch c.TLS {
+	case "":
+		c.TLS = smtpTLSStartTLS
+	case smtpTLSStartTLS, smtpTLSImplicit, smtpTLSNone:
+	default:
+		return nil, fmt.Errorf("invalid email tls mode %q", c.TLS)
+	}
+	if c.Port == 0 {
+		c.Port = defaultSMTPPort
+	}
+	return &EmailNotifier{smtpConfig: c}, nil
+}
+
+// EmailNotifier mails alerts to a recipient list over SMTP
+type EmailNotifier struct {
+	smtpConfig
+}
+
+func (n *EmailNotifier) Notify(msg string) error {
+	c, err := n.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if n.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.Username, n.Password, n.Host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	for _, to := range n.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("smtp recipient %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(n.message(msg, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// dial connects to the server and secures the connection as configured
+func (n *EmailNotifier) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+	tlsConfig := &tls.Config{ServerName: n.Host}
+
+	var conn net.Conn
+	var err error
+	if n.TLS == smtpTLSImplicit {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: smtpTimeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, smtpTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Bounds the whole exchange so a stalled server can't hold up alerts
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	c, err := smtp.NewClient(conn, n.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if n.TLS == smtpTLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			c.Close()
+			return nil, fmt.Errorf("smtp server %s does not support STARTTLS", addr)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// message builds the RFC 5322 message for an alert
+func (n *EmailNotifier) message(msg string, now time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", n.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", emailSubject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is a plain-text SMTP server accepting one message
+type fakeSMTP struct {
+	net.Listener
+	recipients []string
+	data       chan string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{Listener: l, data: make(chan string, 1)}
+	t.Cleanup(func() { l.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTP) serve() {
+	conn, err := s.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 fake")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			s.recipients = append(s.recipients, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 Go ahead")
+			var b strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				b.WriteString(line)
+			}
+			s.data <- b.String()
+			reply("250 Queued")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestEmailNotifierSendsAlert(t *testing.T) {
+	server := newFakeSMTP(t)
+	n, err := newNotifier(notifierConfig{Type: notifierEmail, smtpConfig: smtpConfig{
+		Host: "127.0.0.1",
+		Port: server.Addr().(*net.TCPAddr).Port,
+		From: "tracker@example.com",
+		To:   []string{"alice@example.com", "bob@example.com"},
+		TLS:  smtpTLSNone,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify("Bitcoin (BTC) crossed above 100000.00: now 100250.00"); err != nil {
+		t.Fatal(err)
+	}
+
+	var data string
+	select {
+	case data = <-server.data:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("Subject"); got != "GoCryptoTracker alert" {
+		t.Errorf("Subject = %q", got)
+	}
+	if got := msg.Header.Get("To"); got != "alice@example.com, bob@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	body := new(strings.Builder)
+	if _, err := bufio.NewReader(msg.Body).WriteTo(body); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(body.String()) != "Bitcoin (BTC) crossed above 100000.00: now 100250.00" {
+		t.Errorf("body = %q", body)
+	}
+	if strings.Join(server.recipients, " ") != "alice@example.com bob@example.com" {
+		t.Errorf("recipients = %v", server.recipients)
+	}
+}
+
+func TestEmailNotifierConnectionFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	l.Close() // Nothing listens there now
+
+	n, err := newEmailNotifier(smtpConfig{Host: "127.0.0.1", Port: addr.Port, From: "a@example.com", To: []string{"b@example.com"}, TLS: smtpTLSNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify("BTC is up"); err == nil {
+		t.Error("a refused connection was not reported")
+	}
+}
+
+func TestNewEmailNotifierValidatesConfig(t *testing.T) {
+	for _, c := range []smtpConfig{
+		{From: "a@example.com", To: []string{"b@example.com"}},
+		{Host: "smtp.example.com", To: []string{"b@example.com"}},
+		{Host: "smtp.example.com", From: "a@example.com"},
+		{Host: "smtp.example.com", From: "a@example.com", To: []string{"b@example.com"}, TLS: "ssl"},
+	} {
+		if _, err := newEmailNotifier(c); err == nil {
+			t.Errorf("%+v: accepted", c)
+		}
+	}
+	n, err := newEmailNotifier(smtpConfig{Host: "smtp.example.com", From: "a@example.com", To: []string{"b@example.com"}})
+	if err != nil || n.Port != defaultSMTPPort || n.TLS != smtpTLSStartTLS {
+		t.Errorf("defaults: %+v, %v; want port 587 with STARTTLS", n, err)
+	}
+}
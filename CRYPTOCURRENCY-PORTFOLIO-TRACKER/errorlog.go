@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const recentErrorsSize = 100 // Number of error events kept for /admin/errors
+
+// errorEvent is an error the service ran into, for /admin/errors
+type errorEvent struct {
+	Time    time.Time         `json:"time"`
+	Source  string            `json:"source"` // Area that failed, e.g. "monitor", "http", "job"
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"` // The attributes logged with it, e.g. "symbol" and "err"
+}
+
+// errorRing keeps the most recent error events, overwriting the oldest
+type errorRing struct {
+	mu     sync.Mutex
+	events []errorEvent
+	next   int // Slot the next event is written to once full
+}
+
+var recentErrors errorRing
+
+// add records an event, dropping the oldest once the ring is full
+func (e *errorRing) add(ev errorEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.events) < recentErrorsSize {
+		e.events = append(e.events, ev)
+		return
+	}
+	e.events[e.next] = ev
+	e.next = (e.next + 1) % recentErrorsSize
+}
+
+// list returns the recorded events, newest first
+func (e *errorRing) list() []errorEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	events := make([]errorEvent, 0, len(e.events))
+	for i := len(e.events) - 1; i >= 0; i-- {
+		events = append(events, e.events[(e.next+i)%len(e.events)])
+	}
+	return events
+}
+
+// reportError logs msg with attrs, key-value pairs or slog.Attrs as for
+// slog.Error, and records it for /admin/errors
+func reportError(source, msg string, attrs ...any) {
+	slog.Error(msg, append([]any{"component", source}, attrs...)...)
+
+	record := slog.NewRecord(time.Time{}, slog.LevelError, msg, 0)
+	record.Add(attrs...)
+	var fields map[string]string
+	if record.NumAttrs() > 0 {
+		fields = make(map[string]string, record.NumAttrs())
+		record.Attrs(func(a slog.Attr) bool {
+			fields[a.Key] = a.Value.String()
+			return true
+		})
+	}
+	recentErrors.add(errorEvent{Time: time.Now().UTC(), Source: source, Message: msg, Fields: fields})
+}
+
+// serverError reports err and responds with a 500 carrying msg
+func serverError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	reportError("http", msg, "method", r.Method, "path", r.URL.Path, "request_id", requestID(r.Context()), "err", err)
+	http.Error(w, msg, http.StatusInternalServerError)
+}
+
+// handleRecentErrors lists the most recent errors, newest first
+func handleRecentErrors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(recentErrors.list())
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// exportedHolding is a row of /portfolio/export
+type exportedHolding struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Symbol    string    `json:"symbol"`
+	Amount    float64   `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+	ValueUSD  *float64  `json:"value_usd"` // Null when the symbol couldn't be priced
+}
+
+var exportCSVHeader = []string{"id", "user_id", "symbol", "amount", "created_at", "value_usd"}
+
+// handleExportPortfolio downloads every holding with its current USD value,
+// as JSON (the default) or ?format=csv. Rows are written as they are read
+// rather than collected first.
+func (s *Server) handleExportPortfolio(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "Invalid format, expected json or csv", http.StatusBadRequest)
+		return
+	}
+	userID, ok := optionalUserID(w, r)
+	if !ok {
+		return
+	}
+	if userID, ok = scopeUserID(w, r, userID); !ok {
+		return
+	}
+
+	// Prices are fetched up front, all at once, so rows can stream after
+	h, err := s.store.Holdings(userID)
+	if err != nil {
+		serverError(w, r, "Error fetching portfolio data", err)
+		return
+	}
+	prices := s.prices.Value(r.Context(), h.bySymbol).Prices
+	row := func(p Portfolio) exportedHolding {
+		e := exportedHolding{ID: p.ID, UserID: p.UserID, Symbol: p.Symbol, Amount: p.Amount, CreatedAt: p.CreatedAt}
+		if price, ok := prices[p.Symbol]; ok {
+			value := p.Amount * price
+			e.ValueUSD = &value
+		} else if p.Amount == 0 {
+			var zero float64
+			e.ValueUSD = &zero
+		}
+		return e
+	}
+
+	filename := "portfolio." + format
+	if userID != 0 {
+		filename = "portfolio-" + strconv.Itoa(userID) + "." + format
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	// Once the first row is written the status is sent, so a failure
+	// part way can only be logged and the download cut short
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write(exportCSVHeader)
+		err = s.store.EachHolding(userID, func(p Portfolio) error {
+			e := row(p)
+			value := ""
+			if e.ValueUSD != nil {
+				value = strconv.FormatFloat(*e.ValueUSD, 'f', -1, 64)
+			}
+			return cw.Write([]string{
+				strconv.Itoa(e.ID),
+				strconv.Itoa(e.UserID),
+				e.Symbol,
+				strconv.FormatFloat(e.Amount, 'f', -1, 64),
+				e.CreatedAt.UTC().Format(time.RFC3339),
+				value,
+			})
+		})
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		sep := "[\n"
+		err = s.store.EachHolding(userID, func(p Portfolio) error {
+			b, err := json.Marshal(row(p))
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(append([]byte(sep), b...)); err != nil {
+				return err
+			}
+			sep = ",\n"
+			return nil
+		})
+		if err == nil {
+			end := "\n]\n"
+			if sep == "[\n" {
+				end = "[]\n" // No rows
+			}
+			_, err = w.Write([]byte(end))
+		}
+	}
+	if err != nil {
+		reportError("http", "Error exporting portfolio", "err", err)
+	}
+}
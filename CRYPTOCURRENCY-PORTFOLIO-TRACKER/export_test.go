@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportPortfolioCSV(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{"BTC": 60000})
+	addHoldings(t, store, Portfolio{UserID: 7, Symbol: "BTC", Amount: 0.5}, Portfolio{UserID: 7, Symbol: "NOPE", Amount: 2})
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/export?format=csv&user_id=7", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename="portfolio-7.csv"` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want a header and 2 rows: %v", len(records), records)
+	}
+	if got := strings.Join(records[0], ","); got != "id,user_id,symbol,amount,created_at,value_usd" {
+		t.Errorf("header = %s", got)
+	}
+	row := records[1]
+	if row[1] != "7" || row[2] != "BTC" || row[3] != "0.5" || row[5] != "30000" {
+		t.Errorf("BTC row = %v, want user 7, 0.5 BTC worth 30000", row)
+	}
+	if _, err := time.Parse(time.RFC3339, row[4]); err != nil {
+		t.Errorf("created_at %q: %v", row[4], err)
+	}
+	if records[2][2] != "NOPE" || records[2][5] != "" {
+		t.Errorf("unpriced row = %v, want an empty value", records[2])
+	}
+}
+
+func TestExportPortfolioJSONByDefault(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{"BTC": 60000})
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/export", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" || rec.Body.String() != "[]\n" {
+		t.Errorf("empty export: status %d, type %q, body %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+
+	addHoldings(t, store, Portfolio{UserID: 1, Symbol: "BTC", Amount: 2})
+	rec = serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/export", nil))
+	var rows []exportedHolding
+	if err := json.NewDecoder(rec.Body).Decode(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Symbol != "BTC" || rows[0].ValueUSD == nil || *rows[0].ValueUSD != 120000 {
+		t.Errorf("rows = %+v, want 2 BTC worth 120000", rows)
+	}
+	if rec.Header().Get("Content-Disposition") != `attachment; filename="portfolio.json"` {
+		t.Errorf("Content-Disposition = %q", rec.Header().Get("Content-Disposition"))
+	}
+
+	if rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/export?format=xlsx", nil)); rec.Code != http.StatusBadRequest {
+		t.Errorf("format=xlsx: status = %d, want 400", rec.Code)
+	}
+}
@@ -1,5 +1,8 @@
 module github.com/joshua468/cryptocurrency
 
-go 1.22.0
+go 1.26.0
 
-require github.com/mattn/go-sqlite3 v1.14.22 // indirect
+require (
+	github.com/mattn/go-sqlite3 v1.14.22
+	golang.org/x/time v0.16.0
+)
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// healthTimeout bounds each component check so /health can't hang
+const healthTimeout = 3 * time.Second
+
+// healthReport is the state of each component, "ok" or "error: ..."
+type healthReport struct {
+	DB  string `json:"db"`
+	API string `json:"api"`
+}
+
+// handleHealth checks the database and the CoinCap API, returning 503 if
+// either is unreachable, for load balancer health checks
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+
+	report := healthReport{DB: "ok", API: "ok"}
+	healthy := true
+	if err := s.store.Ping(ctx); err != nil {
+		report.DB = "error: " + err.Error()
+		healthy = false
+	}
+	if err := pingCoinCap(ctx); err != nil {
+		report.API = "error: " + err.Error()
+		healthy = false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *SQLStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// pingCoinCap makes the cheapest CoinCap request there is, a single asset
+func pingCoinCap(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, coinCapURL("/assets?limit=1"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := coinCapClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CoinCap responded %s", resp.Status)
+	}
+	return nil
+}
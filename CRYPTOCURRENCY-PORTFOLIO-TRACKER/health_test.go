@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// getHealth returns the status and report of /health
+func getHealth(t *testing.T, mux *http.ServeMux) (int, healthReport) {
+	t.Helper()
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var report healthReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, report
+}
+
+func TestHealthAllHealthy(t *testing.T) {
+	_, mux := newTestServer(t, newSQLStore(t), fakePrices{})
+	stub := newCoinCapStub(t, map[string]string{"BTC": "65000"})
+
+	code, report := getHealth(t, mux)
+	if code != http.StatusOK || report != (healthReport{DB: "ok", API: "ok"}) {
+		t.Errorf("status %d, report %+v; want 200 and all ok", code, report)
+	}
+	if n := stub.calls("/assets"); n != 1 {
+		t.Errorf("CoinCap checked %d times, want 1", n)
+	}
+}
+
+func TestHealthReportsClosedDB(t *testing.T) {
+	handle, err := openDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle.Close()
+	_, mux := newTestServer(t, NewSQLStore(handle), fakePrices{})
+	newCoinCapStub(t, nil)
+
+	code, report := getHealth(t, mux)
+	if code != http.StatusServiceUnavailable || !strings.HasPrefix(report.DB, "error: ") || report.API != "ok" {
+		t.Errorf("status %d, report %+v; want 503 with the db failing", code, report)
+	}
+}
+
+func TestHealthReportsAPIFailure(t *testing.T) {
+	_, mux := newTestServer(t, newSQLStore(t), fakePrices{})
+	stub := newCoinCapStub(t, nil)
+	stub.Close()
+
+	code, report := getHealth(t, mux)
+	if code != http.StatusServiceUnavailable || report.DB != "ok" || !strings.HasPrefix(report.API, "error: ") {
+		t.Errorf("status %d, report %+v; want 503 with the api failing", code, report)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultValueSnapshotMinutes = 60
+	defaultDrawdownWindowHours  = 24
+)
+
+// drawdownConfig configures the portfolio-level drawdown alert
+type drawdownConfig struct {
+	Percent     float64 `json:"percent"`      // Alert when the value drops by at least this much; 0 disables
+	WindowHours int     `json:"window_hours"` // Compare against the value this long ago, default 24
+}
+
+// valueSnapshot is the total portfolio value at a point in time
+type valueSnapshot struct {
+	TotalValue float64   `json:"total_value"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// valueSnapshotInterval returns how often the portfolio value is recorded,
+// or 0 when snapshots are disabled
+func valueSnapshotInterval() time.Duration {
+	switch {
+	case cfg.ValueSnapshotMinutes < 0:
+		return 0
+	case cfg.ValueSnapshotMinutes == 0:
+		return defaultValueSnapshotMinutes * time.Minute
+	}
+	return time.Duration(cfg.ValueSnapshotMinutes) * time.Minute
+}
+
+// recordValueSnapshot is the scheduled job storing the total portfolio value
+func (s *Server) recordValueSnapshot(ctx context.Context) error {
+	h, err := s.store.Holdings(0)
+	if err != nil {
+		return err
+	}
+	v := s.prices.Value(ctx, h.bySymbol)
+	if len(v.FailedSymbols) > 0 {
+		// A partial total would show up as a fake drawdown
+		return fmt.Errorf("skipping snapshot, unpriced symbols: %s", strings.Join(v.FailedSymbols, ", "))
+	}
+	return s.store.RecordValue(valueSnapshot{TotalValue: v.TotalValue, RecordedAt: time.Now().UTC()})
+}
+
+func (s *SQLStore) RecordValue(v valueSnapshot) error {
+	_, err := execWriteOn(s.db, "INSERT INTO value_history (total_value, recorded_at) VALUES (?, ?)", v.TotalValue, v.RecordedAt.UTC())
+	return err
+}
+
+func (s *SQLStore) ValueBefore(t time.Time) (valueSnapshot, error) {
+	var v valueSnapshot
+	err := s.db.QueryRow(
+		"SELECT total_value, recorded_at FROM value_history WHERE recorded_at <= ? ORDER BY recorded_at DESC LIMIT 1",
+		t.UTC(),
+	).Scan(&v.TotalValue, &v.RecordedAt)
+	return v, err
+}
+
+var (
+	drawdownMu      sync.Mutex
+	drawdownAlerted bool // Set while a drawdown has been notified and not yet recovered
+)
+
+// checkDrawdown is the scheduled job comparing the latest snapshot with the
+// one from the configured window ago and notifying on a large drop
+func (s *Server) checkDrawdown(ctx context.Context) error {
+	window := time.Duration(cfg.Drawdown.WindowHours) * time.Hour
+	if window <= 0 {
+		window = defaultDrawdownWindowHours * time.Hour
+	}
+
+	current, err := s.store.ValueBefore(time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	previous, err := s.store.ValueBefore(current.RecordedAt.Add(-window))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && previous.TotalValue <= 0) {
+		// Not enough history yet
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	drop := (previous.TotalValue - current.TotalValue) / previous.TotalValue * 100
+
+	drawdownMu.Lock()
+	defer drawdownMu.Unlock()
+	if drop >= cfg.Drawdown.Percent {
+		if !drawdownAlerted {
+			msg := fmt.Sprintf("Portfolio value ($%.2f) has dropped %.2f%% since %s ($%.2f)!",
+				current.TotalValue, drop, previous.RecordedAt.Format(time.RFC3339), previous.TotalValue)
+			notify(msg)
+			drawdownAlerted = true
+		}
+	} else {
+		drawdownAlerted = false
+	}
+	return nil
+}
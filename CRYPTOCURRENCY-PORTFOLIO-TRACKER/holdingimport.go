@@ -0,0 +1,235 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxHoldingImportBytes caps the size of a /portfolio/import upload
+const maxHoldingImportBytes = 10 << 20
+
+// holdingImportError is a problem with one line of an imported CSV file,
+// which aborts the whole import
+type holdingImportError struct {
+	Line   int              `json:"line"`
+	Errors validationErrors `json:"errors"`
+}
+
+func (e *holdingImportError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Errors)
+}
+
+// parseHoldingsCSV reads symbol, amount and optional cost_basis columns.
+// With merge, rows repeating a symbol are added together, their cost
+// basis averaged by amount; without it a repeat is an error.
+func parseHoldingsCSV(src io.Reader, merge bool) ([]Portfolio, error) {
+	reader := csv.NewReader(src)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, &csvHeaderError{msg: "CSV file is empty"}
+	}
+	if err != nil {
+		return nil, &csvHeaderError{msg: fmt.Sprintf("error reading CSV header: %v", err)}
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, found := columns[name]; !found {
+			columns[name] = i
+		}
+	}
+	var missing []string
+	for _, name := range []string{"symbol", "amount"} {
+		if _, ok := columns[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &csvHeaderError{msg: "CSV header is missing required columns: " + strings.Join(missing, ", ")}
+	}
+
+	var rows []Portfolio
+	seen := make(map[string]int) // Symbol to its index in rows
+	firstLine := make(map[string]int)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				line = parseErr.StartLine
+			}
+			var errs validationErrors
+			errs.add("row", err.Error())
+			return nil, &holdingImportError{Line: line, Errors: errs}
+		}
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		if strings.Join(record, "") == "" {
+			// Spreadsheets often end with blank rows
+			continue
+		}
+
+		var errs validationErrors
+		p := Portfolio{Symbol: normalizeSymbol(field("symbol"))}
+		if p.Amount, err = strconv.ParseFloat(field("amount"), 64); err == nil {
+			errs = validateHolding(p)
+		} else {
+			if p.Symbol == "" {
+				errs.add("symbol", "is required")
+			}
+			errs.add("amount", "must be a number")
+		}
+		if value := field("cost_basis"); value != "" {
+			cost, err := strconv.ParseFloat(value, 64)
+			if err != nil || cost < 0 {
+				errs.add("cost_basis", "must be a non-negative number")
+			}
+			p.CostBasis = &cost
+		}
+		if i, dup := seen[p.Symbol]; dup && len(errs) == 0 {
+			if !merge {
+				errs.add("symbol", fmt.Sprintf("%s already appears on line %d; pass merge=true to add them together", p.Symbol, firstLine[p.Symbol]))
+			} else {
+				rows[i] = mergeHoldings(rows[i], p)
+				continue
+			}
+		}
+		if len(errs) > 0 {
+			return nil, &holdingImportError{Line: line, Errors: errs}
+		}
+		seen[p.Symbol] = len(rows)
+		firstLine[p.Symbol] = line
+		rows = append(rows, p)
+	}
+	if len(rows) == 0 {
+		return nil, &csvHeaderError{msg: "CSV file has no holdings"}
+	}
+	return rows, nil
+}
+
+// mergeHoldings adds b to a. The cost basis is averaged by amount, and
+// unknown if either one's is.
+func mergeHoldings(a, b Portfolio) Portfolio {
+	merged := Portfolio{Symbol: a.Symbol, Amount: a.Amount + b.Amount}
+	if a.CostBasis != nil && b.CostBasis != nil {
+		cost := (*a.CostBasis*a.Amount + *b.CostBasis*b.Amount) / merged.Amount
+		merged.CostBasis = &cost
+	}
+	return merged
+}
+
+func (s *SQLStore) ImportHoldings(userID int, rows []Portfolio) error {
+	return withTxOn(s.db, func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare("INSERT INTO portfolio (user_id, symbol, amount, cost_basis) VALUES (?, ?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, p := range rows {
+			if _, err := stmt.Exec(userID, p.Symbol, p.Amount, p.CostBasis); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// handleImportHoldings adds the holdings in an uploaded CSV file, given as
+// the "file" field of a multipart form or as the request body, to a user's
+// portfolio. Every row is validated first and all are inserted in one
+// transaction, so a bad row leaves the portfolio untouched.
+func (s *Server) handleImportHoldings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := optionalUserID(w, r)
+	if !ok {
+		return
+	}
+	if userID, ok = scopeUserID(w, r, userID); !ok {
+		return
+	}
+	if userID == 0 {
+		var errs validationErrors
+		errs.add("user_id", "is required")
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxHoldingImportBytes)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Error reading uploaded file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	rows, err := parseHoldingsCSV(body, r.URL.Query().Get("merge") == "true")
+	var headerErr *csvHeaderError
+	if errors.As(err, &headerErr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var lineErr *holdingImportError
+	if errors.As(err, &lineErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		response := struct {
+			Error string `json:"error"`
+			*holdingImportError
+		}{
+			Error:              lineErr.Error(),
+			holdingImportError: lineErr,
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error reading uploaded file", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.ImportHoldings(userID, rows); err != nil {
+		serverError(w, r, "Error importing holdings", err)
+		return
+	}
+
+	response := struct {
+		Imported int `json:"imported"`
+	}{
+		Imported: len(rows),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// uploadCSV posts data to path on handler as the "file" field of a form
+func uploadCSV(t *testing.T, handler http.Handler, path, data string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "holdings.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(data))
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return serve(handler, req)
+}
+
+func TestImportHoldings(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{})
+
+	rec := uploadCSV(t, mux, "/portfolio/import?user_id=3", "Symbol,Amount,Cost_Basis\nbtc,0.5,60000\nETH, 2,\n\n")
+	if rec.Code != http.StatusCreated || strings.TrimSpace(rec.Body.String()) != `{"imported":2}` {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	got := columnValues(t, store.db, "SELECT user_id || ' ' || symbol || ' ' || amount || ' ' || IFNULL(cost_basis, '-') FROM portfolio ORDER BY id")
+	if got != "[3 BTC 0.5 60000.0 3 ETH 2.0 -]" {
+		t.Errorf("stored %s", got)
+	}
+}
+
+func TestImportHoldingsRejectsBadLine(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{})
+
+	rec := uploadCSV(t, mux, "/portfolio/import?user_id=3", "symbol,amount\nBTC,1\nETH,lots\nSOL,5\n")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	var got struct {
+		Error  string           `json:"error"`
+		Line   int              `json:"line"`
+		Errors validationErrors `json:"errors"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Line != 3 || !strings.HasPrefix(got.Error, "line 3:") || len(got.Errors) != 1 || got.Errors[0].Field != "amount" {
+		t.Errorf("got %+v, want an amount error on line 3", got)
+	}
+	// Line 2 was fine, but nothing is imported
+	if got := columnValues(t, store.db, "SELECT symbol FROM portfolio"); got != "[]" {
+		t.Errorf("stored %s, want nothing", got)
+	}
+}
+
+func TestImportHoldingsEmptyFile(t *testing.T) {
+	_, mux := newTestServer(t, newSQLStore(t), fakePrices{})
+	for data, want := range map[string]string{
+		"":                  "CSV file is empty",
+		"symbol,amount\n\n": "CSV file has no holdings",
+		"ticker,quantity\n": "missing required columns: symbol, amount",
+	} {
+		rec := uploadCSV(t, mux, "/portfolio/import?user_id=3", data)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%q: status = %d, body %q; want 400 saying %q", data, rec.Code, rec.Body, want)
+		}
+	}
+}
+
+func TestImportHoldingsDuplicateSymbols(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{})
+	data := "symbol,amount,cost_basis\nBTC,1,100\neth,1,10\nbtc,3,200\n"
+
+	rec := uploadCSV(t, mux, "/portfolio/import?user_id=3", data)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "already appears on line 2") {
+		t.Errorf("without merge: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	rec = uploadCSV(t, mux, "/portfolio/import?user_id=3&merge=true", data)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("with merge: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if got := columnValues(t, store.db, "SELECT symbol || ' ' || amount || ' ' || cost_basis FROM portfolio WHERE symbol = 'BTC'"); got != "[BTC 4.0 175.0]" {
+		t.Errorf("merged BTC = %s, want 4 at an average cost of 175", got)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// realizedEntry is the ledger P&L of one symbol, using average cost
+type realizedEntry struct {
+	Symbol       string  `json:"symbol"`
+	Amount       float64 `json:"amount"`     // Still held according to the ledger
+	CostBasis    float64 `json:"cost_basis"` // Of the amount still held
+	RealizedGain float64 `json:"realized_gain"`
+	Fees         float64 `json:"fees"`
+}
+
+// realizedPnL replays transactions, oldest first, using the average cost
+// method. Buys and deposits add to the cost basis at their price; sells
+// realize the difference between their proceeds and the average cost of
+// the amount sold; withdrawals remove cost without realizing a gain. With
+// fees included, buy fees add to cost and sell fees reduce proceeds.
+func realizedPnL(txs []Transaction, includeFees bool) []realizedEntry {
+	bySymbol := make(map[string]*realizedEntry)
+	for _, t := range txs {
+		e := bySymbol[t.Symbol]
+		if e == nil {
+			e = &realizedEntry{Symbol: t.Symbol}
+			bySymbol[t.Symbol] = e
+		}
+		e.Fees += t.Fee
+		fee := 0.0
+		if includeFees {
+			fee = t.Fee
+		}
+
+		switch t.Type {
+		case txBuy, txDeposit:
+			e.Amount += t.Amount
+			e.CostBasis += t.Amount*t.Price + fee
+		case txSell, txWithdrawal:
+			sold := min(t.Amount, e.Amount)
+			var cost float64
+			if e.Amount > 0 {
+				cost = e.CostBasis * sold / e.Amount
+			}
+			if t.Type == txSell {
+				e.RealizedGain += t.Amount*t.Price - fee - cost
+			}
+			e.Amount -= sold
+			e.CostBasis -= cost
+		}
+	}
+
+	entries := make([]realizedEntry, 0, len(bySymbol))
+	for _, e := range bySymbol {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Symbol < entries[j].Symbol })
+	return entries
+}
+
+func (s *SQLStore) Transactions(userID int) ([]Transaction, error) {
+	query := "SELECT id, user_id, type, symbol, amount, COALESCE(price, 0), COALESCE(fee, 0), occurred_at FROM transactions"
+	var args []any
+	if userID != 0 {
+		query += " WHERE user_id = ?"
+		args = append(args, userID)
+	}
+	rows, err := s.db.Query(query+" ORDER BY occurred_at, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var txs []Transaction
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Type, &t.Symbol, &t.Amount, &t.Price, &t.Fee, &t.OccurredAt); err != nil {
+			return nil, err
+		}
+		txs = append(txs, t)
+	}
+	return txs, rows.Err()
+}
+
+// handleLedgerPnL reports realized gains and fees per symbol from the
+// transaction ledger, optionally for one user
+func (s *Server) handleLedgerPnL(w http.ResponseWriter, r *http.Request) {
+	userID, ok := optionalUserID(w, r)
+	if !ok {
+		return
+	}
+	if userID, ok = scopeUserID(w, r, userID); !ok {
+		return
+	}
+	txs, err := s.store.Transactions(userID)
+	if err != nil {
+		serverError(w, r, "Error fetching transactions", err)
+		return
+	}
+	entries := realizedPnL(txs, cfg.FeesInCostBasis)
+
+	response := struct {
+		Symbols       []realizedEntry `json:"symbols"`
+		TotalRealized float64         `json:"total_realized_gain"`
+		TotalFees     float64         `json:"total_fees"`
+		FeesIncluded  bool            `json:"fees_included"` // Whether fees count towards cost and proceeds
+	}{
+		Symbols:      entries,
+		FeesIncluded: cfg.FeesInCostBasis,
+	}
+	for _, e := range entries {
+		response.TotalRealized += e.RealizedGain
+		response.TotalFees += e.Fees
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
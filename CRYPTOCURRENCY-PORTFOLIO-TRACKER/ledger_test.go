@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// feeLedger buys 2 at 100 with a 4 fee, then sells 1 at 150 with a 2 fee
+func feeLedger() []Transaction {
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []Transaction{
+		{UserID: 1, Type: txBuy, Symbol: "BTC", Amount: 2, Price: 100, Fee: 4, OccurredAt: at},
+		{UserID: 1, Type: txSell, Symbol: "BTC", Amount: 1, Price: 150, Fee: 2, OccurredAt: at.Add(time.Hour)},
+	}
+}
+
+func TestRealizedPnLFees(t *testing.T) {
+	cases := []struct {
+		includeFees    bool
+		gain, costLeft float64
+	}{
+		{false, 50, 100},
+		{true, 46, 102},
+	}
+	for _, c := range cases {
+		entries := realizedPnL(feeLedger(), c.includeFees)
+		if len(entries) != 1 {
+			t.Fatalf("got %d entries, want 1", len(entries))
+		}
+		e := entries[0]
+		if math.Abs(e.RealizedGain-c.gain) > 1e-9 || math.Abs(e.CostBasis-c.costLeft) > 1e-9 || e.Amount != 1 || e.Fees != 6 {
+			t.Errorf("fees included %v: got %+v, want gain %v and cost %v left on 1 BTC, 6 in fees", c.includeFees, e, c.gain, c.costLeft)
+		}
+	}
+}
+
+func TestRealizedPnLWithdrawalRealizesNothing(t *testing.T) {
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := realizedPnL([]Transaction{
+		{Type: txDeposit, Symbol: "ETH", Amount: 4, Price: 10, OccurredAt: at},
+		{Type: txWithdrawal, Symbol: "ETH", Amount: 1, Price: 50, OccurredAt: at},
+	}, false)
+	if e := entries[0]; e.RealizedGain != 0 || e.Amount != 3 || e.CostBasis != 30 {
+		t.Errorf("got %+v, want 3 ETH left at cost 30 and nothing realized", e)
+	}
+}
+
+func TestLedgerPnLReportsTotalFees(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{})
+	cfg.FeesInCostBasis = true
+	applyAll(t, store, feeLedger()...)
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/transactions/pnl?user_id=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		TotalRealized float64 `json:"total_realized_gain"`
+		TotalFees     float64 `json:"total_fees"`
+		FeesIncluded  bool    `json:"fees_included"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(got.TotalRealized-46) > 1e-9 || got.TotalFees != 6 || !got.FeesIncluded {
+		t.Errorf("got %+v, want 46 realized and 6 in fees, included", got)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+)
+
+// captureLogs sends the default logger's records at level and above to the
+// returned buffer, as JSON lines, until the test ends
+func captureLogs(t *testing.T, level slog.Level) *bytes.Buffer {
+	var buf bytes.Buffer
+	saved := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: level})))
+	t.Cleanup(func() { slog.SetDefault(saved) })
+	return &buf
+}
+
+// logRecords parses the JSON lines in buf
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("log line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// findRecord returns the first record with message msg
+func findRecord(records []map[string]any, msg string) (map[string]any, bool) {
+	for _, r := range records {
+		if r["msg"] == msg {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+func TestMonitorLogsStructuredFields(t *testing.T) {
+	logs := captureLogs(t, slog.LevelDebug)
+	monitorSeries(t, tokenConfig{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(100)}, 90, 110)
+	records := logRecords(t, logs)
+
+	polled, ok := findRecord(records, "Polled price")
+	if !ok || polled["level"] != "DEBUG" || polled["component"] != "monitor" || polled["symbol"] != "BTC" || polled["price"] != 90.0 {
+		t.Errorf("poll record = %v, want a debug record with component, symbol and price", polled)
+	}
+	crossed, ok := findRecord(records, "Threshold crossed")
+	if !ok || crossed["level"] != "WARN" || crossed["symbol"] != "BTC" || crossed["price"] != 110.0 ||
+		crossed["threshold"] != "100" || crossed["direction"] != directionAbove {
+		t.Errorf("crossing record = %v, want a warning with symbol, price, threshold and direction", crossed)
+	}
+}
+
+func TestMonitorLogsStructuredErrors(t *testing.T) {
+	logs := captureLogs(t, slog.LevelInfo)
+	s, _ := newTestServer(t, &fakeStore{}, fakePrices{})
+	cfg.StartupJitterSeconds = -1
+	useProvider(t, &scriptedProvider{errs: []error{errors.New("connection reset")}})
+
+	startMonitor(t, s, tokenConfig{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(100)})
+	waitFor(t, "the failure", func() bool { return tokenStatusOf("BTC").ConsecutiveFailures == 1 })
+
+	failed, ok := findRecord(logRecords(t, logs), "Error retrieving price")
+	if !ok || failed["level"] != "ERROR" || failed["component"] != "monitor" || failed["provider"] != "scripted" || failed["symbol"] != "BTC" || failed["err"] != "connection reset" {
+		t.Errorf("error record = %v, want a monitor error with provider, symbol and err", failed)
+	}
+	if ev := recentErrors.list()[0]; ev.Message != "Error retrieving price" || ev.Fields["symbol"] != "BTC" || ev.Fields["err"] != "connection reset" {
+		t.Errorf("recorded %+v, want the symbol and err as fields", ev)
+	}
+}
+
+func TestLogLevelFiltersRoutinePolls(t *testing.T) {
+	logs := captureLogs(t, slog.LevelInfo)
+	monitorSeries(t, tokenConfig{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(100)}, 110)
+	records := logRecords(t, logs)
+
+	if r, ok := findRecord(records, "Polled price"); ok {
+		t.Errorf("debug record %v logged at info level", r)
+	}
+	if _, ok := findRecord(records, "Threshold crossed"); !ok {
+		t.Error("crossing not logged at info level")
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	cases := map[string]slog.Level{
+		"":        slog.LevelInfo,
+		"debug":   slog.LevelDebug,
+		"INFO":    slog.LevelInfo,
+		"warning": slog.LevelWarn,
+		"error":   slog.LevelError,
+	}
+	for s, want := range cases {
+		if got, err := parseLogLevel(s); err != nil || got != want {
+			t.Errorf("parseLogLevel(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("invalid level accepted")
+	}
+}
@@ -1,281 +1,1091 @@
-package main
-
-import (
-	"database/sql"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"strconv"
-	"sync"
-	"time"
-
-	_ "github.com/mattn/go-sqlite3"
-)
-
-var (
-	db *sql.DB
-	wg sync.WaitGroup
-)
-
-const (
-	coincapCryptoAPI = "https://api.coincap.io/v2/assets"
-	retryDelay       = 30 // Delay between checking a token's price
-)
-
-type coinCapAsset struct {
-	Data []struct {
-		ID       string `json:"id"`
-		Symbol   string `json:"symbol"`
-		PriceUsd string `json:"priceUsd"`
-	} `json:"data"`
-}
-
-type tokenConfig struct {
-	Name      string  `json:"name"`
-	Symbol    string  `json:"symbol"`
-	Threshold float64 `json:"threshold"`
-}
-
-type config struct {
-	Tokens []tokenConfig `json:"tokens"`
-}
-
-type Portfolio struct {
-	ID        int          `json:"id"`
-	UserID    int          `json:"user_id"`
-	Symbol    string       `json:"symbol"`
-	Amount    float64      `json:"amount"`
-	CreatedAt time.Time    `json:"created_at"`
-	UpdatedAt sql.NullTime `json:"updated_at"`
-}
-
-func main() {
-	// Open database connection
-	var err error
-	db, err = sql.Open("sqlite3", "./portfolio.db")
-	if err != nil {
-		log.Fatal("Error opening database connection:", err)
-	}
-	defer db.Close()
-
-	// Create table if not exists
-	if err := createTable(); err != nil {
-		log.Fatal("Error creating table:", err)
-	}
-
-	// Load configuration from file
-	cfg, err := loadConfig("config.json")
-	if err != nil {
-		log.Fatal("Error loading configuration:", err)
-	}
-
-	for _, token := range cfg.Tokens {
-		wg.Add(1)
-		go monitorToken(token)
-	}
-
-	// Define routes
-	http.HandleFunc("/portfolio", handlePortfolio)
-	http.HandleFunc("/portfolio/add", handleAddToPortfolio)
-	http.HandleFunc("/portfolio/value", handlePortfolioValue)
-
-	// Start server
-	fmt.Println("Server listening on port 8080...")
-	go func() {
-		if err := http.ListenAndServe(":8080", nil); err != nil {
-			log.Fatal("HTTP server error:", err)
-		}
-	}()
-	wg.Wait()
-}
-
-// createTable creates portfolio table if not exists
-func createTable() error {
-	createStmt := `
-		CREATE TABLE IF NOT EXISTS portfolio (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id INTEGER,
-			symbol TEXT,
-			amount REAL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP
-		);
-	`
-
-	_, err := db.Exec(createStmt)
-	return err
-}
-
-// monitorToken continuously monitors the price of a token
-func monitorToken(token tokenConfig) {
-	defer wg.Done()
-	for {
-		price, err := getCoinCapPrice(token.Symbol)
-		if err != nil {
-			log.Printf("Error retrieving %s price: %v\n", token.Name, err)
-			continue
-		}
-		if price > token.Threshold {
-			msg := fmt.Sprintf("%s price ($%.2f) is above threshold ($%.2f)!", token.Name, price, token.Threshold)
-			log.Println(msg)
-			// Replace messageBox with appropriate notification mechanism
-		}
-		time.Sleep(retryDelay * time.Second)
-	}
-}
-
-// loadConfig loads configuration from a file
-func loadConfig(filename string) (*config, error) {
-	// Load configuration from file
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
-
-	var cfg config
-	err = json.Unmarshal(data, &cfg)
-	if err != nil {
-		return nil, err
-	}
-
-	return &cfg, nil
-}
-
-// getCoinCapPrice retrieves the price of a cryptocurrency from the CoinCap API
-func getCoinCapPrice(symbol string) (float64, error) {
-	resp, err := http.Get(coincapCryptoAPI)
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
-
-	var assetData coinCapAsset
-	err = json.NewDecoder(resp.Body).Decode(&assetData)
-	if err != nil {
-		return 0, err
-	}
-
-	for _, asset := range assetData.Data {
-		if asset.Symbol == symbol {
-			priceUsd, err := strconv.ParseFloat(asset.PriceUsd, 64)
-			if err != nil {
-				return 0, err
-			}
-			return priceUsd, nil
-		}
-	}
-
-	return 0, fmt.Errorf("price data not found for symbol %s", symbol)
-}
-
-// handlePortfolio fetches and displays portfolio data
-func handlePortfolio(w http.ResponseWriter, r *http.Request) {
-	// Fetch portfolio data from the database
-	rows, err := db.Query("SELECT * FROM portfolio")
-	if err != nil {
-		http.Error(w, "Error fetching portfolio data", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	// Create a slice to store portfolio entries
-	var portfolio []Portfolio
-
-	// Iterate over the rows and populate the portfolio slice
-	for rows.Next() {
-		var p Portfolio
-		err := rows.Scan(&p.ID, &p.UserID, &p.Symbol, &p.Amount, &p.CreatedAt, &p.UpdatedAt)
-		if err != nil {
-			http.Error(w, "Error scanning portfolio data", http.StatusInternalServerError)
-			return
-		}
-		portfolio = append(portfolio, p)
-	}
-
-	// Set response header
-	w.Header().Set("Content-Type", "application/json")
-
-	// Encode portfolio data as JSON and write it to the response writer
-	err = json.NewEncoder(w).Encode(portfolio)
-	if err != nil {
-		http.Error(w, "Error encoding portfolio data", http.StatusInternalServerError)
-		return
-	}
-}
-
-// handleAddToPortfolio adds cryptocurrency to the portfolio
-func handleAddToPortfolio(w http.ResponseWriter, r *http.Request) {
-	// Parse the request body to extract cryptocurrency data
-	var p Portfolio
-	err := json.NewDecoder(r.Body).Decode(&p)
-	if err != nil {
-		http.Error(w, "Error parsing request body", http.StatusBadRequest)
-		return
-	}
-
-	// Insert cryptocurrency data into the database
-	_, err = db.Exec("INSERT INTO portfolio (user_id, symbol, amount) VALUES (?, ?, ?)", p.UserID, p.Symbol, p.Amount)
-	if err != nil {
-		http.Error(w, "Error adding cryptocurrency to portfolio", http.StatusInternalServerError)
-		return
-	}
-
-	// Set response status code to indicate success
-	w.WriteHeader(http.StatusCreated)
-}
-
-// handlePortfolioValue calculates and displays portfolio value
-func handlePortfolioValue(w http.ResponseWriter, r *http.Request) {
-	// Fetch portfolio data from the database
-	rows, err := db.Query("SELECT symbol, amount FROM portfolio")
-	if err != nil {
-		http.Error(w, "Error fetching portfolio data", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	// Map to store cryptocurrency amounts
-	cryptoAmounts := make(map[string]float64)
-
-	// Iterate over the rows and populate the map
-	for rows.Next() {
-		var symbol string
-		var amount float64
-		err := rows.Scan(&symbol, &amount)
-		if err != nil {
-			http.Error(w, "Error scanning portfolio data", http.StatusInternalServerError)
-			return
-		}
-		cryptoAmounts[symbol] += amount
-	}
-
-	// Calculate total portfolio value based on current cryptocurrency prices
-	var totalValue float64
-	for symbol, amount := range cryptoAmounts {
-		price, err := getCoinCapPrice(symbol)
-		if err != nil {
-			http.Error(w, "Error fetching cryptocurrency price", http.StatusInternalServerError)
-			return
-		}
-		totalValue += price * amount
-	}
-
-	// Create a response object
-	response := struct {
-		TotalValue float64 `json:"total_value"`
-	}{
-		TotalValue: totalValue,
-	}
-
-	// Set response header
-	w.Header().Set("Content-Type", "application/json")
-
-	// Encode response object as JSON and write it to the response writer
-	err = json.NewEncoder(w).Encode(response)
-	if err != nil {
-		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
-		return
-	}
-}
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	cfg *config
+	wg  sync.WaitGroup
+
+	// cfgMu guards cfg.Tokens, which can be changed at runtime through
+	// /config/thresholds; the rest of cfg is read-only after startup
+	cfgMu sync.RWMutex
+
+	// appCtx is cancelled when the service shuts down. Monitors started at
+	// runtime derive their context from it.
+	appCtx context.Context
+)
+
+const (
+	defaultDSN                 = "./portfolio.db"
+	defaultAPIBaseURL          = "https://api.coincap.io/v2"
+	defaultPollIntervalSeconds = 30 // Delay between checking a token's price
+	defaultHTTPTimeoutSeconds  = 10 // Limit on each CoinCap request
+
+	directionAbove = "above"
+	directionBelow = "below"
+
+	thresholdModeAbsolute    = "absolute"
+	thresholdModeCostPercent = "cost_percent"
+
+	defaultMaxTokens       = 50 // Watchlist size cap when config doesn't set one
+	defaultPercentDecimals = 2  // Decimal places P&L percentages are rounded to
+
+	priceFetchAttempts   = 3                      // Attempts per symbol when valuing the portfolio
+	priceFetchRetryDelay = 500 * time.Millisecond // Delay between those attempts
+
+	shutdownTimeout = 10 * time.Second // How long in-flight requests get to finish on shutdown
+
+	defaultPageLimit = 100  // Holdings per /portfolio page when no limit is given
+	maxPageLimit     = 1000 // Largest page /portfolio serves
+)
+
+type coinCapAsset struct {
+	Data []struct {
+		ID       string `json:"id"`
+		Symbol   string `json:"symbol"`
+		PriceUsd string `json:"priceUsd"`
+
+		ChangePercent24Hr string `json:"changePercent24Hr"`
+	} `json:"data"`
+	Timestamp int64 `json:"timestamp"` // Unix milliseconds when CoinCap produced the data
+}
+
+// priceQuote is a price along with where and when it was observed
+type priceQuote struct {
+	Provider   string    `json:"provider" xml:"provider"`
+	Price      float64   `json:"price" xml:"price"` // In USD
+	ObservedAt time.Time `json:"observed_at" xml:"observed_at"`
+}
+
+type tokenConfig struct {
+	Name           string       `json:"name"`
+	Symbol         string       `json:"symbol"`
+	Threshold      decimal      `json:"threshold"`       // A JSON string or number, compared exactly
+	NotifyRecovery bool         `json:"notify_recovery"` // Notify when the price drops back below the threshold
+	MarketHours    *marketHours `json:"market_hours"`    // Optional trading hours for tokenized assets
+
+	// GracePeriodSeconds suppresses notifications for crossings observed
+	// this soon after startup
+	GracePeriodSeconds int `json:"grace_period_seconds"`
+
+	// ThresholdMode is "absolute" (the default) or "cost_percent", in which
+	// case Threshold is a percentage relative to the holding's average cost
+	ThresholdMode string `json:"threshold_mode"`
+
+	// Direction is "above" (the default) to alert when the price rises past
+	// an absolute threshold, or "below" to alert when it falls under it
+	Direction string `json:"direction,omitempty"`
+
+	// ChangeAlert notifies when the price moves by at least this many
+	// dollars between two consecutive polls, regardless of the threshold
+	ChangeAlert decimal `json:"change_alert"`
+
+	// Hysteresis is how far, in dollars, the price must move back past the
+	// threshold before the alert re-arms, so a price hovering right at the
+	// threshold doesn't notify on every wobble
+	Hysteresis decimal `json:"hysteresis"`
+
+	// UpperThreshold alerts when the price rises past it and LowerThreshold
+	// when it falls under it; either or both may be set. Threshold remains
+	// an alias for the upper one, or the lower one with direction "below".
+	UpperThreshold *decimal `json:"upper_threshold,omitempty"`
+	LowerThreshold *decimal `json:"lower_threshold,omitempty"`
+
+	// UserID is the holder a portfolio threshold belongs to, 0 for tokens
+	// from the config
+	UserID int `json:"-"`
+}
+
+// monitorKey identifies the monitor of a symbol for a holder: the symbol
+// alone for user 0, or suffixed with the user's ID
+func monitorKey(symbol string, userID int) string {
+	if userID == 0 {
+		return symbol
+	}
+	return fmt.Sprintf("%s:%d", symbol, userID)
+}
+
+// thresholds returns the token's upper and lower thresholds, nil when not
+// configured. Threshold fills the slot its direction names unless that is
+// set explicitly; when neither is, it applies even if zero.
+func (t tokenConfig) thresholds() (upper, lower *decimal) {
+	upper, lower = t.UpperThreshold, t.LowerThreshold
+	if upper == nil && lower == nil || !t.Threshold.IsZero() {
+		threshold := t.Threshold
+		if t.Direction == directionBelow {
+			if lower == nil {
+				lower = &threshold
+			}
+		} else if upper == nil {
+			upper = &threshold
+		}
+	}
+	return upper, lower
+}
+
+// thresholdKey identifies the thresholds a persisted triggered flag was
+// saved for
+func (t tokenConfig) thresholdKey() (threshold, direction string) {
+	if t.UpperThreshold == nil && t.LowerThreshold == nil {
+		return t.Threshold.String(), t.Direction
+	}
+	upper, lower := t.thresholds()
+	var lo, hi string
+	if lower != nil {
+		lo = lower.String()
+	}
+	if upper != nil {
+		hi = upper.String()
+	}
+	return lo + ".." + hi, "range"
+}
+
+type config struct {
+	// APIBaseURL is the CoinCap API root, e.g. to go through a proxy.
+	// PollIntervalSeconds is the delay between checks of each token.
+	// Both use the defaults when empty or zero.
+	APIBaseURL          string `json:"api_base_url,omitempty"`
+	PollIntervalSeconds int    `json:"poll_interval_seconds,omitempty"`
+
+	Tokens []tokenConfig `json:"tokens"`
+	Backup backupConfig  `json:"backup"`
+
+	// TokenDir is a directory, relative to the config file, whose *.json
+	// files each hold {"tokens": [...]} to append to the watchlist
+	TokenDir string `json:"token_dir,omitempty"`
+
+	// StartupJitterSeconds spreads the first poll of each token over this
+	// window so a large watchlist doesn't hit CoinCap all at once. Zero
+	// uses the poll interval; a negative value disables the jitter.
+	StartupJitterSeconds int `json:"startup_jitter_seconds"`
+
+	// ValueSnapshotMinutes is how often the total portfolio value is
+	// recorded to value_history. Zero uses the default; negative disables.
+	ValueSnapshotMinutes int            `json:"value_snapshot_minutes"`
+	Drawdown             drawdownConfig `json:"drawdown"`
+
+	// MonitorPortfolio ignores Tokens and instead monitors every holding
+	// whose user has set a threshold for it via /portfolio/thresholds,
+	// refreshing the set periodically
+	MonitorPortfolio        bool `json:"monitor_portfolio"`
+	PortfolioRefreshMinutes int  `json:"portfolio_refresh_minutes"`
+
+	// DustThresholdUSD hides holdings worth less than this from value
+	// breakdowns and allocations unless the request passes include_dust=true
+	DustThresholdUSD float64 `json:"dust_threshold_usd"`
+
+	// MaxTokens caps the size of the watchlist, including tokens added at
+	// runtime. Zero uses defaultMaxTokens; a negative value removes the cap.
+	MaxTokens int `json:"max_tokens"`
+
+	// DesktopNotifications also shows alerts as native desktop
+	// notifications, for local runs
+	DesktopNotifications bool `json:"desktop_notifications"`
+
+	// PercentDecimals is how many decimal places P&L percentages are
+	// rounded to, defaulting to defaultPercentDecimals
+	PercentDecimals *int `json:"percent_decimals,omitempty"`
+
+	PositionWeighting positionWeightingConfig `json:"position_weighting"`
+
+	// RiskFreeRatePercent is the default annual risk-free rate for
+	// /portfolio/stats/sharpe
+	RiskFreeRatePercent float64 `json:"risk_free_rate_percent"`
+
+	// PortfolioRules are checked against every user's holdings each
+	// PortfolioRuleMinutes (default 15)
+	PortfolioRules       []portfolioRule `json:"portfolio_rules,omitempty"`
+	PortfolioRuleMinutes int             `json:"portfolio_rule_minutes"`
+
+	// PriceCacheSeconds is how long a fetched price list is reused. Zero
+	// uses the default of 30 seconds; a negative value disables the cache.
+	PriceCacheSeconds int `json:"price_cache_seconds"`
+
+	// DelistedAfter is how many consecutive "not found" results mark a
+	// monitored symbol as delisted (default 10). StopPollingDelisted then
+	// stops its monitor instead of polling on at the backoff's pace.
+	DelistedAfter       int  `json:"delisted_after"`
+	StopPollingDelisted bool `json:"stop_polling_delisted"`
+
+	// FeesInCostBasis adds transaction fees, assumed to be in USD, to the
+	// cost of buys and deducts them from the proceeds of sells
+	FeesInCostBasis bool `json:"fees_in_cost_basis"`
+
+	DailySummary dailySummaryConfig `json:"daily_summary"`
+
+	// Notifiers are where alerts are sent, the log when none are given
+	Notifiers []notifierConfig `json:"notifiers,omitempty"`
+
+	// StrictSymbols fails startup when a configured symbol isn't listed
+	// by CoinCap instead of only warning
+	StrictSymbols bool `json:"strict_symbols"`
+
+	// Currency is the default display currency of valuations, USD if empty.
+	// Requests can still pick another with ?currency=.
+	Currency string `json:"currency,omitempty"`
+
+	// HTTPTimeoutSeconds bounds each CoinCap request, 10 seconds if zero
+	HTTPTimeoutSeconds int `json:"http_timeout_seconds,omitempty"`
+
+	// LogLevel is debug, info (the default), warn or error
+	LogLevel string `json:"log_level,omitempty"`
+
+	// PriceHistoryRetentionDays prunes recorded prices older than this at
+	// startup and daily; 0 keeps them forever
+	PriceHistoryRetentionDays int `json:"price_history_retention_days,omitempty"`
+
+	// Database is the SQLite file or DSN, ./portfolio.db if empty. The
+	// DB_DSN environment variable overrides it; ":memory:" keeps nothing
+	// on disk.
+	Database string `json:"database,omitempty"`
+
+	CORS corsConfig `json:"cors"`
+
+	RateLimit rateLimitConfig `json:"rate_limit"`
+
+	// PriceProviders are the price sources in order of preference, each
+	// falling back to the next when it fails. Only CoinCap is used if empty.
+	PriceProviders []string `json:"price_providers,omitempty"`
+
+	CoinGecko coinGeckoConfig `json:"coingecko"`
+
+	Auth authConfig `json:"auth"`
+
+	// StrictAccept answers 406 to reads whose Accept header allows neither
+	// JSON nor XML, instead of sending JSON anyway
+	StrictAccept bool `json:"strict_accept,omitempty"`
+}
+
+type Portfolio struct {
+	ID        int          `json:"id" xml:"id"`
+	UserID    int          `json:"user_id" xml:"user_id"`
+	Symbol    string       `json:"symbol" xml:"symbol"`
+	Amount    float64      `json:"amount" xml:"amount"`
+	CreatedAt time.Time    `json:"created_at" xml:"created_at"`
+	UpdatedAt sql.NullTime `json:"updated_at" xml:"-"` // See MarshalXML
+
+	// CostBasis is the USD price paid per unit, null for holdings added
+	// before it was recorded
+	CostBasis *float64 `json:"cost_basis" xml:"cost_basis,omitempty"`
+}
+
+// MarshalXML writes UpdatedAt as a plain time, left out when null, rather
+// than sql.NullTime's Time and Valid fields
+func (p Portfolio) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type holding Portfolio // Without this method
+	x := struct {
+		holding
+		UpdatedAt *time.Time `xml:"updated_at,omitempty"`
+	}{holding: holding(p)}
+	if p.UpdatedAt.Valid {
+		x.UpdatedAt = &p.UpdatedAt.Time
+	}
+	return e.EncodeElement(x, start)
+}
+
+func main() {
+	flag.Parse()
+
+	// Load configuration from file first, since it sets up logging
+	var err error
+	cfg, err = loadConfig("config.json")
+	if err != nil {
+		fatal("Error loading configuration", err)
+	}
+	setupLogging(cfg)
+
+	// Open database connection
+	handle, err := openDB(databaseDSN(cfg))
+	if err != nil {
+		fatal("Error opening database connection", err)
+	}
+	defer handle.Close()
+
+	// Bring the schema up to date
+	if err := migrate(handle); err != nil {
+		fatal("Error migrating database", err)
+	}
+	store := NewSQLStore(handle)
+
+	// A dry run only reads price_history, so it stops here
+	if *replaySymbol != "" {
+		if err := runReplay(store); err != nil {
+			fatal("Error replaying price history", err)
+		}
+		return
+	}
+
+	setupNotifiers(cfg)
+	go notifications.run()
+	setupPriceProvider(cfg)
+	coinCapClient.Timeout = cfg.httpTimeout()
+
+	// Monitors, jobs and the server stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	appCtx = ctx
+
+	if err := validateSymbols(ctx, cfg); err != nil {
+		fatal("Error validating configuration", err)
+	}
+	if err := validateCurrency(ctx, cfg); err != nil {
+		fatal("Error validating configuration", err)
+	}
+
+	api := NewServer(store, providerPriceClient{})
+	api.strictAccept = cfg.StrictAccept
+	if err := api.prunePriceHistory(ctx); err != nil {
+		reportError("db", "Error pruning price history", "err", err)
+	}
+
+	// Register periodic jobs
+	if cfg.PriceHistoryRetentionDays > 0 {
+		jobs.add("price-history-prune", every(historyPruneInterval), api.prunePriceHistory)
+	}
+	if cfg.Backup.IntervalMinutes > 0 {
+		jobs.add("backup", every(time.Duration(cfg.Backup.IntervalMinutes)*time.Minute), api.runBackup)
+	}
+	if interval := valueSnapshotInterval(); interval > 0 {
+		jobs.add("value-snapshot", every(interval), api.recordValueSnapshot)
+		if cfg.Drawdown.Percent > 0 {
+			jobs.add("drawdown", every(interval), api.checkDrawdown)
+		}
+	}
+	if cfg.DailySummary.Time != "" {
+		// Validated when the config was loaded
+		sched, _ := cfg.DailySummary.schedule()
+		jobs.add("daily-summary", sched, api.sendDailySummary)
+	}
+	if len(cfg.PortfolioRules) > 0 {
+		jobs.add("portfolio-rules", every(portfolioRuleInterval()), api.evaluatePortfolioRules)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		jobs.run(ctx)
+	}()
+
+	// Start monitoring, either the configured watchlist or the symbols held
+	if cfg.MonitorPortfolio {
+		if err := api.syncPortfolioWatchlist(ctx); err != nil {
+			reportError("monitor", "Error loading portfolio watchlist", "err", err)
+		}
+		jobs.add("portfolio-watchlist", every(portfolioRefreshInterval()), api.syncPortfolioWatchlist)
+	} else {
+		api.reconcileMonitors(ctx, watchlist())
+	}
+
+	// Define routes
+	api.routes(http.DefaultServeMux)
+	http.HandleFunc("/portfolio/dca", handleDCA)
+	http.HandleFunc("/markets", handleMarkets)
+	http.HandleFunc("/currencies", handleCurrencies)
+	http.HandleFunc("/admin/cache/clear", handleClearCache)
+	http.HandleFunc("/admin/monitor/pause", handlePauseMonitoring)
+	http.HandleFunc("/admin/monitor/resume", handleResumeMonitoring)
+	http.HandleFunc("/admin/errors", handleRecentErrors)
+	http.HandleFunc("/status", handleStatus)
+	http.HandleFunc("/prices", handlePrices)
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/jobs", handleJobs)
+	http.HandleFunc("/config/export", handleConfigExport)
+
+	var handler http.Handler = http.DefaultServeMux
+	if os.Getenv("READ_ONLY") == "true" {
+		slog.Info("Running in read-only mode")
+		handler = readOnly(handler)
+	}
+	if cfg.Auth.apiKey() != "" {
+		handler = requireAPIKey(cfg.Auth, handler)
+	} else if cfg.Auth.RequireForReads {
+		fatal("Invalid auth setup", errors.New("auth.require_for_reads needs an API key"))
+	}
+	handler = api.authenticate(handler)
+	if cfg.RateLimit.RequestsPerSecond >= 0 {
+		handler = rateLimit(newIPRateLimiter(cfg.RateLimit), handler)
+	}
+	handler = cors(cfg.CORS, handler)
+	handler = accessLog(handler)
+
+	// Start server, over HTTPS when a certificate and key are configured
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		fatal("Invalid TLS setup", errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	server := &http.Server{Addr: ":8080", Handler: handler}
+	go func() {
+		var err error
+		if certFile != "" {
+			slog.Info("Server listening", "addr", server.Addr, "tls", true)
+			err = server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			slog.Info("Server listening", "addr", server.Addr, "tls", false)
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("HTTP server error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	slog.Info("Shutting down")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error shutting down HTTP server", "error", err)
+	}
+	// The monitors and jobs must stop before the deferred handle.Close
+	wg.Wait()
+	// Then nothing else can notify, and what they queued can go out
+	notifications.close(shutdownCtx)
+	slog.Info("Shutdown complete")
+}
+
+// loadConfig loads configuration from a file
+func loadConfig(filename string) (*config, error) {
+	// Load configuration from file
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg config
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.mergeTokenFiles(filename); err != nil {
+		return nil, err
+	}
+
+	if d := cfg.PercentDecimals; d != nil && (*d < 0 || *d > 10) {
+		return nil, fmt.Errorf("percent_decimals must be between 0 and 10")
+	}
+	for i, rule := range cfg.PortfolioRules {
+		if rule.Name == "" {
+			cfg.PortfolioRules[i].Name = rule.Metric
+		}
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("portfolio rule %d: %v", i+1, err)
+		}
+	}
+	if cfg.APIBaseURL != "" {
+		if u, err := url.Parse(cfg.APIBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid api_base_url %q", cfg.APIBaseURL)
+		}
+	}
+	if cfg.CoinGecko.BaseURL != "" {
+		if u, err := url.Parse(cfg.CoinGecko.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid coingecko.base_url %q", cfg.CoinGecko.BaseURL)
+		}
+	}
+	if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		return nil, err
+	}
+	if err := cfg.CORS.validate(); err != nil {
+		return nil, err
+	}
+	for _, name := range cfg.PriceProviders {
+		if _, err := newPriceProvider(&cfg, name); err != nil {
+			return nil, err
+		}
+	}
+	for _, nc := range cfg.Notifiers {
+		if _, err := newNotifier(nc); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.DailySummary.Time != "" {
+		if _, err := cfg.DailySummary.schedule(); err != nil {
+			return nil, err
+		}
+	}
+	if limit := cfg.maxTokens(); limit > 0 && len(cfg.Tokens) > limit {
+		return nil, fmt.Errorf("%d tokens configured, the maximum is %d", len(cfg.Tokens), limit)
+	}
+	for _, token := range cfg.Tokens {
+		if err := validateToken(token); err != nil {
+			return nil, fmt.Errorf("token %s: %v", token.Symbol, err)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// coinCapURL returns the URL of a CoinCap API path such as "/assets"
+func coinCapURL(path string) string {
+	base := defaultAPIBaseURL
+	if cfg != nil && cfg.APIBaseURL != "" {
+		base = strings.TrimRight(cfg.APIBaseURL, "/")
+	}
+	return base + path
+}
+
+// httpTimeout is how long a CoinCap request may take
+func (c *config) httpTimeout() time.Duration {
+	if c.HTTPTimeoutSeconds <= 0 {
+		return defaultHTTPTimeoutSeconds * time.Second
+	}
+	return time.Duration(c.HTTPTimeoutSeconds) * time.Second
+}
+
+// pollUnit is the unit of poll_interval_seconds; tests shorten it
+var pollUnit = time.Second
+
+// pollInterval is the delay between checks of a token's price
+func pollInterval() time.Duration {
+	if cfg == nil || cfg.PollIntervalSeconds <= 0 {
+		return defaultPollIntervalSeconds * pollUnit
+	}
+	return time.Duration(cfg.PollIntervalSeconds) * pollUnit
+}
+
+// maxTokens returns the watchlist size cap, or 0 for no cap
+func (c *config) maxTokens() int {
+	switch {
+	case c.MaxTokens == 0:
+		return defaultMaxTokens
+	case c.MaxTokens < 0:
+		return 0
+	}
+	return c.MaxTokens
+}
+
+// percentDecimals returns the decimal places P&L percentages are rounded to
+func (c *config) percentDecimals() int {
+	if c.PercentDecimals == nil {
+		return defaultPercentDecimals
+	}
+	return *c.PercentDecimals
+}
+
+// validateToken checks the enumerated and structured fields of a token config
+func validateToken(token tokenConfig) error {
+	switch token.ThresholdMode {
+	case "", thresholdModeAbsolute, thresholdModeCostPercent:
+	default:
+		return fmt.Errorf("invalid threshold mode %q", token.ThresholdMode)
+	}
+	switch token.Direction {
+	case "", directionAbove, directionBelow:
+	default:
+		return fmt.Errorf("invalid direction %q", token.Direction)
+	}
+	if !token.Threshold.IsZero() {
+		if token.Direction == directionBelow && token.LowerThreshold != nil {
+			return fmt.Errorf("threshold with direction below and lower_threshold are both set")
+		}
+		if token.Direction != directionBelow && token.UpperThreshold != nil {
+			return fmt.Errorf("threshold and upper_threshold are both set")
+		}
+	}
+	if upper, lower := token.thresholds(); upper != nil && lower != nil && lower.Cmp(*upper) >= 0 {
+		return fmt.Errorf("lower threshold %s must be less than upper threshold %s", lower, upper)
+	}
+	if token.Hysteresis.Sign() < 0 {
+		return fmt.Errorf("invalid hysteresis %s", token.Hysteresis)
+	}
+	if token.MarketHours != nil {
+		if err := token.MarketHours.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchlist returns a copy of the configured tokens
+func watchlist() []tokenConfig {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	return append([]tokenConfig(nil), cfg.Tokens...)
+}
+
+// tokenConfigFor returns the monitored token config for symbol, if any
+func tokenConfigFor(symbol string) (tokenConfig, bool) {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	for _, token := range cfg.Tokens {
+		if token.Symbol == symbol {
+			return token, true
+		}
+	}
+	return tokenConfig{}, false
+}
+
+// getCoinCapPrice retrieves the price of a cryptocurrency from the CoinCap API
+func getCoinCapPrice(ctx context.Context, symbol string) (float64, error) {
+	quote, err := getCoinCapQuote(ctx, symbol)
+	if err != nil {
+		return 0, err
+	}
+	return quote.Price, nil
+}
+
+// getCoinCapQuote retrieves the price of a cryptocurrency along with the
+// time CoinCap reported it, reusing a recently fetched asset list
+func getCoinCapQuote(ctx context.Context, symbol string) (priceQuote, error) {
+	return coinCapPrices.quote(ctx, symbol)
+}
+
+// getCoinCapAssets retrieves the current CoinCap asset list and refreshes
+// the price cache with it
+func getCoinCapAssets(ctx context.Context) (coinCapAsset, error) {
+	var assetData coinCapAsset
+	err := coinCapGet(ctx, coinCapURL("/assets"), &assetData)
+	if err != nil {
+		return coinCapAsset{}, err
+	}
+	for i := range assetData.Data {
+		assetData.Data[i].Symbol = normalizeSymbol(assetData.Data[i].Symbol)
+	}
+	coinCapPrices.store(assetData)
+	return assetData, nil
+}
+
+// quote returns the price of symbol from the asset list
+func (a coinCapAsset) quote(symbol string) (priceQuote, error) {
+	symbol = normalizeSymbol(symbol)
+	observedAt := time.Now().UTC()
+	if a.Timestamp > 0 {
+		observedAt = time.UnixMilli(a.Timestamp).UTC()
+	}
+
+	for _, asset := range a.Data {
+		if asset.Symbol == symbol {
+			priceUsd, err := parseAssetPrice(symbol, asset.PriceUsd)
+			if err != nil {
+				return priceQuote{}, err
+			}
+			return priceQuote{Provider: "coincap", Price: priceUsd, ObservedAt: observedAt}, nil
+		}
+	}
+
+	return priceQuote{}, fmt.Errorf("%w %s", ErrSymbolNotFound, symbol)
+}
+
+// parseAssetPrice parses the priceUsd CoinCap listed for symbol. Illiquid
+// assets are listed with an empty or null price, reported as ErrPriceUnavailable.
+func parseAssetPrice(symbol, priceUsd string) (float64, error) {
+	if priceUsd == "" {
+		return 0, fmt.Errorf("%w for symbol %s", ErrPriceUnavailable, symbol)
+	}
+	price, err := strconv.ParseFloat(priceUsd, 64)
+	if err != nil || price < 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+		return 0, fmt.Errorf("malformed price %q for symbol %s", priceUsd, symbol)
+	}
+	return price, nil
+}
+
+var (
+	// ErrPriceUnavailable means the asset is listed but currently has no
+	// price. Errors wrapping it or ErrSymbolNotFound won't clear by asking
+	// again, unlike network or upstream failures.
+	ErrPriceUnavailable = errors.New("no price available")
+
+	// ErrSymbolNotFound means the price source doesn't list the symbol at all
+	ErrSymbolNotFound = errors.New("price data not found for symbol")
+)
+
+// withPriceRetry calls fetch up to priceFetchAttempts times until it
+// succeeds, giving up early if ctx is cancelled
+func withPriceRetry[T any](ctx context.Context, fetch func(context.Context) (T, error)) (T, error) {
+	var result T
+	var err error
+	for attempt := 1; attempt <= priceFetchAttempts; attempt++ {
+		result, err = fetch(ctx)
+		if err == nil || errors.Is(err, ErrPriceUnavailable) {
+			// A missing price won't appear by asking again straight away
+			return result, err
+		}
+		if attempt < priceFetchAttempts && !sleepContext(ctx, priceFetchRetryDelay) {
+			break
+		}
+	}
+	return result, err
+}
+
+// portfolioPage is one page of holdings and the number there are in all
+type portfolioPage struct {
+	XMLName xml.Name    `json:"-" xml:"portfolio"`
+	Items   []Portfolio `json:"items" xml:"items>holding"`
+	Total   int         `json:"total" xml:"total"`
+	Limit   int         `json:"limit" xml:"limit"`
+	Offset  int         `json:"offset" xml:"offset"`
+}
+
+// pageParams parses the limit and offset query parameters, capping limit at
+// maxPageLimit. It writes a 400 and returns false if either is invalid.
+func pageParams(w http.ResponseWriter, r *http.Request) (limit, offset int, ok bool) {
+	limit = defaultPageLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		limit = min(limit, maxPageLimit)
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		var err error
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return 0, 0, false
+		}
+	}
+	return limit, offset, true
+}
+
+// handlePortfolio fetches and displays portfolio data a page at a time,
+// ordered by id, with ?limit= and ?offset=. With ?user_id= only that
+// user's holdings are returned; without it every user's holdings are, as
+// before, so multi-user setups should always pass it. Logged-in users only
+// ever see their own.
+func (s *Server) handlePortfolio(w http.ResponseWriter, r *http.Request) {
+	format, ok := s.responseFormat(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := optionalUserID(w, r)
+	if !ok {
+		return
+	}
+	if userID, ok = scopeUserID(w, r, userID); !ok {
+		return
+	}
+	limit, offset, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	// Fetch portfolio data from the store
+	portfolio, total, err := s.store.ListPortfolio(userID, limit, offset)
+	if err != nil {
+		serverError(w, r, "Error fetching portfolio data", err)
+		return
+	}
+
+	writeEncoded(w, format, http.StatusOK, portfolioPage{
+		Items:  portfolio,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// addHoldingRequest is the body of /portfolio/add
+type addHoldingRequest struct {
+	UserID int     `json:"user_id"`
+	Symbol string  `json:"symbol"`
+	Amount float64 `json:"amount"`
+
+	// CostBasis is the USD price paid per unit, the current price if omitted
+	CostBasis *float64 `json:"cost_basis"`
+}
+
+// handleAddToPortfolio adds cryptocurrency to the portfolio
+func (s *Server) handleAddToPortfolio(w http.ResponseWriter, r *http.Request) {
+	// Parse the request body to extract cryptocurrency data. Unknown fields
+	// are rejected so a misspelled one isn't silently dropped.
+	var req addHoldingRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&req)
+	if err != nil {
+		http.Error(w, "Error parsing request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID, ok := scopeUserID(w, r, req.UserID)
+	if !ok {
+		return
+	}
+	p := Portfolio{UserID: userID, Symbol: normalizeSymbol(req.Symbol), Amount: req.Amount, CostBasis: req.CostBasis}
+	errs := validateHolding(p)
+	if p.UserID <= 0 {
+		errs.add("user_id", "is required and must be positive")
+	}
+	if p.CostBasis != nil && *p.CostBasis < 0 {
+		errs.add("cost_basis", "must not be negative")
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if p.CostBasis == nil {
+		// Without a price the holding is stored with an unknown cost basis
+		if price, err := s.prices.Price(r.Context(), p.Symbol); err == nil {
+			p.CostBasis = &price
+		}
+	}
+
+	// Insert cryptocurrency data into the database
+	err = s.store.AddHolding(p)
+	if err != nil {
+		serverError(w, r, "Error adding cryptocurrency to portfolio", err)
+		return
+	}
+
+	// Set response status code to indicate success
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleUpdatePortfolio replaces the symbol and amount of an existing
+// holding and records when it was updated
+func (s *Server) handleUpdatePortfolio(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var p Portfolio
+	err := json.NewDecoder(r.Body).Decode(&p)
+	if err != nil {
+		http.Error(w, "Error parsing request body", http.StatusBadRequest)
+		return
+	}
+	// Only a logged-in user's own holdings can be updated; otherwise any
+	// holding can, as before accounts existed
+	var ok bool
+	if p.UserID, ok = scopeUserID(w, r, 0); !ok {
+		return
+	}
+	p.Symbol = normalizeSymbol(p.Symbol)
+	errs := validateHolding(p)
+	if p.ID <= 0 {
+		errs.add("id", "is required")
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	p, err = s.store.UpdateHolding(p)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Holding not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error updating portfolio", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(p)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleRemoveFromPortfolio deletes a holding by id. When user_id is given
+// the holding must also belong to that user, as it must to a logged-in one.
+func (s *Server) handleRemoveFromPortfolio(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID     int `json:"id"`
+		UserID int `json:"user_id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Error parsing request body", http.StatusBadRequest)
+		return
+	}
+	if req.ID <= 0 {
+		var errs validationErrors
+		errs.add("id", "is required")
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	userID, ok := scopeUserID(w, r, req.UserID)
+	if !ok {
+		return
+	}
+	deleted, err := s.store.RemoveHolding(req.ID, userID)
+	if err != nil {
+		serverError(w, r, "Error removing cryptocurrency from portfolio", err)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, "Holding not found", http.StatusNotFound)
+		return
+	}
+
+	response := struct {
+		Deleted int64 `json:"deleted"`
+	}{
+		Deleted: deleted,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handlePortfolioValue calculates and displays portfolio value. With
+// ?at= the current holdings are valued at the prices recorded at or before
+// that time instead of live ones.
+func (s *Server) handlePortfolioValue(w http.ResponseWriter, r *http.Request) {
+	// Resolve the encoding, user and display currency before doing any work
+	format, ok := s.responseFormat(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := optionalUserID(w, r)
+	if !ok {
+		return
+	}
+	if userID, ok = scopeUserID(w, r, userID); !ok {
+		return
+	}
+	currency, rate, ok := s.requestRate(w, r, userID)
+	if !ok {
+		return
+	}
+	var at *time.Time
+	if value := r.URL.Query().Get("at"); value != "" {
+		t, err := parseDateParam(value)
+		if err != nil {
+			http.Error(w, "Invalid at time", http.StatusBadRequest)
+			return
+		}
+		if t.After(time.Now()) {
+			http.Error(w, "at must not be in the future", http.StatusBadRequest)
+			return
+		}
+		at = &t
+	}
+
+	// Fetch portfolio data from the database, optionally for a single user
+	h, err := s.store.Holdings(userID)
+	if err != nil {
+		serverError(w, r, "Error fetching portfolio data", err)
+		return
+	}
+
+	// Calculate total portfolio value based on current cryptocurrency prices.
+	// Symbols that still can't be priced after retries are reported back
+	// instead of failing the whole valuation.
+	//
+	// With consistent=true every price comes from a single snapshot by the
+	// first provider that can take one, so the total reflects one moment
+	// rather than a spread of fetches; consistent_prices is false when no
+	// provider could and the prices were fetched separately.
+	// Holdings are always read with a single query, which SQLite serves from
+	// one snapshot: an import or add running concurrently is either fully
+	// included or not at all, never half applied.
+	consistent := r.URL.Query().Get("consistent") == "true"
+	var v valuation
+	if at != nil {
+		var missing []string
+		v, missing, err = s.valueAt(h.bySymbol, *at)
+		if err != nil {
+			serverError(w, r, "Error fetching price history", err)
+			return
+		}
+		if len(missing) > 0 {
+			http.Error(w, "No price recorded at or before "+at.Format(time.RFC3339)+" for "+strings.Join(missing, ", "), http.StatusUnprocessableEntity)
+			return
+		}
+	} else if consistent {
+		v = s.prices.ValueSnapshot(r.Context(), h.bySymbol)
+	} else {
+		v = s.prices.Value(r.Context(), h.bySymbol)
+	}
+	if userID == 0 && at == nil {
+		// Only a full, live valuation knows every user's current holdings
+		updateHoldingMetrics(h.byUser, v.Prices)
+	}
+	if len(v.FailedSymbols) > 0 && len(v.FailedSymbols) == heldSymbols(h.bySymbol) {
+		writePriceError(w, v, v.FailedSymbols)
+		return
+	}
+	v.excludeDust(dustThreshold(r))
+
+	// Create a response object, with amounts in the units the client asked for
+	amount, units := moneyFormatter(r, currency)
+	response := struct {
+		XMLName       xml.Name       `json:"-" xml:"valuation"`
+		TotalValue    money          `json:"total_value" xml:"total_value"`
+		Holdings      []holdingValue `json:"holdings" xml:"holdings>holding"` // Largest value first, dust excluded
+		Currency      string         `json:"currency" xml:"currency"`
+		Units         string         `json:"units" xml:"units"` // "major", or "minor" for integer cents
+		FailedSymbols symbolList     `json:"failed_symbols,omitempty" xml:"failed_symbols,omitempty"`
+		NotFound      symbolList     `json:"not_found_symbols,omitempty" xml:"not_found_symbols,omitempty"` // The failed symbols no price source lists
+		MarketClosed  symbolList     `json:"market_closed,omitempty" xml:"market_closed,omitempty"`         // Valued at the last price before the close
+		DustSymbols   symbolList     `json:"dust_symbols,omitempty" xml:"dust_symbols,omitempty"`           // Included in the total but hidden from breakdowns
+		DustValue     *money         `json:"dust_value,omitempty" xml:"dust_value,omitempty"`
+
+		// UnpricedSymbols are listed by CoinCap without a price and left out of the total
+		UnpricedSymbols symbolList `json:"unpriced_symbols,omitempty" xml:"unpriced_symbols,omitempty"`
+
+		// Prices are the USD quotes behind the total, for tracing discrepancies.
+		// They are diagnostic and always floats, whatever the units.
+		Prices quoteMap `json:"prices" xml:"prices"`
+
+		// AsOf is when the single price snapshot was taken, for consistent reads
+		AsOf *time.Time `json:"as_of,omitempty" xml:"as_of,omitempty"`
+
+		// ConsistentPrices is set for consistent reads: false if no provider
+		// could take a snapshot and the prices may be from different moments
+		ConsistentPrices *bool `json:"consistent_prices,omitempty" xml:"consistent_prices,omitempty"`
+	}{
+		TotalValue:      amount(v.TotalValue / rate),
+		Holdings:        v.breakdown(h.bySymbol, rate, amount),
+		Currency:        currency,
+		Units:           units,
+		FailedSymbols:   v.FailedSymbols,
+		NotFound:        v.NotFound,
+		MarketClosed:    v.MarketClosed,
+		DustSymbols:     v.DustSymbols,
+		UnpricedSymbols: v.Unpriced,
+		Prices:          v.Quotes,
+	}
+	if v.DustValue > 0 {
+		dust := amount(v.DustValue / rate)
+		response.DustValue = &dust
+	}
+	if !v.AsOf.IsZero() && (consistent || at != nil) {
+		response.AsOf = &v.AsOf
+	}
+	if consistent {
+		snapshot := !v.AsOf.IsZero()
+		response.ConsistentPrices = &snapshot
+	}
+
+	status := http.StatusOK
+	if len(v.FailedSymbols) > 0 {
+		status = http.StatusPartialContent
+	}
+	writeEncoded(w, format, status, response)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file holding data and returns its path
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigHonorsAPIBaseURL(t *testing.T) {
+	useConfig(t, &config{})
+	stub := newCoinCapStub(t, map[string]string{"BTC": "65000"})
+
+	loaded, err := loadConfig(writeConfig(t, `{"api_base_url": "`+stub.URL+`/", "poll_interval_seconds": 5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	useConfig(t, loaded)
+
+	if got := coinCapURL("/assets"); got != stub.URL+"/assets" {
+		t.Errorf("coinCapURL = %q, want %q", got, stub.URL+"/assets")
+	}
+	if got := pollInterval(); got != 5*time.Second {
+		t.Errorf("pollInterval = %v, want 5s", got)
+	}
+	price, err := getCoinCapPrice(context.Background(), "BTC")
+	if err != nil || price != 65000 {
+		t.Errorf("price = %v, %v; want 65000 from the stub", price, err)
+	}
+	if n := stub.calls("/assets"); n != 1 {
+		t.Errorf("stub saw %d asset requests, want 1", n)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	loaded, err := loadConfig(writeConfig(t, `{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	useConfig(t, loaded)
+
+	if got := coinCapURL("/assets"); got != defaultAPIBaseURL+"/assets" {
+		t.Errorf("coinCapURL = %q, want the default", got)
+	}
+	if got := pollInterval(); got != defaultPollIntervalSeconds*time.Second {
+		t.Errorf("pollInterval = %v, want the default", got)
+	}
+}
+
+func TestLoadConfigRejectsInvalidAPIBaseURL(t *testing.T) {
+	for _, base := range []string{"ftp://example.com", "not a url", "http://"} {
+		if _, err := loadConfig(writeConfig(t, `{"api_base_url": "`+base+`"}`)); err == nil {
+			t.Errorf("%q: loaded without error", base)
+		}
+	}
+}
+
+func TestLoadConfigThresholdBounds(t *testing.T) {
+	cases := []struct {
+		name, token string
+		ok          bool
+	}{
+		{"threshold only", `"threshold": 100`, true},
+		{"upper only", `"upper_threshold": 100`, true},
+		{"lower only", `"lower_threshold": 50`, true},
+		{"both", `"upper_threshold": 100, "lower_threshold": 50`, true},
+		{"threshold as upper with lower", `"threshold": 100, "lower_threshold": 50`, true},
+		{"lower equal to upper", `"upper_threshold": 100, "lower_threshold": 100`, false},
+		{"lower above upper", `"upper_threshold": 50, "lower_threshold": 100`, false},
+		{"threshold and upper", `"threshold": 100, "upper_threshold": 120`, false},
+	}
+	for _, c := range cases {
+		_, err := loadConfig(writeConfig(t, `{"tokens": [{"name": "Bitcoin", "symbol": "BTC", `+c.token+`}]}`))
+		if (err == nil) != c.ok {
+			t.Errorf("%s: err = %v, want ok %v", c.name, err, c.ok)
+		}
+	}
+}
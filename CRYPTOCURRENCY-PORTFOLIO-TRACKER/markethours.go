@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// marketHours describes when the market behind a tokenized asset is open.
+// Outside these hours CoinCap keeps returning the last traded price, so
+// alerts are suppressed and valuations flag the price as stale.
+type marketHours struct {
+	Timezone string   `json:"timezone"` // IANA zone, e.g. "America/New_York"
+	Open     string   `json:"open"`     // Local opening time, "15:04" format
+	Close    string   `json:"close"`    // Local closing time, "15:04" format
+	Days     []string `json:"days"`     // Trading days ("Mon".."Sun"), defaults to weekdays
+}
+
+var defaultMarketDays = []string{"Mon", "Tue", "Wed", "Thu", "Fri"}
+
+// validate checks that the timezone, times and days can be parsed
+func (m *marketHours) validate() error {
+	if _, err := time.LoadLocation(m.Timezone); err != nil {
+		return fmt.Errorf("invalid market hours timezone %q: %v", m.Timezone, err)
+	}
+	open, err := time.Parse("15:04", m.Open)
+	if err != nil {
+		return fmt.Errorf("invalid market open time %q", m.Open)
+	}
+	closeAt, err := time.Parse("15:04", m.Close)
+	if err != nil {
+		return fmt.Errorf("invalid market close time %q", m.Close)
+	}
+	if !closeAt.After(open) {
+		return fmt.Errorf("market close time %s must be after open time %s", m.Close, m.Open)
+	}
+	for _, day := range m.Days {
+		if _, ok := parseWeekday(day); !ok {
+			return fmt.Errorf("invalid market day %q", day)
+		}
+	}
+	return nil
+}
+
+// isOpen reports whether the market is open at t. Hours are validated when
+// the config is loaded, so a parse failure here just treats it as open.
+func (m *marketHours) isOpen(t time.Time) bool {
+	loc, err := time.LoadLocation(m.Timezone)
+	if err != nil {
+		return true
+	}
+	local := t.In(loc)
+
+	days := m.Days
+	if len(days) == 0 {
+		days = defaultMarketDays
+	}
+	tradingDay := false
+	for _, day := range days {
+		if wd, ok := parseWeekday(day); ok && wd == local.Weekday() {
+			tradingDay = true
+			break
+		}
+	}
+	if !tradingDay {
+		return false
+	}
+
+	open, err1 := time.Parse("15:04", m.Open)
+	closeAt, err2 := time.Parse("15:04", m.Close)
+	if err1 != nil || err2 != nil {
+		return true
+	}
+	minutes := local.Hour()*60 + local.Minute()
+	return minutes >= open.Hour()*60+open.Minute() && minutes < closeAt.Hour()*60+closeAt.Minute()
+}
+
+// parseWeekday parses a three-letter or full English weekday name
+func parseWeekday(day string) (time.Weekday, bool) {
+	day = strings.ToLower(day)
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		name := strings.ToLower(wd.String())
+		if day == name || day == name[:3] {
+			return wd, true
+		}
+	}
+	return 0, false
+}
+
+// marketClosed reports whether symbol has configured market hours and the
+// market is currently closed
+func marketClosed(symbol string, t time.Time) bool {
+	token, ok := tokenConfigFor(symbol)
+	return ok && token.MarketHours != nil && !token.MarketHours.isOpen(t)
+}
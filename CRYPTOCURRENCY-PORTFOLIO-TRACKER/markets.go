@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const marketsCacheTTL = 5 * time.Minute
+
+// marketsCache holds markets per symbol; listings rarely change
+var marketsCache = newTTLCache[[]market](marketsCacheTTL)
+
+// coinCapMarkets is the response of /v2/assets/{id}/markets
+type coinCapMarkets struct {
+	Data []struct {
+		ExchangeID    string `json:"exchangeId"`
+		BaseSymbol    string `json:"baseSymbol"`
+		QuoteSymbol   string `json:"quoteSymbol"`
+		PriceUsd      string `json:"priceUsd"`
+		VolumeUsd24Hr string `json:"volumeUsd24Hr"`
+	} `json:"data"`
+}
+
+// market is an exchange trading pair for an asset
+type market struct {
+	Exchange     string  `json:"exchange"`
+	Pair         string  `json:"pair"`
+	PriceUsd     float64 `json:"price_usd"`
+	VolumeUsd24h float64 `json:"volume_usd_24h"`
+}
+
+// handleMarkets lists the exchanges and pairs a symbol trades on
+func handleMarkets(w http.ResponseWriter, r *http.Request) {
+	symbol := normalizeSymbol(r.URL.Query().Get("symbol"))
+	if symbol == "" {
+		http.Error(w, "Missing symbol", http.StatusBadRequest)
+		return
+	}
+
+	markets, err := getCoinCapMarkets(r.Context(), symbol)
+	if err != nil {
+		serverError(w, r, "Error fetching markets", err)
+		return
+	}
+
+	response := struct {
+		Symbol  string   `json:"symbol"`
+		Markets []market `json:"markets"`
+	}{
+		Symbol:  symbol,
+		Markets: markets,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
+
+// getCoinCapMarkets fetches the markets for symbol, using the cache when fresh
+func getCoinCapMarkets(ctx context.Context, symbol string) ([]market, error) {
+	if markets, ok := marketsCache.get(symbol); ok {
+		return markets, nil
+	}
+
+	id, err := getCoinCapAssetID(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+	var marketData coinCapMarkets
+	err = coinCapGet(ctx, coinCapURL("/assets/"+url.PathEscape(id)+"/markets"), &marketData)
+	if err != nil {
+		return nil, err
+	}
+
+	markets := make([]market, 0, len(marketData.Data))
+	for _, m := range marketData.Data {
+		// Prices and volumes are missing for some inactive pairs
+		price, _ := strconv.ParseFloat(m.PriceUsd, 64)
+		volume, _ := strconv.ParseFloat(m.VolumeUsd24Hr, 64)
+		markets = append(markets, market{
+			Exchange:     m.ExchangeID,
+			Pair:         m.BaseSymbol + "/" + m.QuoteSymbol,
+			PriceUsd:     price,
+			VolumeUsd24h: volume,
+		})
+	}
+
+	marketsCache.set(symbol, markets)
+	return markets, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Per-symbol gauges exposed on /metrics in the Prometheus text format
+var (
+	priceGauge = newGaugeVec("crypto_price_usd",
+		"Last observed price in USD.", "symbol")
+	holdingAmountGauge = newGaugeVec("crypto_holding_amount",
+		"Amount held as of the last portfolio valuation.", "symbol", "user_id")
+	holdingValueGauge = newGaugeVec("crypto_holding_value_usd",
+		"Holding value in USD as of the last portfolio valuation.", "symbol", "user_id")
+)
+
+// Counters and histograms of the tracker's own activity
+var (
+	priceFetchCounter = newCounterVec("crypto_price_fetches_total",
+		"Price polls by monitored symbol and result (success or failure).", "symbol", "result")
+	notificationCounter = newCounterVec("crypto_notifications_total",
+		"Notifications by notifier and result (sent or failed).", "notifier", "result")
+	coinCapLatency = newHistogram("crypto_coincap_request_duration_seconds",
+		"Latency of CoinCap HTTP requests.", []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10})
+)
+
+var processStart = time.Now()
+
+func init() {
+	register(runtimeCollector{})
+}
+
+// collector is anything /metrics renders
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	metricsMu sync.Mutex
+	metrics   []collector
+)
+
+// register adds c to the /metrics handler
+func register(c collector) {
+	metricsMu.Lock()
+	metrics = append(metrics, c)
+	metricsMu.Unlock()
+}
+
+// gaugeVec is a gauge, or with kind "counter" a counter, partitioned by a
+// fixed set of labels
+type gaugeVec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]gaugeSample
+}
+
+type gaugeSample struct {
+	labelValues []string
+	value       float64
+}
+
+// newGaugeVec creates a gauge and registers it with the /metrics handler
+func newGaugeVec(name, help string, labels ...string) *gaugeVec {
+	g := &gaugeVec{name: name, help: help, kind: "gauge", labels: labels, values: make(map[string]gaugeSample)}
+	register(g)
+	return g
+}
+
+// newCounterVec creates a counter and registers it with the /metrics handler
+func newCounterVec(name, help string, labels ...string) *gaugeVec {
+	g := &gaugeVec{name: name, help: help, kind: "counter", labels: labels, values: make(map[string]gaugeSample)}
+	register(g)
+	return g
+}
+
+// inc adds one to the sample of the given label values
+func (g *gaugeVec) inc(labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := g.values[key]
+	g.values[key] = gaugeSample{labelValues: labelValues, value: s.value + 1}
+}
+
+// set records value for the given label values, in the order the labels were declared
+func (g *gaugeVec) set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[strings.Join(labelValues, "\xff")] = gaugeSample{labelValues: labelValues, value: value}
+}
+
+// replace swaps all samples at once so stale label sets disappear
+func (g *gaugeVec) replace(samples []gaugeSample) {
+	values := make(map[string]gaugeSample, len(samples))
+	for _, s := range samples {
+		values[strings.Join(s.labelValues, "\xff")] = s
+	}
+	g.mu.Lock()
+	g.values = values
+	g.mu.Unlock()
+}
+
+// write renders the gauge in the Prometheus text exposition format
+func (g *gaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	keys := make([]string, 0, len(g.values))
+	for k := range g.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	samples := make([]gaugeSample, len(keys))
+	for i, k := range keys {
+		samples[i] = g.values[k]
+	}
+	g.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", g.name, g.help, g.name, g.kind)
+	for _, s := range samples {
+		pairs := make([]string, len(g.labels))
+		for i, label := range g.labels {
+			pairs[i] = label + "=" + strconv.Quote(s.labelValues[i])
+		}
+		fmt.Fprintf(w, "%s{%s} %s\n", g.name, strings.Join(pairs, ","), strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+}
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64 // Upper bounds, ascending
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// newHistogram creates a histogram and registers it with the /metrics handler
+func newHistogram(name, help string, buckets []float64) *histogram {
+	h := &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+	register(h)
+	return h
+}
+
+// observe records one value
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", h.name, strconv.FormatFloat(bound, 'g', -1, 64), counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, count)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", h.name, strconv.FormatFloat(sum, 'g', -1, 64), h.name, count)
+}
+
+// runtimeCollector reports the Go runtime and process metrics Prometheus
+// clients export by default
+type runtimeCollector struct{}
+
+func (runtimeCollector) write(w io.Writer) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	gauges := []struct {
+		name, help string
+		value      float64
+	}{
+		{"go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine())},
+		{"go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(mem.Alloc)},
+		{"go_memstats_heap_objects", "Number of allocated objects.", float64(mem.HeapObjects)},
+		{"go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(mem.Sys)},
+		{"process_start_time_seconds", "Start time of the process since unix epoch in seconds.", float64(processStart.Unix())},
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, strconv.FormatFloat(g.value, 'g', -1, 64))
+	}
+	fmt.Fprintf(w, "# HELP go_gc_cycles_total Number of completed GC cycles.\n# TYPE go_gc_cycles_total counter\ngo_gc_cycles_total %d\n", mem.NumGC)
+	fmt.Fprintf(w, "# HELP go_info Information about the Go environment.\n# TYPE go_info gauge\ngo_info{version=%q} 1\n", runtime.Version())
+}
+
+// handleMetrics serves all registered metrics
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	for _, g := range metrics {
+		g.write(w)
+	}
+}
+
+// updateHoldingMetrics refreshes the per-user holding gauges from a valuation
+func updateHoldingMetrics(userAmounts map[int]map[string]float64, prices map[string]float64) {
+	var amounts, values []gaugeSample
+	for userID, holdings := range userAmounts {
+		user := strconv.Itoa(userID)
+		for symbol, amount := range holdings {
+			labels := []string{symbol, user}
+			amounts = append(amounts, gaugeSample{labelValues: labels, value: amount})
+			if price, ok := prices[symbol]; ok {
+				values = append(values, gaugeSample{labelValues: labels, value: amount * price})
+			}
+		}
+	}
+	holdingAmountGauge.replace(amounts)
+	holdingValueGauge.replace(values)
+}
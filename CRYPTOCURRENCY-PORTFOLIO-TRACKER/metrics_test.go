@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics returns the value of every series on /metrics
+func scrapeMetrics(t *testing.T) map[string]float64 {
+	t.Helper()
+	rec := serve(http.HandlerFunc(handleMetrics), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	series := make(map[string]float64)
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("metric line %q: %v", line, err)
+		}
+		series[line[:i]] = value
+	}
+	return series
+}
+
+func TestMetricsMoveAfterPoll(t *testing.T) {
+	const (
+		fetches = `crypto_price_fetches_total{symbol="MTRX",result="success"}`
+		sent    = `crypto_notifications_total{notifier="recording",result="sent"}`
+		price   = `crypto_price_usd{symbol="MTRX"}`
+	)
+	before := scrapeMetrics(t)
+	monitorSeries(t, tokenConfig{Name: "Metrix", Symbol: "MTRX", Threshold: decimalFromFloat(100)}, 90, 110)
+	after := scrapeMetrics(t)
+
+	if n := after[fetches] - before[fetches]; n < 2 {
+		t.Errorf("%s moved by %v, want at least 2", fetches, n)
+	}
+	if n := after[sent] - before[sent]; n != 1 {
+		t.Errorf("%s moved by %v, want 1", sent, n)
+	}
+	if after[price] != 110 {
+		t.Errorf("%s = %v, want 110", price, after[price])
+	}
+	if _, ok := after["go_goroutines"]; !ok {
+		t.Error("runtime metrics missing")
+	}
+}
+
+func TestMetricsCountCoinCapRequests(t *testing.T) {
+	const requests = "crypto_coincap_request_duration_seconds_count"
+	useConfig(t, &config{})
+	newCoinCapStub(t, map[string]string{"BTC": "65000"})
+
+	before := scrapeMetrics(t)
+	if _, err := getCoinCapPrice(context.Background(), "BTC"); err != nil {
+		t.Fatal(err)
+	}
+	if n := scrapeMetrics(t)[requests] - before[requests]; n != 1 {
+		t.Errorf("%s moved by %v, want 1", requests, n)
+	}
+}
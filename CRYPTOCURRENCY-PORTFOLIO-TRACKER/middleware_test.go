@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestReadOnlyRejectsWritesAndServesReads(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{"BTC": 100})
+	addHoldings(t, store, Portfolio{UserID: 1, Symbol: "BTC", Amount: 1})
+	handler := readOnly(mux)
+
+	writes := []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/portfolio/add", nil),
+		httptest.NewRequest(http.MethodPut, "/portfolio/update", nil),
+		httptest.NewRequest(http.MethodPost, "/portfolio/remove", nil),
+		httptest.NewRequest(http.MethodDelete, "/portfolio/remove", nil),
+		httptest.NewRequest(http.MethodPost, "/portfolio/import", nil),
+	}
+	for _, req := range writes {
+		if rec := serve(handler, req); rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status = %d, want %d", req.Method, req.URL.Path, rec.Code, http.StatusForbidden)
+		}
+	}
+	if _, total, err := store.ListPortfolio(0, 10, 0); err != nil || total != 1 {
+		t.Errorf("holdings changed in read-only mode: %d, %v", total, err)
+	}
+
+	for _, path := range []string{"/portfolio", "/portfolio/value", "/portfolio/pnl"} {
+		if rec := serve(handler, httptest.NewRequest(http.MethodGet, path, nil)); rec.Code != http.StatusOK {
+			t.Errorf("GET %s: status = %d, want %d", path, rec.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestAccessLogRequestID(t *testing.T) {
+	logs := captureLogs(t, slog.LevelInfo)
+	var seen string
+	handler := accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestID(r.Context())
+		http.Error(w, "Holding not found", http.StatusNotFound)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/portfolio/value", nil)
+	req.Header.Set("X-Request-ID", "trace-123")
+	rec := serve(handler, req)
+	if seen != "trace-123" || rec.Header().Get("X-Request-ID") != "trace-123" {
+		t.Errorf("handler saw %q, response header %q; want trace-123 both", seen, rec.Header().Get("X-Request-ID"))
+	}
+	entry, ok := findRecord(logRecords(t, logs), "Request")
+	if !ok {
+		t.Fatal("no access log line")
+	}
+	for k, want := range map[string]any{"request_id": "trace-123", "method": "GET", "path": "/portfolio/value", "status": float64(404)} {
+		if entry[k] != want {
+			t.Errorf("logged %s = %v, want %v", k, entry[k], want)
+		}
+	}
+	if _, ok := entry["duration_ms"]; !ok {
+		t.Error("duration not logged")
+	}
+}
+
+func TestAccessLogGeneratesRequestID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	handler := accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, sent := range []string{"", "has spaces", strings.Repeat("x", maxRequestIDLength+1)} {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		if sent != "" {
+			req.Header.Set("X-Request-ID", sent)
+		}
+		if id := serve(handler, req).Header().Get("X-Request-ID"); !uuid.MatchString(id) {
+			t.Errorf("sent %q: got id %q, want a generated UUID", sent, id)
+		}
+	}
+	a := serve(handler, httptest.NewRequest(http.MethodGet, "/health", nil)).Header().Get("X-Request-ID")
+	b := serve(handler, httptest.NewRequest(http.MethodGet, "/health", nil)).Header().Get("X-Request-ID")
+	if a == b {
+		t.Errorf("two requests got the same id %q", a)
+	}
+}
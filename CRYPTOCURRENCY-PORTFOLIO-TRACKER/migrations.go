@@ -0,0 +1,196 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// migration evolves the schema by one version. Each must be safe to run
+// against a database an older version of the tracker already set up, which
+// had no schema_migrations table.
+type migration func(tx *sql.Tx) error
+
+// migrations are applied in order; a database at version n has had the
+// first n applied. Append new ones, never reorder or edit applied ones.
+var migrations = []migration{
+	createBaseSchema,
+	func(tx *sql.Tx) error { return addColumnIfMissing(tx, "portfolio", "cost_basis", "REAL") },
+	normalizeStoredSymbols,
+	createUserTables,
+	keyThresholdsByHolder,
+}
+
+// migrate brings the database behind handle up to the latest schema
+// version, applying each pending migration in its own transaction along
+// with its version
+func migrate(handle *sql.DB) error {
+	_, err := handle.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+	version, err := schemaVersion(handle)
+	if err != nil {
+		return err
+	}
+	for i := version; i < len(migrations); i++ {
+		if err := applyMigration(handle, i+1, migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		slog.Info("Applied database migration", "component", "db", "version", i+1)
+	}
+	return nil
+}
+
+// schemaVersion returns the number of migrations applied, 0 for a new database
+func schemaVersion(handle *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := handle.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	return int(version.Int64), err
+}
+
+// applyMigration runs m and records version, or neither
+func applyMigration(handle *sql.DB, version int, m migration) error {
+	return withTxOn(handle, func(tx *sql.Tx) error {
+		if err := m(tx); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version)
+		return err
+	})
+}
+
+// createBaseSchema creates the tables as they were before migrations
+func createBaseSchema(tx *sql.Tx) error {
+	stmts := []string{`
+		CREATE TABLE IF NOT EXISTS portfolio (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER,
+			symbol TEXT,
+			amount REAL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP
+		);
+	`, `
+		CREATE TABLE IF NOT EXISTS transactions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER,
+			type TEXT,
+			symbol TEXT,
+			amount REAL,
+			price REAL,
+			fee REAL,
+			occurred_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+	`, `
+		CREATE TABLE IF NOT EXISTS user_settings (
+			user_id INTEGER PRIMARY KEY,
+			preferred_currency TEXT NOT NULL
+		);
+	`, `
+		CREATE TABLE IF NOT EXISTS value_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			total_value REAL,
+			recorded_at TIMESTAMP
+		);
+	`, `
+		CREATE TABLE IF NOT EXISTS holding_thresholds (
+			symbol TEXT PRIMARY KEY,
+			threshold TEXT NOT NULL
+		);
+	`, `
+		CREATE TABLE IF NOT EXISTS price_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			symbol TEXT NOT NULL,
+			price REAL NOT NULL,
+			recorded_at TIMESTAMP NOT NULL
+		);
+	`, `
+		CREATE INDEX IF NOT EXISTS price_history_symbol_time ON price_history (symbol, recorded_at);
+	`, `
+		CREATE TABLE IF NOT EXISTS token_state (
+			symbol TEXT PRIMARY KEY,
+			last_price REAL,
+			triggered INTEGER NOT NULL,
+			threshold TEXT NOT NULL,
+			direction TEXT NOT NULL,
+			checked_at TIMESTAMP
+		);
+	`}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createUserTables adds accounts and their login sessions
+func createUserTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			password_hash TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS sessions (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users (id),
+			expires_at TIMESTAMP NOT NULL
+		);
+	`)
+	return err
+}
+
+// keyThresholdsByHolder keys holding thresholds by user and symbol, so two
+// users holding the same symbol keep their own. A threshold saved before is
+// given to every user holding its symbol, or to user 0 if nobody does.
+func keyThresholdsByHolder(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE holding_thresholds_by_holder (
+			user_id INTEGER NOT NULL,
+			symbol TEXT NOT NULL,
+			threshold TEXT NOT NULL,
+			PRIMARY KEY (user_id, symbol)
+		);
+		INSERT INTO holding_thresholds_by_holder (user_id, symbol, threshold)
+			SELECT DISTINCT COALESCE(p.user_id, 0), t.symbol, t.threshold
+			FROM holding_thresholds t LEFT JOIN portfolio p ON p.symbol = t.symbol;
+		DROP TABLE holding_thresholds;
+		ALTER TABLE holding_thresholds_by_holder RENAME TO holding_thresholds;
+	`)
+	return err
+}
+
+// addColumnIfMissing adds a column to a table created by an older version
+func addColumnIfMissing(tx *sql.Tx, table, column, decl string) error {
+	var n int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + decl)
+	return err
+}
+
+// normalizeStoredSymbols rewrites symbols saved before they were
+// normalized on the way in. A row whose normalized symbol would collide
+// with a unique one already stored is dropped in favor of that one.
+func normalizeStoredSymbols(tx *sql.Tx) error {
+	for _, table := range []string{"portfolio", "transactions", "price_history"} {
+		if _, err := tx.Exec("UPDATE " + table + " SET symbol = UPPER(TRIM(symbol)) WHERE symbol != UPPER(TRIM(symbol))"); err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"holding_thresholds", "token_state"} {
+		if _, err := tx.Exec("UPDATE OR IGNORE " + table + " SET symbol = UPPER(TRIM(symbol)) WHERE symbol != UPPER(TRIM(symbol))"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM " + table + " WHERE symbol != UPPER(TRIM(symbol))"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB opens a fresh, unmigrated database file
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	handle, err := openDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { handle.Close() })
+	return handle
+}
+
+func TestMigrateFromEmptyDatabase(t *testing.T) {
+	handle := openTestDB(t)
+	if err := migrate(handle); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := schemaVersion(handle); err != nil || version != len(migrations) {
+		t.Fatalf("version = %d, %v; want %d", version, err, len(migrations))
+	}
+	if _, err := handle.Exec("INSERT INTO portfolio (user_id, symbol, amount, cost_basis) VALUES (1, 'BTC', 1, 100)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-running applies nothing and keeps the data
+	if err := migrate(handle); err != nil {
+		t.Fatal(err)
+	}
+	if got := columnValues(t, handle, "SELECT version FROM schema_migrations ORDER BY version"); got != fmt.Sprint(versions(len(migrations))) {
+		t.Errorf("applied versions = %s, want each once", got)
+	}
+	if got := columnValues(t, handle, "SELECT symbol FROM portfolio"); got != "[BTC]" {
+		t.Errorf("portfolio = %s after re-running, want [BTC]", got)
+	}
+}
+
+// versions returns the version strings 1 to n
+func versions(n int) []string {
+	v := make([]string, n)
+	for i := range v {
+		v[i] = fmt.Sprint(i + 1)
+	}
+	return v
+}
+
+func TestMigrateDatabaseFromBeforeMigrations(t *testing.T) {
+	handle := openTestDB(t)
+	// The single table the tracker created before it had migrations
+	_, err := handle.Exec(`CREATE TABLE portfolio (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER, symbol TEXT, amount REAL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP
+	); INSERT INTO portfolio (user_id, symbol, amount) VALUES (1, 'eth', 2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(handle); err != nil {
+		t.Fatal(err)
+	}
+	rows, total, err := NewSQLStore(handle).ListPortfolio(1, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || rows[0].Symbol != "ETH" || rows[0].CostBasis != nil {
+		t.Errorf("rows = %+v, want the ETH holding kept with an unknown cost", rows)
+	}
+}
+
+func TestFailedMigrationIsNotRecorded(t *testing.T) {
+	handle := openTestDB(t)
+	if err := migrate(handle); err != nil {
+		t.Fatal(err)
+	}
+	failing := func(tx *sql.Tx) error {
+		if _, err := tx.Exec("CREATE TABLE half_done (id INTEGER)"); err != nil {
+			return err
+		}
+		return errors.New("broken")
+	}
+	if err := applyMigration(handle, len(migrations)+1, failing); err == nil {
+		t.Fatal("failing migration applied")
+	}
+	if version, _ := schemaVersion(handle); version != len(migrations) {
+		t.Errorf("version = %d after the failure, want %d", version, len(migrations))
+	}
+	if got := columnValues(t, handle, "SELECT name FROM sqlite_master WHERE name = 'half_done'"); got != "[]" {
+		t.Errorf("the failed migration's table was kept")
+	}
+}
+
+func TestNormalizeStoredSymbols(t *testing.T) {
+	store := newSQLStore(t)
+	for _, stmt := range []string{
+		"INSERT INTO portfolio (user_id, symbol, amount) VALUES (1, 'btc', 1), (1, ' Eth', 2)",
+		"INSERT INTO holding_thresholds (user_id, symbol, threshold) VALUES (0, 'BTC', '100'), (0, 'btc', '50'), (0, 'sol', '10')",
+	} {
+		if _, err := store.db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := withTxOn(store.db, normalizeStoredSymbols); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := columnValues(t, store.db, "SELECT symbol FROM portfolio ORDER BY id"); got != "[BTC ETH]" {
+		t.Errorf("portfolio symbols = %s, want [BTC ETH]", got)
+	}
+	// The lower case duplicate gives way to the threshold already stored
+	if got := columnValues(t, store.db, "SELECT symbol || '=' || threshold FROM holding_thresholds ORDER BY symbol"); got != "[BTC=100 SOL=10]" {
+		t.Errorf("thresholds = %s, want [BTC=100 SOL=10]", got)
+	}
+}
+
+func TestKeyThresholdsByHolder(t *testing.T) {
+	handle := openTestDB(t)
+	if _, err := handle.Exec("CREATE TABLE schema_migrations (version INTEGER NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	// Stop just short of keying thresholds by holder
+	for i, m := range migrations[:len(migrations)-1] {
+		if err := applyMigration(handle, i+1, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, stmt := range []string{
+		"INSERT INTO portfolio (user_id, symbol, amount) VALUES (1, 'BTC', 1), (2, 'BTC', 2), (2, 'ETH', 3)",
+		"INSERT INTO holding_thresholds (symbol, threshold) VALUES ('BTC', '100'), ('ETH', '10'), ('SOL', '5')",
+	} {
+		if _, err := handle.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrate(handle); err != nil {
+		t.Fatal(err)
+	}
+	// Each holder gets the old threshold of their symbol; nobody holds SOL
+	if got := columnValues(t, handle, "SELECT user_id || ':' || symbol || '=' || threshold FROM holding_thresholds ORDER BY symbol, user_id"); got != "[1:BTC=100 2:BTC=100 2:ETH=10 0:SOL=5]" {
+		t.Errorf("thresholds = %s", got)
+	}
+	if _, err := handle.Exec("INSERT INTO holding_thresholds (user_id, symbol, threshold) VALUES (1, 'ETH', '20')"); err != nil {
+		t.Errorf("a second holder's threshold of ETH was refused: %v", err)
+	}
+}
+
+// columnValues returns the single column query selects, formatted as a list
+func columnValues(t *testing.T, handle *sql.DB, query string) string {
+	t.Helper()
+	rows, err := handle.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, v)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprint(values)
+}
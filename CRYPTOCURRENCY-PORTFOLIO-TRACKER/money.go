@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// minorUnitExponents lists currencies whose minor unit isn't a hundredth
+var minorUnitExponents = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"VND": 0,
+	"BHD": 3,
+	"KWD": 3,
+	"OMR": 3,
+	"BTC": 8, // Satoshis
+}
+
+// minorUnitExponent returns the number of decimal places in currency's
+// minor unit, e.g. 2 for cents
+func minorUnitExponent(currency string) int {
+	if exp, ok := minorUnitExponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// money is an amount in the response currency. It is serialized as a
+// float, or as an integer count of minor units (e.g. cents) when the
+// request asked for them, so clients doing exact money math never have
+// to parse a float.
+type money struct {
+	value    float64
+	exponent int // Decimal places of the minor unit, or -1 for a float
+}
+
+func (m money) MarshalJSON() ([]byte, error) {
+	if m.exponent < 0 {
+		return json.Marshal(m.value)
+	}
+	return json.Marshal(m.minorUnits())
+}
+
+// MarshalText formats m the same way for XML
+func (m money) MarshalText() ([]byte, error) {
+	if m.exponent < 0 {
+		return strconv.AppendFloat(nil, m.value, 'f', -1, 64), nil
+	}
+	return strconv.AppendInt(nil, m.minorUnits(), 10), nil
+}
+
+func (m money) minorUnits() int64 {
+	return int64(math.Round(m.value * math.Pow10(m.exponent)))
+}
+
+// wantsMinorUnits reports whether the request asked for amounts in integer
+// minor units, via ?units=minor or an X-Money-Units: minor header.
+// "cents" is accepted as an alias.
+func wantsMinorUnits(r *http.Request) bool {
+	units := r.URL.Query().Get("units")
+	if units == "" {
+		units = r.Header.Get("X-Money-Units")
+	}
+	units = strings.ToLower(units)
+	return units == "minor" || units == "cents"
+}
+
+// moneyFormatter returns a function building money values for currency in
+// the units the request asked for, along with the name of those units
+func moneyFormatter(r *http.Request, currency string) (func(float64) money, string) {
+	if !wantsMinorUnits(r) {
+		return func(v float64) money { return money{value: v, exponent: -1} }, "major"
+	}
+	exp := minorUnitExponent(currency)
+	return func(v float64) money { return money{value: v, exponent: exp} }, "minor"
+}
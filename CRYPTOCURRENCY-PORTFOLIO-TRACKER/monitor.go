@@ -0,0 +1,547 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPortfolioRefreshMinutes = 5
+	maxFailureBackoff              = 5 * time.Minute // Longest wait between failing fetches
+	defaultDelistedAfter           = 10
+)
+
+// runningMonitor is a monitor goroutine and the config it was started with
+type runningMonitor struct {
+	token  tokenConfig
+	cancel context.CancelFunc
+}
+
+var (
+	monitorsMu sync.Mutex
+	monitors   = make(map[string]*runningMonitor) // Keyed by monitorKey
+)
+
+// reconcileMonitors makes the running monitors match tokens: new tokens
+// are started, removed ones stopped and changed ones restarted
+func (s *Server) reconcileMonitors(ctx context.Context, tokens []tokenConfig) {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+
+	wanted := make(map[string]tokenConfig, len(tokens))
+	for _, token := range tokens {
+		wanted[monitorKey(token.Symbol, token.UserID)] = token
+	}
+
+	for key, m := range monitors {
+		token, ok := wanted[key]
+		if ok && sameTokenConfig(m.token, token) {
+			delete(wanted, key)
+			continue
+		}
+		m.cancel()
+		delete(monitors, key)
+		removeTokenStatus(key)
+	}
+
+	for _, token := range tokens {
+		key := monitorKey(token.Symbol, token.UserID)
+		if _, ok := wanted[key]; !ok {
+			continue
+		}
+		monitorCtx, cancel := context.WithCancel(ctx)
+		monitors[key] = &runningMonitor{token: token, cancel: cancel}
+		wg.Add(1)
+		go s.monitorToken(monitorCtx, token)
+	}
+}
+
+// sameTokenConfig compares two token configs by their JSON form, since
+// decimals and market hours don't compare meaningfully with ==
+func sameTokenConfig(a, b tokenConfig) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
+
+// sleepContext sleeps for d, returning false early if ctx is cancelled
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// monitorToken continuously monitors the price of a token until ctx is cancelled
+func (s *Server) monitorToken(ctx context.Context, token tokenConfig) {
+	defer wg.Done()
+	if !sleepContext(ctx, startupJitter()) {
+		return
+	}
+	startedAt := time.Now()
+	gracePeriod := time.Duration(token.GracePeriodSeconds) * time.Second
+	// alerts holds the state of the upper and lower threshold, by direction
+	alerts := map[string]*alertState{
+		directionAbove: {armed: true},
+		directionBelow: {armed: true},
+	}
+	// restored is the state saved before a restart, applied on the first
+	// poll once the thresholds are known
+	var restored *persistedState
+	// previous is the price from the last successful poll
+	var previous decimal
+	havePrevious := false
+	status := tokenStatus{Symbol: token.Symbol, UserID: token.UserID, Name: token.Name}
+	notFound := 0 // Consecutive lookups that found no such symbol
+
+	// Resume from the state saved before a restart, so a crossing that was
+	// already notified isn't notified again. The saved price only seeds
+	// change alerts; LastPrice and LastChecked wait for a live fetch so
+	// /prices never serves a price from before the restart.
+	saved, ok, err := loadTokenState(s.store, token)
+	if err != nil {
+		reportError("monitor", "Error loading token state", "symbol", token.Symbol, "err", err)
+	}
+	if ok {
+		restored = &saved
+		status.Triggered = saved.Triggered
+		if time.Since(*saved.CheckedAt) < maxRestoredPriceAge {
+			previous, havePrevious = decimalFromFloat(saved.LastPrice), true
+		}
+	}
+	updateTokenStatus(status)
+	for {
+		alertsPaused, pollingPaused := monitoringPaused()
+		if pollingPaused {
+			if !sleepContext(ctx, pollInterval()) {
+				return
+			}
+			continue
+		}
+		if token.MarketHours != nil && !token.MarketHours.isOpen(time.Now()) {
+			// The price is stale while the underlying market is closed
+			if !sleepContext(ctx, pollInterval()) {
+				return
+			}
+			continue
+		}
+		price, err := priceProvider.Price(ctx, token.Symbol)
+		if ctx.Err() != nil {
+			// Stopped mid-fetch, which isn't a provider failure
+			return
+		}
+		if err != nil {
+			if errors.Is(err, ErrSymbolNotFound) {
+				notFound++
+			} else {
+				notFound = 0
+			}
+			priceFetchCounter.inc(token.Symbol, "failure")
+			if !status.Delisted {
+				// Once delisted the same error would only repeat forever
+				reportError("monitor", "Error retrieving price", "symbol", token.Symbol, "provider", providerName(priceProvider), "err", err)
+			}
+			status.LastError = err.Error()
+			status.ConsecutiveFailures++
+			if notFound == delistedAfter() {
+				status.Delisted = true
+				notify(fmt.Sprintf("%s (%s) is no longer listed by any price source after %d lookups.", token.Name, token.Symbol, notFound))
+			}
+			updateTokenStatus(status)
+			if status.Delisted && cfg.StopPollingDelisted {
+				slog.Info("Stopped monitoring delisted token", "component", "monitor", "symbol", token.Symbol)
+				return
+			}
+			if !sleepContext(ctx, failureBackoff(status.ConsecutiveFailures)) {
+				return
+			}
+			continue
+		}
+		priceFetchCounter.inc(token.Symbol, "success")
+		status.ConsecutiveFailures = 0
+		notFound = 0
+		status.Delisted = false
+		priceGauge.set(price, token.Symbol)
+		status.LastPrice = price
+		checkedAt := time.Now().UTC()
+		status.LastChecked = &checkedAt
+		status.LastError = ""
+		slog.Debug("Polled price", "component", "monitor", "symbol", token.Symbol, "price", price)
+		if err := s.store.RecordPrice(token.Symbol, price, checkedAt); err != nil {
+			reportError("monitor", "Error recording price", "symbol", token.Symbol, "price", price, "err", err)
+		}
+
+		bounds, err := s.effectiveBounds(token)
+		if err != nil {
+			reportError("monitor", "Error computing threshold", "symbol", token.Symbol, "price", price, "err", err)
+			if !sleepContext(ctx, pollInterval()) {
+				return
+			}
+			continue
+		}
+		if restored != nil {
+			// Resume as triggered the bound the saved price hadn't cleared,
+			// so a crossing that was already notified isn't notified again
+			for _, b := range bounds {
+				_, cleared := thresholdState(decimalFromFloat(restored.LastPrice), b.Price, token.Hysteresis, b.Below)
+				alerts[b.direction()].triggered = restored.Triggered && !cleared
+			}
+			restored = nil
+		}
+		current := decimalFromFloat(price)
+
+		inGracePeriod := time.Since(startedAt) < gracePeriod
+		if token.ChangeAlert.Sign() > 0 && havePrevious {
+			change := new(big.Rat).Sub(current.rat(), previous.rat())
+			if new(big.Rat).Abs(change).Cmp(token.ChangeAlert.rat()) >= 0 && !inGracePeriod && !alertsPaused {
+				s.alertToken(token.Symbol, price, changeMessage(token.Name, previous, current))
+			}
+		}
+		previous, havePrevious = current, true
+
+		status.Triggered = false
+		for _, b := range bounds {
+			alert := alerts[b.direction()]
+			crossed, cleared := thresholdState(current, b.Price, token.Hysteresis, b.Below)
+			// Notify only on the transition past the threshold; the alert
+			// re-arms once the price is back on the other side of it
+			switch {
+			case crossed && !alert.triggered:
+				slog.Warn("Threshold crossed", "component", "monitor", "symbol", token.Symbol,
+					"price", price, "threshold", b.Price.String(), "direction", b.direction())
+				if inGracePeriod {
+					alert.armed = false
+				}
+				if alert.armed && !alertsPaused {
+					s.alertToken(token.Symbol, price, b.crossedMessage(token, current))
+				}
+				alert.triggered = true
+			case cleared && alert.triggered:
+				slog.Info("Threshold cleared", "component", "monitor", "symbol", token.Symbol,
+					"price", price, "threshold", b.Price.String(), "direction", b.direction())
+				if alert.armed && token.NotifyRecovery && !alertsPaused {
+					s.alertToken(token.Symbol, price, b.recoveredMessage(token, current))
+				}
+				alert.triggered = false
+				alert.armed = true
+			case cleared:
+				alert.armed = true
+			}
+			status.Triggered = status.Triggered || alert.triggered
+		}
+		updateTokenStatus(status)
+		if err := saveTokenState(s.store, token, status); err != nil {
+			reportError("monitor", "Error saving token state", "symbol", token.Symbol, "price", price, "err", err)
+		}
+		if !sleepContext(ctx, pollInterval()) {
+			return
+		}
+	}
+}
+
+// changeMessage describes the move from previous to current for a change
+// alert, as a rise or fall by the absolute amount
+func changeMessage(name string, previous, current decimal) string {
+	change := new(big.Rat).Sub(current.rat(), previous.rat())
+	verb := "rose"
+	if change.Sign() < 0 {
+		verb = "fell"
+	}
+	return fmt.Sprintf("%s price %s $%s since the last check ($%s -> $%s)!",
+		name, verb, new(big.Rat).Abs(change).FloatString(2), previous, current)
+}
+
+// thresholdState compares price with threshold. crossed reports whether the
+// alert condition holds; cleared whether the price is back past the
+// threshold by at least the hysteresis margin, re-arming the alert.
+func thresholdState(price, threshold, hysteresis decimal, below bool) (crossed, cleared bool) {
+	rearmAt := new(big.Rat)
+	if below {
+		crossed = price.Cmp(threshold) < 0
+		rearmAt.Add(threshold.rat(), hysteresis.rat())
+		cleared = price.rat().Cmp(rearmAt) >= 0
+	} else {
+		crossed = price.Cmp(threshold) > 0
+		rearmAt.Sub(threshold.rat(), hysteresis.rat())
+		cleared = price.rat().Cmp(rearmAt) <= 0
+	}
+	return crossed, cleared
+}
+
+// delistedAfter is how many consecutive not found lookups mark a symbol delisted
+func delistedAfter() int {
+	if cfg.DelistedAfter <= 0 {
+		return defaultDelistedAfter
+	}
+	return cfg.DelistedAfter
+}
+
+// failureBackoff is how long a monitor waits after its nth consecutive
+// failed fetch: the poll interval, doubling each time up to maxFailureBackoff
+func failureBackoff(failures int) time.Duration {
+	delay := pollInterval()
+	for i := 1; i < failures && delay < maxFailureBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxFailureBackoff)
+}
+
+// alertToken notifies msg about symbol, weighted by the size of the position
+func (s *Server) alertToken(symbol string, price float64, msg string) {
+	if msg, ok := s.weightAlert(symbol, price, msg); ok {
+		notify(msg)
+	}
+}
+
+// alertState tracks one threshold of a monitored token between polls
+type alertState struct {
+	triggered bool
+	// armed is false while the alert condition has held since it was
+	// first seen during the grace period, so that crossing is never notified
+	armed bool
+}
+
+// priceBound is a threshold resolved to the price it alerts at
+type priceBound struct {
+	Price decimal
+	Below bool // Alert when the price falls under Price rather than rises past it
+}
+
+func (b priceBound) direction() string {
+	if b.Below {
+		return directionBelow
+	}
+	return directionAbove
+}
+
+func (b priceBound) crossedMessage(token tokenConfig, current decimal) string {
+	if b.Below {
+		return fmt.Sprintf("%s price ($%s) has fallen below threshold ($%s)!", token.Name, current, b.Price)
+	}
+	return fmt.Sprintf("%s price ($%s) is above threshold ($%s)!", token.Name, current, b.Price)
+}
+
+func (b priceBound) recoveredMessage(token tokenConfig, current decimal) string {
+	if b.Below {
+		return fmt.Sprintf("%s price ($%s) has recovered above threshold ($%s).", token.Name, current, b.Price)
+	}
+	return fmt.Sprintf("%s price ($%s) has recovered below threshold ($%s).", token.Name, current, b.Price)
+}
+
+// effectiveBounds returns the prices the token is compared against: its
+// upper threshold, then its lower one, for those that are configured. In
+// cost_percent mode each threshold is a signed percentage of the average
+// cost of the holding: +50 alerts at 150% of cost, -20 at 80% of cost. A
+// lone cost_percent threshold alerts in the direction of its sign.
+func (s *Server) effectiveBounds(token tokenConfig) ([]priceBound, error) {
+	upper, lower := token.thresholds()
+	if token.ThresholdMode == thresholdModeCostPercent && token.UpperThreshold == nil && token.LowerThreshold == nil {
+		upper, lower = &token.Threshold, nil
+		if token.Threshold.Sign() < 0 {
+			upper, lower = nil, &token.Threshold
+		}
+	}
+	var bounds []priceBound
+	for _, b := range []struct {
+		threshold *decimal
+		below     bool
+	}{{upper, false}, {lower, true}} {
+		if b.threshold == nil {
+			continue
+		}
+		price, err := s.thresholdPrice(token, *b.threshold)
+		if err != nil {
+			return nil, err
+		}
+		bounds = append(bounds, priceBound{Price: price, Below: b.below})
+	}
+	return bounds, nil
+}
+
+// thresholdPrice converts a configured threshold to a price
+func (s *Server) thresholdPrice(token tokenConfig, threshold decimal) (decimal, error) {
+	if token.ThresholdMode != thresholdModeCostPercent {
+		return threshold, nil
+	}
+	avgCost, err := s.averageCost(token.Symbol)
+	if err != nil {
+		return decimal{}, err
+	}
+	return decimalFromFloat(avgCost * (1 + threshold.Float64()/100)), nil
+}
+
+// startupJitter returns a random delay before a monitor's first poll
+func startupJitter() time.Duration {
+	window := time.Duration(cfg.StartupJitterSeconds) * time.Second
+	if cfg.StartupJitterSeconds == 0 {
+		window = pollInterval()
+	}
+	if window <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(window)))
+}
+
+// portfolioRefreshInterval is how often the portfolio watchlist is rebuilt
+func portfolioRefreshInterval() time.Duration {
+	if cfg.PortfolioRefreshMinutes <= 0 {
+		return defaultPortfolioRefreshMinutes * time.Minute
+	}
+	return time.Duration(cfg.PortfolioRefreshMinutes) * time.Minute
+}
+
+// syncPortfolioWatchlist monitors each threshold set on a symbol its user
+// still holds, starting and stopping monitors as holdings change
+func (s *Server) syncPortfolioWatchlist(ctx context.Context) error {
+	thresholds, err := s.store.HoldingThresholds(0)
+	if err != nil {
+		return err
+	}
+	h, err := s.store.Holdings(0)
+	if err != nil {
+		return err
+	}
+
+	var tokens []tokenConfig
+	for _, t := range thresholds {
+		if h.byUser[t.UserID][t.Symbol] > 0 {
+			tokens = append(tokens, tokenConfig{Name: holderName(t.Symbol, t.UserID), Symbol: t.Symbol, Threshold: t.Threshold, UserID: t.UserID})
+		}
+	}
+	s.reconcileMonitors(ctx, tokens)
+	return nil
+}
+
+// holderName names a holding in alerts: the symbol, with the user it
+// belongs to unless that is user 0
+func holderName(symbol string, userID int) string {
+	if userID == 0 {
+		return symbol
+	}
+	return fmt.Sprintf("%s (user %d)", symbol, userID)
+}
+
+// holdingThreshold is the alert threshold a user set for a symbol they hold
+type holdingThreshold struct {
+	UserID    int     `json:"user_id"`
+	Symbol    string  `json:"symbol"`
+	Threshold decimal `json:"threshold"`
+}
+
+// handleHoldingThresholds lists (GET) or sets (POST) the thresholds used
+// when monitoring the portfolio, each user's their own. Posting a zero
+// threshold removes it.
+func (s *Server) handleHoldingThresholds(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userID, ok := optionalUserID(w, r)
+		if !ok {
+			return
+		}
+		if userID, ok = scopeUserID(w, r, userID); !ok {
+			return
+		}
+		thresholds, err := s.store.HoldingThresholds(userID)
+		if err != nil {
+			serverError(w, r, "Error fetching thresholds", err)
+			return
+		}
+		if thresholds == nil {
+			thresholds = []holdingThreshold{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(thresholds)
+		if err != nil {
+			http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+			return
+		}
+
+	case http.MethodPost:
+		var t holdingThreshold
+		err := json.NewDecoder(r.Body).Decode(&t)
+		if err != nil {
+			http.Error(w, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+		userID, ok := scopeUserID(w, r, t.UserID)
+		if !ok {
+			return
+		}
+		t.Symbol = normalizeSymbol(t.Symbol)
+		var errs validationErrors
+		if t.Symbol == "" {
+			errs.add("symbol", "is required")
+		}
+		if t.Threshold.Sign() < 0 {
+			errs.add("threshold", "must not be negative")
+		}
+		if len(errs) > 0 {
+			writeValidationErrors(w, errs)
+			return
+		}
+
+		if err := s.store.SetHoldingThreshold(userID, t.Symbol, t.Threshold); err != nil {
+			serverError(w, r, "Error saving threshold", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *SQLStore) HoldingThresholds(userID int) ([]holdingThreshold, error) {
+	query := "SELECT user_id, symbol, threshold FROM holding_thresholds"
+	var args []any
+	if userID != 0 {
+		query += " WHERE user_id = ?"
+		args = append(args, userID)
+	}
+	rows, err := s.db.Query(query+" ORDER BY symbol, user_id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var thresholds []holdingThreshold
+	for rows.Next() {
+		var t holdingThreshold
+		var threshold string
+		if err := rows.Scan(&t.UserID, &t.Symbol, &threshold); err != nil {
+			return nil, err
+		}
+		if t.Threshold, err = parseDecimal(threshold); err != nil {
+			slog.Warn("Ignoring invalid threshold", "component", "monitor", "symbol", t.Symbol, "threshold", threshold)
+			continue
+		}
+		thresholds = append(thresholds, t)
+	}
+	return thresholds, rows.Err()
+}
+
+func (s *SQLStore) SetHoldingThreshold(userID int, symbol string, threshold decimal) error {
+	var err error
+	if threshold.IsZero() {
+		_, err = execWriteOn(s.db, "DELETE FROM holding_thresholds WHERE user_id = ? AND symbol = ?", userID, symbol)
+	} else {
+		_, err = execWriteOn(s.db,
+			"INSERT INTO holding_thresholds (user_id, symbol, threshold) VALUES (?, ?, ?) ON CONFLICT(user_id, symbol) DO UPDATE SET threshold = excluded.threshold",
+			userID, symbol, threshold.String(),
+		)
+	}
+	return err
+}
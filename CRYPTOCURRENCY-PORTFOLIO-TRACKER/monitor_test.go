@@ -0,0 +1,516 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gatedProvider prices every symbol at price, but each fetch announces
+// itself on fetching and then waits for a value on release
+type gatedProvider struct {
+	price    float64
+	fetching chan struct{}
+	release  chan struct{}
+}
+
+func (g gatedProvider) Price(ctx context.Context, symbol string) (float64, error) {
+	g.fetching <- struct{}{}
+	select {
+	case <-g.release:
+		return g.price, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (g gatedProvider) Prices(ctx context.Context, symbols []string) (map[string]float64, error) {
+	prices := make(map[string]float64, len(symbols))
+	for _, symbol := range symbols {
+		price, err := g.Price(ctx, symbol)
+		if err != nil {
+			return nil, err
+		}
+		prices[symbol] = price
+	}
+	return prices, nil
+}
+
+// pricesStatus returns the status of /prices?symbol=symbol
+func pricesStatus(symbol string) int {
+	return serve(http.HandlerFunc(handlePrices), httptest.NewRequest(http.MethodGet, "/prices?symbol="+symbol, nil)).Code
+}
+
+func TestPricesWaitForLiveFetchAfterRestart(t *testing.T) {
+	token := tokenConfig{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(100000)}
+	threshold, direction := token.thresholdKey()
+	savedAt := time.Now().Add(-time.Minute).UTC()
+	store := &fakeStore{states: map[string]persistedState{
+		"BTC": {LastPrice: 50000, CheckedAt: &savedAt, Threshold: threshold, Direction: direction},
+	}}
+	s, _ := newTestServer(t, store, fakePrices{})
+	cfg.StartupJitterSeconds = -1
+
+	provider := gatedProvider{price: 65000, fetching: make(chan struct{}), release: make(chan struct{})}
+	useProvider(t, provider)
+
+	startMonitor(t, s, token)
+	<-provider.fetching
+	if code := pricesStatus("BTC"); code != http.StatusNotFound {
+		t.Errorf("before the first fetch: status = %d, want %d", code, http.StatusNotFound)
+	}
+
+	provider.release <- struct{}{}
+	waitFor(t, "the first fetch", func() bool { return tokenStatusOf("BTC").LastChecked != nil })
+	if snapshot, ok := latestPrices()["BTC"]; !ok || snapshot.Price != 65000 {
+		t.Errorf("after the first fetch: got %+v, want the live price 65000", snapshot)
+	}
+}
+
+func TestPricesReflectMonitorUpdates(t *testing.T) {
+	s, _ := newTestServer(t, &fakeStore{}, fakePrices{})
+	cfg.StartupJitterSeconds = -1
+	useProvider(t, staticProvider{"BTC": 65000, "ETH": 3000})
+	before := time.Now()
+
+	// Both monitors stop before either is waited for
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+		removeTokenStatus("BTC")
+		removeTokenStatus("ETH")
+	})
+	for _, token := range []tokenConfig{
+		{Name: "Ethereum", Symbol: "ETH", Threshold: decimalFromFloat(5000)},
+		{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(100000)},
+	} {
+		wg.Add(1)
+		go s.monitorToken(ctx, token)
+	}
+	waitFor(t, "both fetches", func() bool {
+		return tokenStatusOf("BTC").LastChecked != nil && tokenStatusOf("ETH").LastChecked != nil
+	})
+
+	rec := serve(http.HandlerFunc(handlePrices), httptest.NewRequest(http.MethodGet, "/prices", nil))
+	var list []PriceSnapshot
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Symbol != "BTC" || list[0].Price != 65000 || list[1].Symbol != "ETH" || list[1].Price != 3000 {
+		t.Fatalf("/prices = %+v, want BTC then ETH at their fetched prices", list)
+	}
+	if list[0].Time.Before(before) {
+		t.Errorf("BTC fetched at %v, before the monitor started", list[0].Time)
+	}
+
+	rec = serve(http.HandlerFunc(handlePrices), httptest.NewRequest(http.MethodGet, "/prices?symbol=eth", nil))
+	var one PriceSnapshot
+	if err := json.NewDecoder(rec.Body).Decode(&one); err != nil || one.Symbol != "ETH" || one.Price != 3000 {
+		t.Errorf("/prices?symbol=eth = %+v, %v", one, err)
+	}
+	if code := pricesStatus("DOGE"); code != http.StatusNotFound {
+		t.Errorf("unmonitored symbol: status = %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestFailureBackoffDoublesUpToTheCap(t *testing.T) {
+	useConfig(t, &config{PollIntervalSeconds: 10})
+	want := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second, 160 * time.Second}
+	for i, w := range want {
+		if got := failureBackoff(i + 1); got != w {
+			t.Errorf("failureBackoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+	if got := failureBackoff(50); got != maxFailureBackoff {
+		t.Errorf("failureBackoff(50) = %v, want the cap %v", got, maxFailureBackoff)
+	}
+}
+
+// scriptedProvider answers each fetch with the next of errs, nil meaning a
+// success at price, then keeps succeeding
+type scriptedProvider struct {
+	staticProvider
+
+	mu   sync.Mutex
+	errs []error
+}
+
+func (p *scriptedProvider) Price(ctx context.Context, symbol string) (float64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		if err != nil {
+			return 0, err
+		}
+	}
+	return p.staticProvider.Price(ctx, symbol)
+}
+
+// tokenStatusOf returns the monitor status of symbol
+func tokenStatusOf(symbol string) tokenStatus {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+	return tokenStatuses[symbol]
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// startMonitor runs s.monitorToken for token until the test ends
+func startMonitor(t *testing.T, s *Server, token tokenConfig) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+		removeTokenStatus(token.Symbol)
+	})
+	wg.Add(1)
+	go s.monitorToken(ctx, token)
+}
+
+// usePollUnit makes each poll_interval_seconds last unit until the test ends
+func usePollUnit(t *testing.T, unit time.Duration) {
+	old := pollUnit
+	pollUnit = unit
+	t.Cleanup(func() { pollUnit = old })
+}
+
+func TestMonitorResetsFailuresAfterSuccess(t *testing.T) {
+	s, _ := newTestServer(t, &fakeStore{}, fakePrices{})
+	cfg.StartupJitterSeconds = -1
+	cfg.PollIntervalSeconds = 1
+	usePollUnit(t, 100*time.Millisecond) // Long enough to see the failure first
+	useProvider(t, &scriptedProvider{
+		staticProvider: staticProvider{"BTC": 100},
+		errs:           []error{errors.New("down")},
+	})
+
+	startMonitor(t, s, tokenConfig{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(1000)})
+	waitFor(t, "the failure", func() bool { return tokenStatusOf("BTC").ConsecutiveFailures == 1 })
+	waitFor(t, "the success after the backoff", func() bool { return tokenStatusOf("BTC").LastChecked != nil })
+	if st := tokenStatusOf("BTC"); st.ConsecutiveFailures != 0 || st.LastError != "" {
+		t.Errorf("status after success = %+v, want failures reset", st)
+	}
+}
+
+// unlistedProvider lists no symbols at all, counting the lookups
+type unlistedProvider struct {
+	staticProvider
+	lookups *atomic.Int32
+}
+
+func (p unlistedProvider) Price(ctx context.Context, symbol string) (float64, error) {
+	p.lookups.Add(1)
+	return p.staticProvider.Price(ctx, symbol)
+}
+
+func TestMonitorStopsPollingDelistedToken(t *testing.T) {
+	s, _ := newTestServer(t, &fakeStore{}, fakePrices{})
+	cfg.StartupJitterSeconds = -1
+	cfg.PollIntervalSeconds = 1
+	usePollUnit(t, time.Millisecond)
+	cfg.DelistedAfter = 2
+	cfg.StopPollingDelisted = true
+	n := &recordingNotifier{}
+	q := useNotifier(t, n, 10)
+	useProvider(t, unlistedProvider{lookups: new(atomic.Int32)})
+	t.Cleanup(func() { removeTokenStatus("GONE") })
+
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		s.monitorToken(context.Background(), tokenConfig{Name: "Gone", Symbol: "GONE", Threshold: decimalFromFloat(1)})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("monitor kept polling a delisted token")
+	}
+
+	if st := tokenStatusOf("GONE"); !st.Delisted || st.ConsecutiveFailures != 2 {
+		t.Errorf("status = %+v, want delisted after 2 failures", st)
+	}
+	q.close(context.Background())
+	if got := n.sent(); len(got) != 1 {
+		t.Errorf("sent %q, want a single delisting notification", got)
+	}
+}
+
+func TestMonitorNotifiesDelistingOnce(t *testing.T) {
+	s, _ := newTestServer(t, &fakeStore{}, fakePrices{})
+	cfg.StartupJitterSeconds = -1
+	cfg.PollIntervalSeconds = 1
+	usePollUnit(t, time.Millisecond)
+	cfg.DelistedAfter = 1
+	n := &recordingNotifier{}
+	q := useNotifier(t, n, 10)
+	lookups := new(atomic.Int32)
+	useProvider(t, unlistedProvider{lookups: lookups})
+	t.Cleanup(func() { removeTokenStatus("GONE") })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg.Add(1)
+	go s.monitorToken(ctx, tokenConfig{Name: "Gone", Symbol: "GONE", Threshold: decimalFromFloat(1)})
+	waitFor(t, "a second lookup", func() bool { return lookups.Load() >= 2 })
+	cancel()
+	wg.Wait()
+
+	if st := tokenStatusOf("GONE"); !st.Delisted {
+		t.Errorf("status = %+v, want delisted", st)
+	}
+	q.close(context.Background())
+	if got := n.sent(); len(got) != 1 || got[0] != "Gone (GONE) is no longer listed by any price source after 1 lookups." {
+		t.Errorf("sent %q, want a single delisting notification", got)
+	}
+}
+
+// seriesProvider prices every fetch with the next of prices, repeating the
+// last one once they run out. It closes done on the fetch after the last
+// price, by when that price has been handled.
+type seriesProvider struct {
+	staticProvider
+
+	mu      sync.Mutex
+	prices  []float64
+	fetches int
+	done    chan struct{}
+}
+
+func (p *seriesProvider) Price(ctx context.Context, symbol string) (float64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	price := p.prices[min(p.fetches, len(p.prices)-1)]
+	p.fetches++
+	if p.fetches == len(p.prices)+1 {
+		close(p.done)
+	}
+	return price, nil
+}
+
+// monitorSeries runs a monitor of token through prices, one per poll, and
+// returns the notifications sent
+func monitorSeries(t *testing.T, token tokenConfig, prices ...float64) []string {
+	t.Helper()
+	s, _ := newTestServer(t, &fakeStore{}, fakePrices{})
+	cfg.StartupJitterSeconds = -1
+	cfg.PollIntervalSeconds = 1
+	usePollUnit(t, time.Millisecond)
+	n := &recordingNotifier{}
+	q := useNotifier(t, n, 10)
+	provider := &seriesProvider{prices: prices, done: make(chan struct{})}
+	useProvider(t, provider)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := func() {
+		cancel()
+		wg.Wait()
+	}
+	t.Cleanup(func() {
+		stop()
+		removeTokenStatus(token.Symbol)
+	})
+	wg.Add(1)
+	go s.monitorToken(ctx, token)
+	select {
+	case <-provider.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the price series")
+	}
+	stop()
+	q.close(context.Background())
+	return n.sent()
+}
+
+func TestMonitorNotifiesOncePerCrossing(t *testing.T) {
+	token := tokenConfig{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(100)}
+	got := monitorSeries(t, token, 90, 110, 120)
+	want := "Bitcoin price ($110) is above threshold ($100)!"
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("sent %q, want [%q]", got, want)
+	}
+}
+
+func TestMonitorRearmsAfterDroppingBack(t *testing.T) {
+	token := tokenConfig{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(100)}
+	got := monitorSeries(t, token, 90, 110, 120, 90, 105)
+	if len(got) != 2 || got[0] != "Bitcoin price ($110) is above threshold ($100)!" ||
+		got[1] != "Bitcoin price ($105) is above threshold ($100)!" {
+		t.Errorf("sent %q, want one notification per crossing", got)
+	}
+}
+
+func TestMonitorHysteresisIgnoresFlapping(t *testing.T) {
+	token := tokenConfig{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(100), Hysteresis: decimalFromFloat(10)}
+	// 95 is back under the threshold but not by the margin, so 105 doesn't
+	// notify again; 85 re-arms the alert
+	got := monitorSeries(t, token, 110, 95, 105, 85, 101)
+	if len(got) != 2 || got[1] != "Bitcoin price ($101) is above threshold ($100)!" {
+		t.Errorf("sent %q, want notifications at 110 and 101 only", got)
+	}
+}
+
+func TestMonitorChangeAlertsSayWhichWay(t *testing.T) {
+	token := tokenConfig{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(1000000), ChangeAlert: decimalFromFloat(100)}
+	got := monitorSeries(t, token, 1000, 1200, 1076.55, 1100)
+	want := []string{
+		"Bitcoin price rose $200.00 since the last check ($1000 -> $1200)!",
+		"Bitcoin price fell $123.45 since the last check ($1200 -> $1076.55)!",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+}
+
+func TestThresholdState(t *testing.T) {
+	cases := []struct {
+		price            float64
+		below            bool
+		crossed, cleared bool
+	}{
+		{101, false, true, false},
+		{100, false, false, false},
+		{95, false, false, true},
+		{99, true, true, false},
+		{104, true, false, false},
+		{105, true, false, true},
+	}
+	for _, c := range cases {
+		crossed, cleared := thresholdState(decimalFromFloat(c.price), decimalFromFloat(100), decimalFromFloat(5), c.below)
+		if crossed != c.crossed || cleared != c.cleared {
+			t.Errorf("%v (below %v): got crossed %v, cleared %v; want %v, %v", c.price, c.below, crossed, cleared, c.crossed, c.cleared)
+		}
+	}
+}
+
+func TestMonitorNotifiesEachBoundInItsOwnWords(t *testing.T) {
+	upper, lower := decimalFromFloat(120), decimalFromFloat(80)
+	token := tokenConfig{Name: "Bitcoin", Symbol: "BTC", UpperThreshold: &upper, LowerThreshold: &lower}
+	got := monitorSeries(t, token, 100, 130, 100, 70)
+	want := []string{
+		"Bitcoin price ($130) is above threshold ($120)!",
+		"Bitcoin price ($70) has fallen below threshold ($80)!",
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+}
+
+func TestMonitorLowerBoundOnly(t *testing.T) {
+	lower := decimalFromFloat(80)
+	token := tokenConfig{Name: "Bitcoin", Symbol: "BTC", LowerThreshold: &lower}
+	got := monitorSeries(t, token, 130, 70)
+	if len(got) != 1 || got[0] != "Bitcoin price ($70) has fallen below threshold ($80)!" {
+		t.Errorf("sent %q, want only the lower bound's notification", got)
+	}
+}
+
+// stopsPromptly cancels a running monitor and fails unless it returns soon
+func stopsPromptly(t *testing.T, cancel context.CancelFunc, done <-chan struct{}) {
+	t.Helper()
+	start := time.Now()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("monitor kept running after its context was cancelled")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("monitor took %v to stop", elapsed)
+	}
+}
+
+func TestMonitorStopsOnCancel(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		release bool // Let the first fetch finish, cancelling in the poll interval
+	}{{"mid-fetch", false}, {"between polls", true}} {
+		t.Run(c.name, func(t *testing.T) {
+			s, _ := newTestServer(t, &fakeStore{}, fakePrices{})
+			cfg.StartupJitterSeconds = -1
+			provider := gatedProvider{price: 100, fetching: make(chan struct{}), release: make(chan struct{})}
+			useProvider(t, provider)
+			t.Cleanup(func() { removeTokenStatus("BTC") })
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan struct{})
+			wg.Add(1)
+			go func() {
+				s.monitorToken(ctx, tokenConfig{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(1000)})
+				close(done)
+			}()
+			<-provider.fetching
+			if c.release {
+				provider.release <- struct{}{}
+				waitFor(t, "the first fetch", func() bool { return tokenStatusOf("BTC").LastChecked != nil })
+			}
+			stopsPromptly(t, cancel, done)
+		})
+	}
+}
+
+func TestPortfolioWatchlistKeepsEachHoldersThreshold(t *testing.T) {
+	store := newSQLStore(t)
+	s, mux := newTestServer(t, store, fakePrices{})
+	addHoldings(t, store,
+		Portfolio{UserID: 1, Symbol: "BTC", Amount: 1},
+		Portfolio{UserID: 2, Symbol: "BTC", Amount: 2},
+	)
+	for userID, threshold := range map[int]string{1: "100000", 2: "50000"} {
+		req := httptest.NewRequest(http.MethodPost, "/portfolio/thresholds", strings.NewReader(`{"symbol": "btc", "threshold": "`+threshold+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if rec := serve(mux, asUser(req, userID)); rec.Code != http.StatusNoContent {
+			t.Fatalf("user %d: status = %d, body %s", userID, rec.Code, rec.Body)
+		}
+	}
+
+	// The monitors stop straight away; only which ones start matters
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	t.Cleanup(func() {
+		s.reconcileMonitors(ctx, nil)
+		wg.Wait()
+	})
+	if err := s.syncPortfolioWatchlist(ctx); err != nil {
+		t.Fatal(err)
+	}
+	monitorsMu.Lock()
+	var running []string
+	for key, m := range monitors {
+		running = append(running, key+"="+m.token.Threshold.String())
+	}
+	monitorsMu.Unlock()
+	sort.Strings(running)
+	if got := strings.Join(running, " "); got != "BTC:1=100000 BTC:2=50000" {
+		t.Errorf("monitors = %s, want one per holder with their own threshold", got)
+	}
+
+	rec := serve(mux, asUser(httptest.NewRequest(http.MethodGet, "/portfolio/thresholds", nil), 2))
+	var thresholds []holdingThreshold
+	if err := json.NewDecoder(rec.Body).Decode(&thresholds); err != nil {
+		t.Fatal(err)
+	}
+	if len(thresholds) != 1 || thresholds[0].UserID != 2 || thresholds[0].Threshold.String() != "50000" {
+		t.Errorf("user 2's thresholds = %+v, want only their own", thresholds)
+	}
+}
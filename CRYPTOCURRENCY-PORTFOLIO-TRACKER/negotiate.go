@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Response encodings a read handler can negotiate
+const (
+	formatJSON = "application/json"
+	formatXML  = "application/xml"
+)
+
+// acceptedFormats maps the media ranges understood in an Accept header to
+// the encoding they select. Wildcards get the default, JSON.
+var acceptedFormats = map[string]string{
+	"application/json": formatJSON,
+	"application/xml":  formatXML,
+	"text/xml":         formatXML,
+	"application/*":    formatJSON,
+	"*/*":              formatJSON,
+}
+
+// responseFormat picks the response encoding from the Accept header: the
+// supported type with the highest q, the first listed on a tie. Without a
+// match it falls back to JSON, or with strictAccept set writes a 406 and
+// returns false.
+func (s *Server) responseFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return formatJSON, true
+	}
+
+	format, best := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		f, ok := acceptedFormats[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > best {
+			format, best = f, q
+		}
+	}
+	if format != "" {
+		return format, true
+	}
+	if s.strictAccept {
+		http.Error(w, "Not acceptable; supported types are application/json and application/xml", http.StatusNotAcceptable)
+		return "", false
+	}
+	return formatJSON, true
+}
+
+// writeEncoded writes v in format with the given status
+func writeEncoded(w http.ResponseWriter, format string, status int, v any) {
+	w.Header().Set("Content-Type", format)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+
+	var err error
+	if format == formatXML {
+		if _, err = w.Write([]byte(xml.Header)); err == nil {
+			err = xml.NewEncoder(w).Encode(v)
+		}
+	} else {
+		err = json.NewEncoder(w).Encode(v)
+	}
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+	}
+}
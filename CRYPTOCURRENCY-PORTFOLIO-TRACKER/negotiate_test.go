@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// negotiated sends a GET for path with the given Accept header
+func negotiated(handler http.Handler, path, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	return serve(handler, req)
+}
+
+func TestPortfolioAsXMLOrJSON(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{})
+	addHoldings(t, store, Portfolio{UserID: 1, Symbol: "BTC", Amount: 1.5})
+
+	rec := negotiated(mux, "/portfolio", "application/xml")
+	if ct := rec.Header().Get("Content-Type"); ct != formatXML {
+		t.Errorf("XML: Content-Type = %q", ct)
+	}
+	if !strings.HasPrefix(rec.Body.String(), xml.Header) {
+		t.Errorf("XML: no declaration in %q", rec.Body)
+	}
+	var page struct {
+		XMLName xml.Name `xml:"portfolio"`
+		Items   []struct {
+			Symbol string  `xml:"symbol"`
+			Amount float64 `xml:"amount"`
+		} `xml:"items>holding"`
+		Total int `xml:"total"`
+	}
+	if err := xml.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || len(page.Items) != 1 || page.Items[0].Symbol != "BTC" || page.Items[0].Amount != 1.5 {
+		t.Errorf("XML: got %+v", page)
+	}
+
+	for _, accept := range []string{"", "application/json", "*/*", "text/html"} {
+		rec := negotiated(mux, "/portfolio", accept)
+		if ct := rec.Header().Get("Content-Type"); ct != formatJSON {
+			t.Errorf("Accept %q: Content-Type = %q, want JSON", accept, ct)
+		}
+		var page portfolioPage
+		if err := json.NewDecoder(rec.Body).Decode(&page); err != nil || page.Total != 1 || page.Items[0].Symbol != "BTC" {
+			t.Errorf("Accept %q: got %+v, %v", accept, page, err)
+		}
+	}
+}
+
+func TestPortfolioValueAsXML(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{"BTC": 60000})
+	addHoldings(t, store, Portfolio{UserID: 1, Symbol: "BTC", Amount: 0.5}, Portfolio{UserID: 1, Symbol: "NOPE", Amount: 1})
+
+	rec := negotiated(mux, "/portfolio/value", "text/xml")
+	if rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Type") != formatXML || !strings.Contains(rec.Header().Get("Vary"), "Accept") {
+		t.Fatalf("status %d, headers %v", rec.Code, rec.Header())
+	}
+	var got struct {
+		XMLName    xml.Name `xml:"valuation"`
+		TotalValue float64  `xml:"total_value"`
+		Currency   string   `xml:"currency"`
+		Failed     []string `xml:"failed_symbols>symbol"`
+		Quotes     []struct {
+			Symbol string  `xml:"symbol,attr"`
+			Price  float64 `xml:"price"`
+		} `xml:"prices>quote"`
+	}
+	if err := xml.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.TotalValue != 30000 || got.Currency != "USD" {
+		t.Errorf("total %v %s, want 30000 USD", got.TotalValue, got.Currency)
+	}
+	if len(got.Failed) != 1 || got.Failed[0] != "NOPE" {
+		t.Errorf("failed symbols = %v", got.Failed)
+	}
+	if len(got.Quotes) != 1 || got.Quotes[0].Symbol != "BTC" || got.Quotes[0].Price != 60000 {
+		t.Errorf("quotes = %+v", got.Quotes)
+	}
+}
+
+func TestResponseFormatPreference(t *testing.T) {
+	useConfig(t, &config{})
+	for accept, want := range map[string]string{
+		"application/xml;q=0.9, application/json":        formatJSON,
+		"application/json;q=0.5, application/xml":        formatXML,
+		"text/html, text/xml;q=0.8, */*;q=0.1":           formatXML,
+		"application/json, application/xml":              formatJSON,
+		"application/xml;q=oops, application/json;q=0.2": formatJSON,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/portfolio", nil)
+		req.Header.Set("Accept", accept)
+		if got, ok := (&Server{}).responseFormat(httptest.NewRecorder(), req); !ok || got != want {
+			t.Errorf("Accept %q: got %q, want %q", accept, got, want)
+		}
+	}
+}
+
+func TestStrictAcceptRejectsUnsupportedTypes(t *testing.T) {
+	s, mux := newTestServer(t, newSQLStore(t), fakePrices{})
+	s.strictAccept = true
+
+	for _, path := range []string{"/portfolio", "/portfolio/value"} {
+		if rec := negotiated(mux, path, "text/html"); rec.Code != http.StatusNotAcceptable {
+			t.Errorf("%s as text/html: status = %d, want 406", path, rec.Code)
+		}
+		if rec := negotiated(mux, path, "application/xml"); rec.Code != http.StatusOK {
+			t.Errorf("%s as XML: status = %d, want 200", path, rec.Code)
+		}
+		if rec := negotiated(mux, path, ""); rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != formatJSON {
+			t.Errorf("%s without Accept: status = %d, want JSON", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	notificationTitle    = "GoCryptoTracker"
+	desktopNotifyTimeout = 5 * time.Second
+	webhookTimeout       = 10 * time.Second
+	slackAttempts        = 3
+	slackRetryDelay      = 2 * time.Second
+	notifyQueueSize      = 100
+)
+
+// Notifier types selectable in the config
+const (
+	notifierLog     = "log"
+	notifierWebhook = "webhook"
+	notifierDesktop = "desktop"
+	notifierSlack   = "slack"
+	notifierEmail   = "email"
+)
+
+// Notifier delivers an alert message somewhere
+type Notifier interface {
+	Notify(msg string) error
+}
+
+// notifierConfig selects one notifier and its settings
+type notifierConfig struct {
+	Type    string `json:"type"`              // "log", "webhook", "slack", "email" or "desktop"
+	URL     string `json:"url,omitempty"`     // Webhook or Slack incoming-webhook endpoint
+	Channel string `json:"channel,omitempty"` // Slack channel override, e.g. "#alerts"
+	smtpConfig
+}
+
+// notifiers receive every alert; the log unless the config says otherwise
+var notifiers = []Notifier{LogNotifier{}}
+
+// newNotifier builds the notifier described by c
+func newNotifier(c notifierConfig) (Notifier, error) {
+	switch c.Type {
+	case notifierLog:
+		return LogNotifier{}, nil
+	case notifierWebhook:
+		if c.URL == "" {
+			return nil, fmt.Errorf("webhook notifier requires a url")
+		}
+		return &WebhookNotifier{URL: c.URL, Client: &http.Client{Timeout: webhookTimeout}}, nil
+	case notifierSlack:
+		if c.URL == "" {
+			return nil, fmt.Errorf("slack notifier requires a url")
+		}
+		return &SlackNotifier{URL: c.URL, Channel: c.Channel, Client: &http.Client{Timeout: webhookTimeout}}, nil
+	case notifierEmail:
+		return newEmailNotifier(c.smtpConfig)
+	case notifierDesktop:
+		return DesktopNotifier{}, nil
+	}
+	return nil, fmt.Errorf("invalid notifier type %q", c.Type)
+}
+
+// setupNotifiers replaces the default log notifier with those selected in
+// the config. The configs are validated by loadConfig.
+func setupNotifiers(c *config) {
+	if len(c.Notifiers) > 0 {
+		notifiers = nil
+		for _, nc := range c.Notifiers {
+			n, err := newNotifier(nc)
+			if err != nil {
+				continue
+			}
+			notifiers = append(notifiers, n)
+		}
+	}
+	if c.DesktopNotifications {
+		notifiers = append(notifiers, DesktopNotifier{})
+	}
+}
+
+// notifyQueue delivers notifications from a goroutine of its own, so a slow
+// or retrying notifier never holds up the monitor or job raising the alert
+type notifyQueue struct {
+	msgs chan string
+	done chan struct{}
+}
+
+func newNotifyQueue(size int) *notifyQueue {
+	return &notifyQueue{msgs: make(chan string, size), done: make(chan struct{})}
+}
+
+// notifications is the queue notify sends to, delivered by its run
+var notifications = newNotifyQueue(notifyQueueSize)
+
+// run delivers queued notifications until the queue is closed
+func (q *notifyQueue) run() {
+	defer close(q.done)
+	for msg := range q.msgs {
+		deliver(msg)
+	}
+}
+
+// send queues msg without blocking, dropping it if the queue is full
+func (q *notifyQueue) send(msg string) {
+	select {
+	case q.msgs <- msg:
+	default:
+		notificationCounter.inc("queue", "dropped")
+		reportError("notify", "Notification queue full, dropped", "message", msg)
+	}
+}
+
+// close stops the queue once everything already queued is delivered, or
+// when ctx is done. Nothing may be sent after.
+func (q *notifyQueue) close(ctx context.Context) {
+	close(q.msgs)
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		slog.Warn("Undelivered notifications dropped at shutdown", "component", "notify", "count", len(q.msgs))
+	}
+}
+
+// notify queues msg for every notifier
+func notify(msg string) {
+	notifications.send(msg)
+}
+
+// deliver sends msg to every notifier, logging any that fail
+func deliver(msg string) {
+	for _, n := range notifiers {
+		if err := n.Notify(msg); err != nil {
+			notificationCounter.inc(notifierName(n), "failed")
+			reportError("notify", "Error sending notification", "notifier", notifierName(n), "err", err)
+			continue
+		}
+		notificationCounter.inc(notifierName(n), "sent")
+	}
+}
+
+// notifierName labels n in metrics, e.g. "webhook" for *WebhookNotifier
+func notifierName(n Notifier) string {
+	name := fmt.Sprintf("%T", n)
+	name = name[strings.LastIndex(name, ".")+1:]
+	return strings.ToLower(strings.TrimSuffix(name, "Notifier"))
+}
+
+// withoutURL strips the request URL from a client error, since webhook
+// URLs carry their secret in the path and errors end up in the logs and
+// /admin/errors
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s request: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// LogNotifier writes alerts to the log
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(msg string) error {
+	slog.Warn(msg, "component", "notify")
+	return nil
+}
+
+// WebhookNotifier POSTs alerts as {"message": "..."} to URL
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Notify(msg string) error {
+	body, err := json.Marshal(struct {
+		Message string `json:"message"`
+	}{
+		Message: msg,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := n.Client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return withoutURL(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// SlackNotifier posts alerts to a Slack incoming webhook. Failed posts are
+// retried, since Slack answers 429 and 5xx under load.
+type SlackNotifier struct {
+	URL     string
+	Channel string
+	Client  *http.Client
+}
+
+func (n *SlackNotifier) Notify(msg string) error {
+	body, err := json.Marshal(struct {
+		Text    string `json:"text"`
+		Channel string `json:"channel,omitempty"`
+	}{
+		Text:    fmt.Sprintf("*%s*: %s", notificationTitle, msg),
+		Channel: n.Channel,
+	})
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err = n.post(body)
+		if err == nil || attempt == slackAttempts {
+			return err
+		}
+		time.Sleep(slackRetryDelay)
+	}
+}
+
+// post sends one payload, treating any non-200 answer as a failure
+func (n *SlackNotifier) post(body []byte) error {
+	resp, err := n.Client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return withoutURL(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack responded %s", resp.Status)
+	}
+	return nil
+}
+
+// DesktopNotifier shows alerts as native desktop notifications, for when
+// the tracker runs on a workstation. It shells out to notify-send on Linux
+// and osascript on macOS.
+type DesktopNotifier struct{}
+
+func (DesktopNotifier) Notify(msg string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), desktopNotifyTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.CommandContext(ctx, "notify-send", notificationTitle, msg)
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", strconv.Quote(msg), strconv.Quote(notificationTitle))
+		cmd = exec.CommandContext(ctx, "osascript", "-e", script)
+	default:
+		return fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier keeps every message it is sent, after waiting for a
+// value on gate if it has one
+type recordingNotifier struct {
+	gate chan struct{}
+
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (n *recordingNotifier) Notify(msg string) error {
+	if n.gate != nil {
+		<-n.gate
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.msgs = append(n.msgs, msg)
+	return nil
+}
+
+func (n *recordingNotifier) sent() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.msgs...)
+}
+
+// useNotifier sends notifications to n through a fresh queue of size for
+// the test
+func useNotifier(t *testing.T, n Notifier, size int) *notifyQueue {
+	savedNotifiers, savedQueue := notifiers, notifications
+	notifiers = []Notifier{n}
+	notifications = newNotifyQueue(size)
+	go notifications.run()
+	t.Cleanup(func() {
+		notifiers, notifications = savedNotifiers, savedQueue
+	})
+	return notifications
+}
+
+func TestNotifyDoesNotWaitForSlowNotifiers(t *testing.T) {
+	n := &recordingNotifier{gate: make(chan struct{})}
+	q := useNotifier(t, n, 10)
+
+	start := time.Now()
+	notify("one")
+	notify("two")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("notify blocked for %v", elapsed)
+	}
+
+	close(n.gate)
+	q.close(context.Background())
+	if got := n.sent(); len(got) != 2 || got[0] != "one" || got[1] != "two" {
+		t.Errorf("sent %q, want [one two]", got)
+	}
+}
+
+func TestNotifyDropsWhenQueueIsFull(t *testing.T) {
+	n := &recordingNotifier{gate: make(chan struct{})}
+	q := useNotifier(t, n, 1)
+
+	notify("delivering")
+	// Wait for the first message to leave the queue for the notifier
+	for deadline := time.Now().Add(5 * time.Second); len(q.msgs) > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	notify("queued")
+	notify("dropped")
+
+	close(n.gate)
+	q.close(context.Background())
+	if got := n.sent(); len(got) != 2 || got[1] != "queued" {
+		t.Errorf("sent %q, want [delivering queued]", got)
+	}
+}
+
+func TestWebhookNotifierPostsMessage(t *testing.T) {
+	var got struct {
+		Message string `json:"message"`
+	}
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	n, err := newNotifier(notifierConfig{Type: notifierWebhook, URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify("BTC is up"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Message != "BTC is up" || contentType != "application/json" {
+		t.Errorf("posted %+v as %q", got, contentType)
+	}
+}
+
+func TestWebhookNotifierReportsErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	n := &WebhookNotifier{URL: server.URL, Client: server.Client()}
+	if err := n.Notify("BTC is up"); err == nil {
+		t.Error("a 502 response was not reported")
+	}
+}
+
+func TestNewNotifierValidatesConfig(t *testing.T) {
+	for _, c := range []notifierConfig{{Type: notifierWebhook}, {Type: notifierSlack}, {Type: "pager"}} {
+		if _, err := newNotifier(c); err == nil {
+			t.Errorf("%+v: accepted", c)
+		}
+	}
+}
+
+func TestSlackNotifierPostsPayload(t *testing.T) {
+	var got struct {
+		Text    string `json:"text"`
+		Channel string `json:"channel"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	n, err := newNotifier(notifierConfig{Type: notifierSlack, URL: server.URL, Channel: "#alerts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify("Bitcoin (BTC) crossed above 100000.00: now 100250.00"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != "*GoCryptoTracker*: Bitcoin (BTC) crossed above 100000.00: now 100250.00" || got.Channel != "#alerts" {
+		t.Errorf("posted %+v", got)
+	}
+}
+
+func TestSlackNotifierRetries(t *testing.T) {
+	var mu sync.Mutex
+	posts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		posts++
+		if posts == 1 {
+			http.Error(w, "rate_limited", http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	n := &SlackNotifier{URL: server.URL, Client: server.Client()}
+	if err := n.Notify("BTC is up"); err != nil {
+		t.Fatalf("after a 429 then a 200: %v", err)
+	}
+	if posts != 2 {
+		t.Errorf("posted %d times, want 2", posts)
+	}
+}
+
+func TestNotifierErrorsDontLogTheURL(t *testing.T) {
+	// Nothing listens at the secret URL's host, so every post fails
+	server := httptest.NewServer(http.NotFoundHandler())
+	secretURL := server.URL + "/services/T000/B000/secret-token"
+	server.Close()
+
+	logs := captureLogs(t, slog.LevelInfo)
+	client := &http.Client{Timeout: time.Second}
+	saved := notifiers
+	notifiers = []Notifier{&WebhookNotifier{URL: secretURL, Client: client}}
+	t.Cleanup(func() { notifiers = saved })
+	deliver("BTC is up")
+
+	slackErr := (&SlackNotifier{URL: secretURL, Client: client}).post([]byte("{}"))
+	if slackErr == nil || strings.Contains(slackErr.Error(), "secret-token") {
+		t.Errorf("slack post error = %v, want one without the URL", slackErr)
+	}
+	if !strings.Contains(logs.String(), "Error sending notification") {
+		t.Fatalf("failure not logged: %s", logs)
+	}
+	if strings.Contains(logs.String(), "secret-token") {
+		t.Errorf("log leaks the webhook URL: %s", logs)
+	}
+	for _, ev := range recentErrors.list() {
+		if strings.Contains(fmt.Sprint(ev.Message, ev.Fields), "secret-token") {
+			t.Errorf("/admin/errors leaks the webhook URL: %s %v", ev.Message, ev.Fields)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const defaultPerformersCount = 3
+
+// pnlEntry is the unrealized profit or loss of one holding
+type pnlEntry struct {
+	Symbol      string  `json:"symbol"`
+	Amount      float64 `json:"amount"`
+	AverageCost float64 `json:"average_cost"`
+	Price       float64 `json:"price"`
+	CostBasis   float64 `json:"cost_basis"`
+	Value       float64 `json:"value"`
+	Gain        float64 `json:"gain"`
+
+	// GainPercent is null when the holding has no cost, e.g. an airdrop
+	GainPercent *float64 `json:"gain_percent"`
+
+	// gainRatio orders entries, unrounded, with free holdings first
+	gainRatio float64
+}
+
+// roundPercent rounds p half away from zero to the configured number of
+// decimal places. Values too large for that precision are returned as is.
+func roundPercent(p float64) float64 {
+	scale := math.Pow(10, float64(cfg.percentDecimals()))
+	if math.IsInf(p*scale, 0) || math.Abs(p) >= 1e15 {
+		return p
+	}
+	return math.Round(p*scale) / scale
+}
+
+// unrealizedPnL computes the gain of each priced holding with a known cost
+// basis, and returns the symbols that had no cost basis separately
+func unrealizedPnL(amounts map[string]float64, v valuation, costs map[string]float64) ([]pnlEntry, []string) {
+	var entries []pnlEntry
+	var unknown []string
+	for symbol, amount := range amounts {
+		price, priced := v.Prices[symbol]
+		if !priced || amount == 0 {
+			continue
+		}
+		avgCost, ok := costs[symbol]
+		if !ok {
+			unknown = append(unknown, symbol)
+			continue
+		}
+		entry := pnlEntry{
+			Symbol:      symbol,
+			Amount:      amount,
+			AverageCost: avgCost,
+			Price:       price,
+			CostBasis:   avgCost * amount,
+			Value:       price * amount,
+		}
+		entry.Gain = entry.Value - entry.CostBasis
+		if entry.CostBasis > 0 {
+			entry.gainRatio = entry.Gain / entry.CostBasis
+			percent := roundPercent(entry.gainRatio * 100)
+			entry.GainPercent = &percent
+		} else {
+			entry.gainRatio = math.Inf(1)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Strings(unknown)
+	return entries, unknown
+}
+
+// handlePerformers returns the best and worst holdings by unrealized gain percentage
+func (s *Server) handlePerformers(w http.ResponseWriter, r *http.Request) {
+	n := defaultPerformersCount
+	if value := r.URL.Query().Get("n"); value != "" {
+		var err error
+		n, err = strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid n", http.StatusBadRequest)
+			return
+		}
+	}
+	userID, ok := optionalUserID(w, r)
+	if !ok {
+		return
+	}
+	if userID, ok = scopeUserID(w, r, userID); !ok {
+		return
+	}
+
+	h, err := s.store.Holdings(userID)
+	if err != nil {
+		serverError(w, r, "Error fetching portfolio data", err)
+		return
+	}
+	costs, err := s.store.HoldingCosts(userID)
+	if err != nil {
+		serverError(w, r, "Error fetching cost basis", err)
+		return
+	}
+	v := s.prices.Value(r.Context(), h.bySymbol)
+	entries, unknown := unrealizedPnL(h.bySymbol, v, costs)
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].gainRatio > entries[j].gainRatio
+	})
+	best := entries[:min(n, len(entries))]
+	worst := make([]pnlEntry, 0, min(n, len(entries)))
+	for i := len(entries) - 1; i >= 0 && len(worst) < n; i-- {
+		worst = append(worst, entries[i])
+	}
+
+	response := struct {
+		Best          []pnlEntry `json:"best"`
+		Worst         []pnlEntry `json:"worst"`
+		NoCostBasis   []string   `json:"no_cost_basis,omitempty"`
+		FailedSymbols []string   `json:"failed_symbols,omitempty"`
+	}{
+		Best:          best,
+		Worst:         worst,
+		NoCostBasis:   unknown,
+		FailedSymbols: v.FailedSymbols,
+	}
+	if response.Best == nil {
+		response.Best = []pnlEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// HoldingCosts leaves out symbols with any row added before cost basis was
+// recorded, since their cost is only partly known
+func (s *SQLStore) HoldingCosts(userID int) (map[string]float64, error) {
+	query := "SELECT symbol, SUM(amount * cost_basis), SUM(amount), COUNT(*) - COUNT(cost_basis) FROM portfolio"
+	var args []any
+	if userID != 0 {
+		query += " WHERE user_id = ?"
+		args = append(args, userID)
+	}
+	rows, err := s.db.Query(query+" GROUP BY symbol", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	costs := make(map[string]float64)
+	for rows.Next() {
+		var symbol string
+		var cost, amount sql.NullFloat64
+		var unknown int
+		if err := rows.Scan(&symbol, &cost, &amount, &unknown); err != nil {
+			return nil, err
+		}
+		if unknown == 0 && amount.Float64 > 0 {
+			costs[symbol] = cost.Float64 / amount.Float64
+		}
+	}
+	return costs, rows.Err()
+}
+
+// handlePortfolioPnL returns the unrealized gain of each holding against
+// the cost basis recorded when it was added, and the total. Holdings with
+// an unknown cost basis are listed separately and left out of the totals.
+func (s *Server) handlePortfolioPnL(w http.ResponseWriter, r *http.Request) {
+	userID, ok := optionalUserID(w, r)
+	if !ok {
+		return
+	}
+	if userID, ok = scopeUserID(w, r, userID); !ok {
+		return
+	}
+
+	h, err := s.store.Holdings(userID)
+	if err != nil {
+		serverError(w, r, "Error fetching portfolio data", err)
+		return
+	}
+	costs, err := s.store.HoldingCosts(userID)
+	if err != nil {
+		serverError(w, r, "Error fetching cost basis", err)
+		return
+	}
+	v := s.prices.Value(r.Context(), h.bySymbol)
+	entries, unknown := unrealizedPnL(h.bySymbol, v, costs)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Symbol < entries[j].Symbol
+	})
+
+	response := struct {
+		Holdings       []pnlEntry `json:"holdings"`
+		TotalCostBasis float64    `json:"total_cost_basis"`
+		TotalValue     float64    `json:"total_value"`
+		TotalGain      float64    `json:"total_gain"`
+		GainPercent    *float64   `json:"gain_percent"` // Null when the total cost is zero
+		NoCostBasis    []string   `json:"no_cost_basis,omitempty"`
+		FailedSymbols  []string   `json:"failed_symbols,omitempty"`
+	}{
+		Holdings:      []pnlEntry{},
+		NoCostBasis:   unknown,
+		FailedSymbols: v.FailedSymbols,
+	}
+	for _, e := range entries {
+		response.Holdings = append(response.Holdings, e)
+		response.TotalCostBasis += e.CostBasis
+		response.TotalValue += e.Value
+	}
+	response.TotalGain = response.TotalValue - response.TotalCostBasis
+	if response.TotalCostBasis > 0 {
+		percent := roundPercent(response.TotalGain / response.TotalCostBasis * 100)
+		response.GainPercent = &percent
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
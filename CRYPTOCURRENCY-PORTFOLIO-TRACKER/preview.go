@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// handlePreviewAdd shows how the portfolio would look after buying amount
+// of symbol, without persisting anything
+func (s *Server) handlePreviewAdd(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	symbol := normalizeSymbol(query.Get("symbol"))
+	if symbol == "" {
+		http.Error(w, "Missing symbol", http.StatusBadRequest)
+		return
+	}
+	amount, err := strconv.ParseFloat(query.Get("amount"), 64)
+	if err != nil || amount <= 0 {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := optionalUserID(w, r)
+	if !ok {
+		return
+	}
+	if userID, ok = scopeUserID(w, r, userID); !ok {
+		return
+	}
+	currency, rate, ok := s.requestRate(w, r, userID)
+	if !ok {
+		return
+	}
+	h, err := s.store.Holdings(userID)
+	if err != nil {
+		serverError(w, r, "Error fetching portfolio data", err)
+		return
+	}
+
+	previewAmounts := make(map[string]float64, len(h.bySymbol)+1)
+	for s, a := range h.bySymbol {
+		previewAmounts[s] = a
+	}
+	previewAmounts[symbol] += amount
+	preview := s.prices.Value(r.Context(), previewAmounts)
+	if _, priced := preview.Prices[symbol]; !priced {
+		writePriceError(w, preview, []string{symbol})
+		return
+	}
+
+	// Value the current holdings with the same prices rather than fetching again
+	current := valuation{Prices: preview.Prices, Values: make(map[string]float64)}
+	for s, a := range h.bySymbol {
+		if price, ok := preview.Prices[s]; ok {
+			current.Values[s] = price * a
+			current.TotalValue += price * a
+		}
+	}
+	minValue := dustThreshold(r)
+	current.excludeDust(minValue)
+	preview.excludeDust(minValue)
+
+	response := struct {
+		Symbol            string            `json:"symbol"`
+		Amount            float64           `json:"amount"`
+		Currency          string            `json:"currency"`
+		CurrentTotalValue float64           `json:"current_total_value"`
+		PreviewTotalValue float64           `json:"preview_total_value"`
+		CurrentAllocation []allocationEntry `json:"current_allocation"`
+		PreviewAllocation []allocationEntry `json:"preview_allocation"`
+		FailedSymbols     []string          `json:"failed_symbols,omitempty"`
+	}{
+		Symbol:            symbol,
+		Amount:            amount,
+		Currency:          currency,
+		CurrentTotalValue: current.TotalValue / rate,
+		PreviewTotalValue: preview.TotalValue / rate,
+		CurrentAllocation: current.allocation(h.bySymbol, rate),
+		PreviewAllocation: preview.allocation(previewAmounts, rate),
+		FailedSymbols:     preview.FailedSymbols,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
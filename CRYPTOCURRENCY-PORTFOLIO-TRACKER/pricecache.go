@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultPriceCacheSeconds = 30
+
+// priceCache holds the prices from the last CoinCap asset list. CoinCap
+// only serves the whole list, so one fetch fills in every symbol and
+// pricing several holdings within the ttl costs a single request.
+type priceCache struct {
+	mu         sync.RWMutex
+	prices     map[string]float64 // Symbols listed with a price
+	invalid    map[string]error   // Symbols listed without a usable one
+	observedAt time.Time
+	fetchedAt  time.Time
+
+	// refreshMu lets only one caller refetch a stale list at a time
+	refreshMu sync.Mutex
+}
+
+var coinCapPrices = newPriceCache()
+
+func newPriceCache() *priceCache {
+	c := &priceCache{}
+	registerCache(c)
+	return c
+}
+
+// priceCacheTTL is how long a fetched asset list is reused, 0 if caching
+// is disabled
+func priceCacheTTL() time.Duration {
+	switch {
+	case cfg == nil || cfg.PriceCacheSeconds == 0:
+		return defaultPriceCacheSeconds * time.Second
+	case cfg.PriceCacheSeconds < 0:
+		return 0
+	}
+	return time.Duration(cfg.PriceCacheSeconds) * time.Second
+}
+
+// quote returns the price of symbol, fetching the asset list if the cached
+// one is missing or older than the ttl
+func (c *priceCache) quote(ctx context.Context, symbol string) (priceQuote, error) {
+	if q, hit, err := c.lookup(symbol); hit {
+		return q, err
+	}
+
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	// Another caller may have refreshed the list while this one waited
+	if q, hit, err := c.lookup(symbol); hit {
+		return q, err
+	}
+	assets, err := getCoinCapAssets(ctx)
+	if err != nil {
+		return priceQuote{}, err
+	}
+	if priceCacheTTL() <= 0 {
+		return assets.quote(symbol)
+	}
+	// getCoinCapAssets stored the list, so a fresh lookup now succeeds
+	if q, hit, err := c.lookup(symbol); hit {
+		return q, err
+	}
+	return assets.quote(symbol)
+}
+
+// snapshot returns a quote function answering every symbol from a single
+// asset list: the cached one while it is fresh, otherwise one new fetch.
+// Pricing several symbols through it costs at most one request.
+func (c *priceCache) snapshot(ctx context.Context) (func(string) (priceQuote, error), error) {
+	if quote, ok := c.fresh(); ok {
+		return quote, nil
+	}
+
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	if quote, ok := c.fresh(); ok {
+		return quote, nil
+	}
+	assets, err := getCoinCapAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return assets.quote, nil
+}
+
+// lookup answers from the cached list, returning false if it is stale
+func (c *priceCache) lookup(symbol string) (priceQuote, bool, error) {
+	quote, ok := c.fresh()
+	if !ok {
+		return priceQuote{}, false, nil
+	}
+	q, err := quote(symbol)
+	return q, true, err
+}
+
+// fresh returns a quote function over the cached list, or false if the
+// list is stale. store replaces the maps rather than changing them, so the
+// function keeps answering from the list as it was.
+func (c *priceCache) fresh() (func(string) (priceQuote, error), bool) {
+	ttl := priceCacheTTL()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.prices == nil || ttl <= 0 || time.Since(c.fetchedAt) > ttl {
+		return nil, false
+	}
+	prices, invalid, observedAt := c.prices, c.invalid, c.observedAt
+	return func(symbol string) (priceQuote, error) {
+		symbol = normalizeSymbol(symbol)
+		if price, ok := prices[symbol]; ok {
+			return priceQuote{Provider: "coincap", Price: price, ObservedAt: observedAt}, nil
+		}
+		if err, ok := invalid[symbol]; ok {
+			return priceQuote{}, err
+		}
+		return priceQuote{}, fmt.Errorf("%w %s", ErrSymbolNotFound, symbol)
+	}, true
+}
+
+// store replaces the cached prices with those of a freshly fetched list
+func (c *priceCache) store(assets coinCapAsset) {
+	fetched := make(map[string]float64, len(assets.Data))
+	invalid := make(map[string]error)
+	for _, asset := range assets.Data {
+		_, priced := fetched[asset.Symbol]
+		if _, failed := invalid[asset.Symbol]; priced || failed {
+			// Keep the first, highest ranked, asset with a ticker as assets.quote does
+			continue
+		}
+		price, err := parseAssetPrice(asset.Symbol, asset.PriceUsd)
+		if err != nil {
+			invalid[asset.Symbol] = err
+			continue
+		}
+		fetched[asset.Symbol] = price
+	}
+
+	now := time.Now().UTC()
+	observedAt := now
+	if assets.Timestamp > 0 {
+		observedAt = time.UnixMilli(assets.Timestamp).UTC()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prices = fetched
+	c.invalid = invalid
+	c.observedAt = observedAt
+	c.fetchedAt = now
+}
+
+// clear drops the cached list and returns the number of symbols it held
+func (c *priceCache) clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.prices) + len(c.invalid)
+	c.prices = nil
+	c.invalid = nil
+	return n
+}
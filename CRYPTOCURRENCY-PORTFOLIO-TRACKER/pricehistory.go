@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	defaultHistoryWindow = 24 * time.Hour // Range /history returns when from is omitted
+	historyPruneInterval = 24 * time.Hour
+)
+
+func (s *SQLStore) RecordPrice(symbol string, price float64, at time.Time) error {
+	_, err := execWriteOn(s.db, "INSERT INTO price_history (symbol, price, recorded_at) VALUES (?, ?, ?)", symbol, price, at.UTC())
+	return err
+}
+
+func (s *SQLStore) PriceHistory(symbol string, from, to time.Time) ([]pricePoint, error) {
+	rows, err := s.db.Query(
+		"SELECT price, recorded_at FROM price_history WHERE symbol = ? AND recorded_at >= ? AND recorded_at <= ? ORDER BY recorded_at",
+		symbol, from.UTC(), to.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []pricePoint
+	for rows.Next() {
+		var p pricePoint
+		if err := rows.Scan(&p.Price, &p.Time); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// prunePriceHistory deletes prices recorded more than
+// price_history_retention_days ago. With no retention set history is kept
+// forever.
+func (s *Server) prunePriceHistory(ctx context.Context) error {
+	if cfg.PriceHistoryRetentionDays <= 0 {
+		return nil
+	}
+	cutoff := time.Now().UTC().AddDate(0, 0, -cfg.PriceHistoryRetentionDays)
+	n, err := s.store.PrunePriceHistory(cutoff)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		slog.Info("Pruned price history", "component", "db", "rows", n, "before", cutoff)
+	}
+	return nil
+}
+
+func (s *SQLStore) PrunePriceHistory(cutoff time.Time) (int64, error) {
+	result, err := execWriteOn(s.db, "DELETE FROM price_history WHERE recorded_at < ?", cutoff.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// handlePriceHistory returns the prices the monitors recorded for symbol
+// between from and to, oldest first. to defaults to now and from to a day
+// before it.
+func (s *Server) handlePriceHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	symbol := normalizeSymbol(query.Get("symbol"))
+	if symbol == "" {
+		http.Error(w, "Missing symbol", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	var err error
+	if query.Get("to") != "" {
+		if to, err = parseDateParam(query.Get("to")); err != nil {
+			http.Error(w, "Invalid to date", http.StatusBadRequest)
+			return
+		}
+	}
+	from := to.Add(-defaultHistoryWindow)
+	if query.Get("from") != "" {
+		if from, err = parseDateParam(query.Get("from")); err != nil {
+			http.Error(w, "Invalid from date", http.StatusBadRequest)
+			return
+		}
+	}
+	if !to.After(from) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	points, err := s.store.PriceHistory(symbol, from, to)
+	if err != nil {
+		serverError(w, r, "Error fetching price history", err)
+		return
+	}
+	if points == nil {
+		points = []pricePoint{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(points)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PriceAt returns the last price of symbol recorded at or before at, or
+// sql.ErrNoRows if there is none
+func (s *SQLStore) PriceAt(symbol string, at time.Time) (pricePoint, error) {
+	var p pricePoint
+	err := s.db.QueryRow(
+		"SELECT price, recorded_at FROM price_history WHERE symbol = ? AND recorded_at <= ? ORDER BY recorded_at DESC LIMIT 1",
+		symbol, at.UTC(),
+	).Scan(&p.Price, &p.Time)
+	return p, err
+}
+
+// valueAt values amounts at the prices recorded at or before at. Symbols
+// with no price recorded by then are returned as missing, and the amounts
+// are valued only if there are none.
+func (s *Server) valueAt(amounts map[string]float64, at time.Time) (valuation, []string, error) {
+	quotes := make(map[string]priceQuote, len(amounts))
+	var missing []string
+	for symbol, amount := range amounts {
+		if amount == 0 {
+			continue
+		}
+		p, err := s.store.PriceAt(symbol, at)
+		if errors.Is(err, sql.ErrNoRows) {
+			missing = append(missing, symbol)
+			continue
+		}
+		if err != nil {
+			return valuation{}, nil, err
+		}
+		quotes[symbol] = priceQuote{Provider: "history", Price: p.Price, ObservedAt: p.Time}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return valuation{}, missing, nil
+	}
+	v := valueHoldingsWith(amounts, func(symbol string) (priceQuote, error) { return quotes[symbol], nil })
+	v.AsOf = at
+	return v, nil, nil
+}
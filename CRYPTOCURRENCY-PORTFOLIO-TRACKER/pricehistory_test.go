@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordPrices stores each of prices for symbol an hour apart from start
+func recordPrices(t *testing.T, store *SQLStore, symbol string, start time.Time, prices ...float64) {
+	t.Helper()
+	for i, price := range prices {
+		if err := store.RecordPrice(symbol, price, start.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPriceHistoryRange(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{})
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	recordPrices(t, store, "BTC", start, 100, 101, 102, 103, 104)
+	recordPrices(t, store, "ETH", start, 10, 11)
+
+	for _, c := range []struct {
+		query string
+		want  []float64
+	}{
+		{"symbol=btc&from=2026-03-01T01:00:00Z&to=2026-03-01T03:00:00Z", []float64{101, 102, 103}},
+		{"symbol=BTC&from=2026-03-01&to=2026-03-02", []float64{100, 101, 102, 103, 104}},
+		{"symbol=ETH&from=2026-03-01T00:30:00Z&to=2026-03-02", []float64{11}},
+		{"symbol=SOL&from=2026-03-01&to=2026-03-02", nil},
+	} {
+		rec := serve(mux, httptest.NewRequest(http.MethodGet, "/history?"+c.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body %s", c.query, rec.Code, rec.Body)
+		}
+		var points []pricePoint
+		if err := json.NewDecoder(rec.Body).Decode(&points); err != nil {
+			t.Fatal(err)
+		}
+		var got []float64
+		for i, p := range points {
+			if i > 0 && p.Time.Before(points[i-1].Time) {
+				t.Errorf("%s: points out of order", c.query)
+			}
+			got = append(got, p.Price)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%s: got %v, want %v", c.query, got, c.want)
+		}
+	}
+}
+
+func TestPriceHistoryRejectsBadQueries(t *testing.T) {
+	_, mux := newTestServer(t, newSQLStore(t), fakePrices{})
+	for _, query := range []string{"", "symbol=BTC&from=yesterday", "symbol=BTC&from=2026-03-02&to=2026-03-01"} {
+		if rec := serve(mux, httptest.NewRequest(http.MethodGet, "/history?"+query, nil)); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestPrunePriceHistory(t *testing.T) {
+	store := newSQLStore(t)
+	s, _ := newTestServer(t, store, fakePrices{})
+	now := time.Now().UTC()
+	recordPrices(t, store, "BTC", now.AddDate(0, 0, -10), 1)
+	recordPrices(t, store, "BTC", now.AddDate(0, 0, -2), 2)
+
+	// Without a retention everything is kept
+	if err := s.prunePriceHistory(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := columnValues(t, store.db, "SELECT price FROM price_history ORDER BY recorded_at"); got != "[1 2]" {
+		t.Errorf("without retention: prices = %s, want [1 2]", got)
+	}
+
+	cfg.PriceHistoryRetentionDays = 7
+	if err := s.prunePriceHistory(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := columnValues(t, store.db, "SELECT price FROM price_history"); got != "[2]" {
+		t.Errorf("with 7 days retention: prices = %s, want [2]", got)
+	}
+}
+
+func TestMonitorRecordsPolledPrices(t *testing.T) {
+	store := newSQLStore(t)
+	s, _ := newTestServer(t, store, fakePrices{})
+	cfg.StartupJitterSeconds = -1
+	useProvider(t, staticProvider{"BTC": 65000})
+
+	startMonitor(t, s, tokenConfig{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(100000)})
+	waitFor(t, "a recorded price", func() bool {
+		points, err := store.PriceHistory("BTC", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+		return err == nil && len(points) == 1 && points[0].Price == 65000
+	})
+}
+
+func TestPortfolioValueAt(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{}) // No live prices
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	recordPrices(t, store, "BTC", start, 100, 110, 120)
+	recordPrices(t, store, "ETH", start.Add(2*time.Hour), 10)
+	addHoldings(t, store, Portfolio{UserID: 1, Symbol: "BTC", Amount: 2}, Portfolio{UserID: 2, Symbol: "ETH", Amount: 3})
+
+	// Between the second and third points the second is used
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/value?user_id=1&at=2026-03-01T01:30:00Z", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		TotalValue float64   `json:"total_value"`
+		AsOf       time.Time `json:"as_of"`
+		Prices     map[string]struct {
+			Price    float64 `json:"price"`
+			Provider string  `json:"provider"`
+		} `json:"prices"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.TotalValue != 220 || got.Prices["BTC"].Price != 110 {
+		t.Errorf("total %v at BTC %v, want 220 at 110", got.TotalValue, got.Prices["BTC"].Price)
+	}
+	if !got.AsOf.Equal(start.Add(90 * time.Minute)) {
+		t.Errorf("as_of = %v", got.AsOf)
+	}
+
+	// ETH has no price recorded that early
+	rec = serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/value?at=2026-03-01T01:30:00Z", nil))
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "for ETH") {
+		t.Errorf("without ETH history: status = %d, body %s; want 422 naming ETH", rec.Code, rec.Body)
+	}
+	rec = serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/value?at=2026-03-01T02:00:00Z", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("once ETH is recorded: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	for _, at := range []string{"last-tuesday", time.Now().UTC().Add(time.Hour).Format(time.RFC3339)} {
+		if rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/value?at="+at, nil)); rec.Code != http.StatusBadRequest {
+			t.Errorf("at=%s: status = %d, want 400", at, rec.Code)
+		}
+	}
+}
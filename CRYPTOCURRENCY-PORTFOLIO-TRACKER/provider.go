@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PriceProvider is a source of current USD prices
+type PriceProvider interface {
+	// Price returns the price of symbol
+	Price(ctx context.Context, symbol string) (float64, error)
+	// Prices returns the prices of symbols from as few requests as the
+	// source allows. If only some can be priced the others are reported in
+	// a PriceErrors; any other error means none could be.
+	Prices(ctx context.Context, symbols []string) (map[string]float64, error)
+}
+
+// quoteProvider is implemented by providers that know when and by whom
+// each price was observed
+type quoteProvider interface {
+	Quotes(ctx context.Context, symbols []string) (map[string]priceQuote, error)
+}
+
+// snapshotProvider is implemented by providers that can price every symbol
+// from a single observation
+type snapshotProvider interface {
+	// Snapshot returns the quotes of symbols and the moment they were all
+	// observed. Symbols it couldn't price are reported in a PriceErrors.
+	Snapshot(ctx context.Context, symbols []string) (map[string]priceQuote, time.Time, error)
+}
+
+// errNoSnapshot means no provider could price the symbols from a single
+// observation
+var errNoSnapshot = errors.New("no price snapshot available")
+
+// priceProvider is where monitors and valuations get prices
+var priceProvider PriceProvider = CoinCapProvider{}
+
+// PriceErrors holds why each symbol a provider couldn't price failed. The
+// errors wrap ErrPriceUnavailable or ErrSymbolNotFound where those apply.
+type PriceErrors map[string]error
+
+func (e PriceErrors) Error() string {
+	symbols := make([]string, 0, len(e))
+	for symbol := range e {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	messages := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		messages[i] = e[symbol].Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// providerQuotes prices symbols through p, with the provenance of each
+// price when p records it
+func providerQuotes(ctx context.Context, p PriceProvider, symbols []string) (map[string]priceQuote, error) {
+	if q, ok := p.(quoteProvider); ok {
+		return q.Quotes(ctx, symbols)
+	}
+	prices, err := p.Prices(ctx, symbols)
+	observedAt := time.Now().UTC()
+	quotes := make(map[string]priceQuote, len(prices))
+	for symbol, price := range prices {
+		quotes[symbol] = priceQuote{Price: price, ObservedAt: observedAt}
+	}
+	return quotes, err
+}
+
+// CoinCapProvider prices symbols from CoinCap's asset list, through the
+// price cache
+type CoinCapProvider struct{}
+
+func (CoinCapProvider) Price(ctx context.Context, symbol string) (float64, error) {
+	return getCoinCapPrice(ctx, symbol)
+}
+
+func (p CoinCapProvider) Prices(ctx context.Context, symbols []string) (map[string]float64, error) {
+	quotes, err := p.Quotes(ctx, symbols)
+	prices := make(map[string]float64, len(quotes))
+	for symbol, q := range quotes {
+		prices[symbol] = q.Price
+	}
+	return prices, err
+}
+
+// Quotes answers every symbol from a single asset list
+func (CoinCapProvider) Quotes(ctx context.Context, symbols []string) (map[string]priceQuote, error) {
+	quote, err := coinCapPrices.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	quotes := make(map[string]priceQuote, len(symbols))
+	failures := PriceErrors{}
+	for _, symbol := range symbols {
+		q, err := quote(symbol)
+		if err != nil {
+			failures[symbol] = err
+			continue
+		}
+		quotes[symbol] = q
+	}
+	if len(failures) > 0 {
+		return quotes, failures
+	}
+	return quotes, nil
+}
+
+// Snapshot answers every symbol from one freshly fetched asset list
+func (CoinCapProvider) Snapshot(ctx context.Context, symbols []string) (map[string]priceQuote, time.Time, error) {
+	assets, err := getCoinCapAssets(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	asOf := time.Now().UTC()
+	if assets.Timestamp > 0 {
+		asOf = time.UnixMilli(assets.Timestamp).UTC()
+	}
+	quotes := make(map[string]priceQuote, len(symbols))
+	failures := PriceErrors{}
+	for _, symbol := range symbols {
+		q, err := assets.quote(symbol)
+		if err != nil {
+			failures[symbol] = err
+			continue
+		}
+		quotes[symbol] = q
+	}
+	if len(failures) > 0 {
+		return quotes, asOf, failures
+	}
+	return quotes, asOf, nil
+}
+
+// errAllProvidersFailed means no provider could give a price, for reasons
+// other than not listing the symbol
+var errAllProvidersFailed = errors.New("all price providers failed")
+
+// FallbackProvider asks each of its providers in order until one succeeds,
+// so a primary that is down or rate limited doesn't stop pricing
+type FallbackProvider struct {
+	Providers []PriceProvider
+}
+
+func (f FallbackProvider) Price(ctx context.Context, symbol string) (float64, error) {
+	var errs []error
+	for _, p := range f.Providers {
+		price, err := p.Price(ctx, symbol)
+		if err == nil {
+			slog.Debug("Price served", "component", "provider", "provider", providerName(p), "symbol", symbol)
+			return price, nil
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", providerName(p), err))
+	}
+	return 0, fallbackError(symbol, errs)
+}
+
+func (f FallbackProvider) Prices(ctx context.Context, symbols []string) (map[string]float64, error) {
+	quotes, err := f.Quotes(ctx, symbols)
+	prices := make(map[string]float64, len(quotes))
+	for symbol, q := range quotes {
+		prices[symbol] = q.Price
+	}
+	return prices, err
+}
+
+// Quotes asks each provider in turn for the symbols the ones before it
+// couldn't price
+func (f FallbackProvider) Quotes(ctx context.Context, symbols []string) (map[string]priceQuote, error) {
+	quotes := make(map[string]priceQuote, len(symbols))
+	errs := make(map[string][]error)
+	var outages providerErrors // Providers that failed outright
+	remaining := symbols
+	for _, p := range f.Providers {
+		if len(remaining) == 0 {
+			break
+		}
+		name := providerName(p)
+		got, err := providerQuotes(ctx, p, remaining)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var failures PriceErrors
+		if err != nil && !errors.As(err, &failures) {
+			// Nothing priced; every remaining symbol failed the same way
+			outages = append(outages, fmt.Errorf("%s: %w", name, err))
+			failures = PriceErrors{}
+			for _, symbol := range remaining {
+				failures[symbol] = err
+			}
+		}
+
+		var next []string
+		for _, symbol := range remaining {
+			if q, ok := got[symbol]; ok && failures[symbol] == nil {
+				quotes[symbol] = q
+				continue
+			}
+			err := failures[symbol]
+			if err == nil {
+				err = errors.New("no price returned")
+			}
+			errs[symbol] = append(errs[symbol], fmt.Errorf("%s: %w", name, err))
+			next = append(next, symbol)
+		}
+		if served := len(remaining) - len(next); served > 0 {
+			slog.Debug("Prices served", "component", "provider", "provider", name, "symbols", served)
+		}
+		remaining = next
+	}
+
+	if len(remaining) == 0 {
+		return quotes, nil
+	}
+	if len(outages) == len(f.Providers) {
+		// Unlike missing symbols, outages are worth retrying
+		return nil, fmt.Errorf("%w: %w", errAllProvidersFailed, outages)
+	}
+	failures := PriceErrors{}
+	for _, symbol := range remaining {
+		failures[symbol] = fallbackError(symbol, errs[symbol])
+	}
+	return quotes, failures
+}
+
+// Snapshot takes the snapshot from the first provider able to, in order.
+// Prices from different providers are never mixed, so symbols the one that
+// answers can't price stay unpriced.
+func (f FallbackProvider) Snapshot(ctx context.Context, symbols []string) (map[string]priceQuote, time.Time, error) {
+	var outages providerErrors
+	for _, p := range f.Providers {
+		sp, ok := p.(snapshotProvider)
+		if !ok {
+			continue
+		}
+		quotes, asOf, err := sp.Snapshot(ctx, symbols)
+		if ctx.Err() != nil {
+			return nil, time.Time{}, ctx.Err()
+		}
+		var failures PriceErrors
+		if err == nil || errors.As(err, &failures) {
+			return quotes, asOf, err
+		}
+		outages = append(outages, fmt.Errorf("%s: %w", providerName(p), err))
+	}
+	if len(outages) == 0 {
+		return nil, time.Time{}, errNoSnapshot
+	}
+	return nil, time.Time{}, fmt.Errorf("%w: %w", errNoSnapshot, outages)
+}
+
+// fallbackError combines every provider's error for symbol. If they all
+// agree the symbol isn't listed the result wraps ErrSymbolNotFound;
+// otherwise it wraps errAllProvidersFailed.
+func fallbackError(symbol string, errs []error) error {
+	notFound := len(errs) > 0
+	for _, err := range errs {
+		if !errors.Is(err, ErrSymbolNotFound) {
+			notFound = false
+		}
+	}
+	if notFound {
+		return fmt.Errorf("%w %s by any provider", ErrSymbolNotFound, symbol)
+	}
+	return fmt.Errorf("%w for %s: %w", errAllProvidersFailed, symbol, providerErrors(errs))
+}
+
+// providerErrors are the errors of several providers, one after another
+type providerErrors []error
+
+func (e providerErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e providerErrors) Unwrap() []error { return e }
+
+// providerName labels p in logs, e.g. "coincap" for CoinCapProvider
+func providerName(p PriceProvider) string {
+	name := fmt.Sprintf("%T", p)
+	name = name[strings.LastIndex(name, ".")+1:]
+	return strings.ToLower(strings.TrimSuffix(name, "Provider"))
+}
+
+// newPriceProvider returns the provider called name in the config
+func newPriceProvider(c *config, name string) (PriceProvider, error) {
+	switch name {
+	case "coincap":
+		return CoinCapProvider{}, nil
+	case "coingecko":
+		return newCoinGeckoProvider(c.CoinGecko), nil
+	}
+	return nil, fmt.Errorf("invalid price provider %q", name)
+}
+
+// setupPriceProvider selects the providers listed in price_providers, in
+// order of preference, falling back from each to the next. The config is
+// validated by loadConfig.
+func setupPriceProvider(c *config) {
+	if len(c.PriceProviders) == 0 {
+		return
+	}
+	var providers []PriceProvider
+	for _, name := range c.PriceProviders {
+		p, err := newPriceProvider(c, name)
+		if err != nil {
+			continue
+		}
+		providers = append(providers, p)
+	}
+	if len(providers) == 1 {
+		priceProvider = providers[0]
+		return
+	}
+	priceProvider = FallbackProvider{Providers: providers}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// staticProvider prices symbols from a fixed map, one at a time
+type staticProvider map[string]float64
+
+func (p staticProvider) Price(ctx context.Context, symbol string) (float64, error) {
+	price, ok := p[symbol]
+	if !ok {
+		return 0, fmt.Errorf("%w %s", ErrSymbolNotFound, symbol)
+	}
+	return price, nil
+}
+
+func (p staticProvider) Prices(ctx context.Context, symbols []string) (map[string]float64, error) {
+	prices := make(map[string]float64, len(symbols))
+	failures := PriceErrors{}
+	for _, symbol := range symbols {
+		price, err := p.Price(ctx, symbol)
+		if err != nil {
+			failures[symbol] = err
+			continue
+		}
+		prices[symbol] = price
+	}
+	if len(failures) > 0 {
+		return prices, failures
+	}
+	return prices, nil
+}
+
+// snapshotStub is a staticProvider that can also take snapshots, observed
+// at asOf, or fails every request with err
+type snapshotStub struct {
+	staticProvider
+	asOf time.Time
+	err  error
+}
+
+func (p snapshotStub) Snapshot(ctx context.Context, symbols []string) (map[string]priceQuote, time.Time, error) {
+	if p.err != nil {
+		return nil, time.Time{}, p.err
+	}
+	prices, err := p.Prices(ctx, symbols)
+	quotes := make(map[string]priceQuote, len(prices))
+	for symbol, price := range prices {
+		quotes[symbol] = priceQuote{Price: price, ObservedAt: p.asOf}
+	}
+	return quotes, p.asOf, err
+}
+
+// useProvider sets priceProvider for the test
+func useProvider(t testing.TB, p PriceProvider) {
+	saved := priceProvider
+	priceProvider = p
+	t.Cleanup(func() { priceProvider = saved })
+}
+
+func TestValueHoldingsSnapshotFallsBackAlongTheChain(t *testing.T) {
+	useConfig(t, &config{})
+	asOf := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	useProvider(t, FallbackProvider{Providers: []PriceProvider{
+		snapshotStub{err: errors.New("down")},
+		staticProvider{"BTC": 1},
+		snapshotStub{staticProvider: staticProvider{"BTC": 100}, asOf: asOf},
+	}})
+
+	v := valueHoldingsSnapshot(context.Background(), map[string]float64{"BTC": 2, "NOPE": 1})
+	if v.TotalValue != 200 || !v.AsOf.Equal(asOf) {
+		t.Errorf("got total %v as of %v, want 200 as of %v", v.TotalValue, v.AsOf, asOf)
+	}
+	if len(v.FailedSymbols) != 1 || v.FailedSymbols[0] != "NOPE" {
+		t.Errorf("failed symbols = %v, want [NOPE]", v.FailedSymbols)
+	}
+}
+
+func TestValueHoldingsSnapshotWithoutSnapshotProvider(t *testing.T) {
+	useConfig(t, &config{})
+	useProvider(t, FallbackProvider{Providers: []PriceProvider{staticProvider{"BTC": 100}}})
+
+	v := valueHoldingsSnapshot(context.Background(), map[string]float64{"BTC": 2})
+	if v.TotalValue != 200 {
+		t.Errorf("total = %v, want 200", v.TotalValue)
+	}
+	if !v.AsOf.IsZero() {
+		t.Errorf("AsOf = %v, want zero as the prices aren't from one snapshot", v.AsOf)
+	}
+}
+
+// unsnapshotPrices is a fakePrices whose providers can't take a snapshot
+type unsnapshotPrices struct {
+	fakePrices
+}
+
+func (p unsnapshotPrices) ValueSnapshot(ctx context.Context, amounts map[string]float64) valuation {
+	return p.Value(ctx, amounts)
+}
+
+func TestConsistentValueReportsWhetherPricesAreFromOneSnapshot(t *testing.T) {
+	store := &fakeStore{rows: []Portfolio{{ID: 1, UserID: 1, Symbol: "BTC", Amount: 2}}}
+	for _, c := range []struct {
+		prices PriceClient
+		want   bool
+	}{
+		{fakePrices{"BTC": 100}, true},
+		{unsnapshotPrices{fakePrices{"BTC": 100}}, false},
+	} {
+		_, mux := newTestServer(t, store, c.prices)
+		rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/value?user_id=1&consistent=true", nil))
+		var got struct {
+			AsOf             *time.Time `json:"as_of"`
+			ConsistentPrices *bool      `json:"consistent_prices"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ConsistentPrices == nil || *got.ConsistentPrices != c.want || (got.AsOf != nil) != c.want {
+			t.Errorf("%T: consistent_prices %v, as_of %v; want %v", c.prices, got.ConsistentPrices, got.AsOf, c.want)
+		}
+	}
+}
+
+func TestValueHoldingsWithFakeProvider(t *testing.T) {
+	useConfig(t, &config{})
+	useProvider(t, staticProvider{"BTC": 60000, "ETH": 3000})
+
+	v := valueHoldings(context.Background(), map[string]float64{"BTC": 0.5, "ETH": 2, "NOPE": 1})
+	if v.TotalValue != 36000 {
+		t.Errorf("total = %v, want 36000", v.TotalValue)
+	}
+	if len(v.FailedSymbols) != 1 || v.FailedSymbols[0] != "NOPE" {
+		t.Errorf("failed = %v, want [NOPE]", v.FailedSymbols)
+	}
+}
+
+func TestCoinCapProvider(t *testing.T) {
+	useConfig(t, &config{})
+	stub := newCoinCapStub(t, map[string]string{"BTC": "65000", "ETH": "3000.5"})
+	p := CoinCapProvider{}
+
+	price, err := p.Price(context.Background(), "ETH")
+	if err != nil || price != 3000.5 {
+		t.Errorf("Price(ETH) = %v, %v; want 3000.5", price, err)
+	}
+	if _, err := p.Price(context.Background(), "NOPE"); !errors.Is(err, ErrSymbolNotFound) {
+		t.Errorf("Price(NOPE): err = %v, want ErrSymbolNotFound", err)
+	}
+
+	prices, err := p.Prices(context.Background(), []string{"BTC", "ETH", "NOPE"})
+	var failures PriceErrors
+	if !errors.As(err, &failures) || len(failures) != 1 || !errors.Is(failures["NOPE"], ErrSymbolNotFound) {
+		t.Errorf("Prices: err = %v, want only NOPE failing as not found", err)
+	}
+	if len(prices) != 2 || prices["BTC"] != 65000 || prices["ETH"] != 3000.5 {
+		t.Errorf("Prices = %v", prices)
+	}
+	if n := stub.calls("/assets"); n != 1 {
+		t.Errorf("asset list fetched %d times, want 1 from the cache", n)
+	}
+
+	_, asOf, err := p.Snapshot(context.Background(), []string{"BTC"})
+	if err != nil || !asOf.Equal(time.UnixMilli(1760000000000)) {
+		t.Errorf("Snapshot: as of %v, err %v; want the list's timestamp", asOf, err)
+	}
+}
+
+// downProvider fails every request with err, like a provider that is down
+// or rate limited
+type downProvider struct{ err error }
+
+func (p downProvider) Price(ctx context.Context, symbol string) (float64, error) {
+	return 0, p.err
+}
+
+func (p downProvider) Prices(ctx context.Context, symbols []string) (map[string]float64, error) {
+	return nil, p.err
+}
+
+func TestFallbackServesFromSecondary(t *testing.T) {
+	logs := captureLogs(t, slog.LevelDebug)
+	f := FallbackProvider{Providers: []PriceProvider{
+		downProvider{errors.New("429 Too Many Requests")},
+		staticProvider{"BTC": 65000, "ETH": 3000},
+	}}
+
+	price, err := f.Price(context.Background(), "BTC")
+	if err != nil || price != 65000 {
+		t.Fatalf("Price = %v, %v; want 65000 from the secondary", price, err)
+	}
+	rec, ok := findRecord(logRecords(t, logs), "Price served")
+	if !ok || rec["provider"] != "static" {
+		t.Errorf("log record %v, want the static provider named", rec)
+	}
+
+	prices, err := f.Prices(context.Background(), []string{"BTC", "ETH"})
+	if err != nil || prices["BTC"] != 65000 || prices["ETH"] != 3000 {
+		t.Errorf("Prices = %v, %v; want both from the secondary", prices, err)
+	}
+}
+
+func TestFallbackFillsInWhatThePrimaryMissed(t *testing.T) {
+	f := FallbackProvider{Providers: []PriceProvider{
+		staticProvider{"BTC": 65000},
+		staticProvider{"BTC": 1, "ETH": 3000},
+	}}
+	prices, err := f.Prices(context.Background(), []string{"BTC", "ETH"})
+	if err != nil || prices["BTC"] != 65000 || prices["ETH"] != 3000 {
+		t.Errorf("Prices = %v, %v; want BTC from the primary and ETH from the secondary", prices, err)
+	}
+}
+
+func TestFallbackAllFailing(t *testing.T) {
+	outage := FallbackProvider{Providers: []PriceProvider{
+		downProvider{errors.New("connection refused")},
+		downProvider{errors.New("503 Service Unavailable")},
+	}}
+	_, err := outage.Price(context.Background(), "BTC")
+	if !errors.Is(err, errAllProvidersFailed) || errors.Is(err, ErrSymbolNotFound) {
+		t.Errorf("Price with every provider down: err = %v, want errAllProvidersFailed", err)
+	}
+	if _, err := outage.Prices(context.Background(), []string{"BTC"}); !errors.Is(err, errAllProvidersFailed) {
+		t.Errorf("Prices with every provider down: err = %v, want errAllProvidersFailed", err)
+	}
+
+	unlisted := FallbackProvider{Providers: []PriceProvider{staticProvider{}, staticProvider{"BTC": 1}}}
+	_, err = unlisted.Price(context.Background(), "NOPE")
+	if !errors.Is(err, ErrSymbolNotFound) || errors.Is(err, errAllProvidersFailed) {
+		t.Errorf("Price of a symbol nobody lists: err = %v, want ErrSymbolNotFound", err)
+	}
+	_, err = unlisted.Prices(context.Background(), []string{"BTC", "NOPE"})
+	var failures PriceErrors
+	if !errors.As(err, &failures) || len(failures) != 1 || !errors.Is(failures["NOPE"], ErrSymbolNotFound) {
+		t.Errorf("Prices: err = %v, want only NOPE failing as not found", err)
+	}
+
+	// One provider down and the other not listing it isn't "not found"
+	mixed := FallbackProvider{Providers: []PriceProvider{downProvider{errors.New("timeout")}, staticProvider{}}}
+	if _, err := mixed.Price(context.Background(), "BTC"); !errors.Is(err, errAllProvidersFailed) {
+		t.Errorf("Price with mixed failures: err = %v, want errAllProvidersFailed", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Defaults of rateLimitConfig's zero values
+const (
+	defaultRateLimitPerSecond = 10
+	defaultRateLimitBurst     = 20
+	defaultRateLimitClients   = 10000
+)
+
+// rateLimitConfig limits how fast each client IP may call the API, so one
+// client can't use up the CoinCap rate limit for everyone
+type rateLimitConfig struct {
+	// RequestsPerSecond is the sustained rate allowed per IP, 10 if zero.
+	// A negative value disables rate limiting.
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	// Burst is how many requests an idle client may make at once, 20 if zero
+	Burst int `json:"burst"`
+	// MaxClients caps how many IPs are tracked, 10000 if zero. Beyond it the
+	// longest idle client is forgotten.
+	MaxClients int `json:"max_clients"`
+}
+
+// clientLimiter is the token bucket of one client IP
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipRateLimiter hands out a token bucket per client IP, tracking at most
+// max clients
+type ipRateLimiter struct {
+	limit rate.Limit
+	burst int
+	max   int
+
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+}
+
+func newIPRateLimiter(c rateLimitConfig) *ipRateLimiter {
+	l := &ipRateLimiter{
+		limit:   rate.Limit(c.RequestsPerSecond),
+		burst:   c.Burst,
+		max:     c.MaxClients,
+		clients: make(map[string]*clientLimiter),
+	}
+	if l.limit == 0 {
+		l.limit = defaultRateLimitPerSecond
+	}
+	if l.burst <= 0 {
+		l.burst = defaultRateLimitBurst
+	}
+	if l.max <= 0 {
+		l.max = defaultRateLimitClients
+	}
+	return l
+}
+
+// reserve takes a token from ip's bucket, returning how long the client
+// must wait first if it is empty
+func (l *ipRateLimiter) reserve(ip string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	client, ok := l.clients[ip]
+	if !ok {
+		if len(l.clients) >= l.max {
+			l.evict(now)
+		}
+		client = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = now
+
+	reservation := client.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		// Rejected requests shouldn't use up tokens
+		reservation.CancelAt(now)
+	}
+	return delay
+}
+
+// evict forgets every client whose bucket has refilled, since a new bucket
+// would behave the same, or the longest idle one if none has
+func (l *ipRateLimiter) evict(now time.Time) {
+	refill := time.Duration(float64(l.burst) / float64(l.limit) * float64(time.Second))
+	var oldest string
+	for ip, client := range l.clients {
+		if now.Sub(client.lastSeen) >= refill {
+			delete(l.clients, ip)
+		} else if oldest == "" || client.lastSeen.Before(l.clients[oldest].lastSeen) {
+			oldest = ip
+		}
+	}
+	if len(l.clients) >= l.max {
+		delete(l.clients, oldest)
+	}
+}
+
+// clientIP returns the address a request came from, without its port.
+// X-Forwarded-For is not trusted since any client can set it.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimit rejects requests beyond a client's allowance with 429 Too Many
+// Requests and a Retry-After header
+func rateLimit(l *ipRateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if delay := l.reserve(clientIP(r), time.Now()); delay > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
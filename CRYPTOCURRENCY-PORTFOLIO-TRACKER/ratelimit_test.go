@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// limitedRequest sends a request from ip through handler
+func limitedRequest(handler http.Handler, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/portfolio/value", nil)
+	req.RemoteAddr = ip + ":40000"
+	return serve(handler, req)
+}
+
+func TestRateLimitRejectsBurstThenRecovers(t *testing.T) {
+	limiter := newIPRateLimiter(rateLimitConfig{RequestsPerSecond: 20, Burst: 3})
+	handler := rateLimit(limiter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 3; i++ {
+		if rec := limitedRequest(handler, "10.0.0.1"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst: status = %d", i+1, rec.Code)
+		}
+	}
+	rec := limitedRequest(handler, "10.0.0.1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("past the burst: status %d, Retry-After %q; want 429 and 1", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// Each client has a bucket of its own
+	if rec := limitedRequest(handler, "10.0.0.2"); rec.Code != http.StatusOK {
+		t.Errorf("another client: status = %d", rec.Code)
+	}
+
+	time.Sleep(100 * time.Millisecond) // Two tokens at 20 per second
+	if rec := limitedRequest(handler, "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Errorf("after waiting: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimitRejectionsDontUseTokens(t *testing.T) {
+	limiter := newIPRateLimiter(rateLimitConfig{RequestsPerSecond: 1, Burst: 1})
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	if delay := limiter.reserve("a", now); delay != 0 {
+		t.Fatalf("first request delayed %v", delay)
+	}
+	for i := 0; i < 5; i++ {
+		if delay := limiter.reserve("a", now.Add(500*time.Millisecond)); delay != 500*time.Millisecond {
+			t.Fatalf("rejected request %d: delay = %v, want 500ms", i+1, delay)
+		}
+	}
+	if delay := limiter.reserve("a", now.Add(time.Second)); delay != 0 {
+		t.Errorf("after refilling: delay = %v, want none", delay)
+	}
+}
+
+func TestRateLimiterTracksBoundedClients(t *testing.T) {
+	limiter := newIPRateLimiter(rateLimitConfig{RequestsPerSecond: 1, Burst: 10, MaxClients: 2})
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	limiter.reserve("a", now)
+	limiter.reserve("b", now.Add(time.Second))
+	limiter.reserve("c", now.Add(2*time.Second))
+
+	if len(limiter.clients) != 2 {
+		t.Fatalf("tracking %d clients, want 2", len(limiter.clients))
+	}
+	if _, ok := limiter.clients["a"]; ok {
+		t.Error("the longest idle client was kept")
+	}
+}
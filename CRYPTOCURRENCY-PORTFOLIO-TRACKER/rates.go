@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ratesCacheTTL   = 2 * time.Minute
+	defaultCurrency = "USD"
+	btcCurrency     = "BTC" // Valuations in BTC use its live price
+)
+
+// ratesCache holds the full rate list under a single key
+var ratesCache = newTTLCache[map[string]currencyRate](ratesCacheTTL)
+
+// coinCapRates is the response of /v2/rates
+type coinCapRates struct {
+	Data []struct {
+		ID             string `json:"id"`
+		Symbol         string `json:"symbol"`
+		CurrencySymbol string `json:"currencySymbol"`
+		Type           string `json:"type"`
+		RateUsd        string `json:"rateUsd"`
+	} `json:"data"`
+}
+
+// currencyRate is the USD value of one unit of a currency
+type currencyRate struct {
+	Symbol         string  `json:"symbol"`
+	Name           string  `json:"name"`
+	CurrencySymbol string  `json:"currency_symbol,omitempty"`
+	Type           string  `json:"type"`
+	RateUsd        float64 `json:"rate_usd"`
+}
+
+var (
+	errUnknownCurrency         = errors.New("unknown currency")
+	errDenominationUnavailable = errors.New("price of the display currency is unavailable")
+)
+
+// getCoinCapRates returns all CoinCap rates keyed by symbol
+func getCoinCapRates(ctx context.Context) (map[string]currencyRate, error) {
+	if rates, ok := ratesCache.get("all"); ok {
+		return rates, nil
+	}
+
+	var rateData coinCapRates
+	err := coinCapGet(ctx, coinCapURL("/rates"), &rateData)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := make(map[string]currencyRate, len(rateData.Data))
+	for _, rd := range rateData.Data {
+		rate, err := strconv.ParseFloat(rd.RateUsd, 64)
+		if err != nil || rate <= 0 {
+			continue
+		}
+		rates[strings.ToUpper(rd.Symbol)] = currencyRate{
+			Symbol:         strings.ToUpper(rd.Symbol),
+			Name:           rd.ID,
+			CurrencySymbol: rd.CurrencySymbol,
+			Type:           rd.Type,
+			RateUsd:        rate,
+		}
+	}
+
+	ratesCache.set("all", rates)
+	return rates, nil
+}
+
+// getCoinCapRate returns the USD value of one unit of currency
+func getCoinCapRate(ctx context.Context, currency string) (float64, error) {
+	currency = strings.ToUpper(currency)
+	switch currency {
+	case defaultCurrency:
+		return 1, nil
+	case btcCurrency:
+		// Priced through the providers like any holding, so BTC-denominated
+		// totals match the BTC price used in the valuation itself
+		price, err := withPriceRetry(ctx, func(ctx context.Context) (float64, error) {
+			return priceProvider.Price(ctx, btcCurrency)
+		})
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", errDenominationUnavailable, err)
+		}
+		if price <= 0 {
+			return 0, fmt.Errorf("%w: no BTC price", errDenominationUnavailable)
+		}
+		return price, nil
+	}
+	rates, err := getCoinCapRates(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rate, ok := rates[currency]
+	if !ok {
+		return 0, fmt.Errorf("%w %s", errUnknownCurrency, currency)
+	}
+	return rate.RateUsd, nil
+}
+
+// currency is the configured display currency, USD unless set
+func (c *config) currency() string {
+	if c == nil || c.Currency == "" {
+		return defaultCurrency
+	}
+	return strings.ToUpper(c.Currency)
+}
+
+// validateCurrency checks that the configured currency has a CoinCap rate.
+// An unreachable CoinCap only skips the check, as for symbols.
+func validateCurrency(ctx context.Context, c *config) error {
+	_, err := getCoinCapRate(ctx, c.currency())
+	switch {
+	case errors.Is(err, errUnknownCurrency):
+		return fmt.Errorf("invalid currency %q: CoinCap has no rate for it", c.Currency)
+	case err != nil:
+		slog.Warn("Could not validate currency against CoinCap", "component", "config", "currency", c.currency(), "error", err)
+	}
+	return nil
+}
+
+// requestCurrency picks the display currency for a valuation request: the
+// currency query parameter, else the saved preference of userID, the user
+// the request was scoped to, else the configured currency
+func (s *Server) requestCurrency(r *http.Request, userID int) (string, error) {
+	if currency := r.URL.Query().Get("currency"); currency != "" {
+		return strings.ToUpper(currency), nil
+	}
+	if userID != 0 {
+		currency, err := s.store.PreferredCurrency(userID)
+		if err != nil {
+			return "", err
+		}
+		if currency != "" {
+			return currency, nil
+		}
+	}
+	return cfg.currency(), nil
+}
+
+// requestRate resolves the request's display currency and its USD rate.
+// It writes an error response and returns false on failure.
+func (s *Server) requestRate(w http.ResponseWriter, r *http.Request, userID int) (string, float64, bool) {
+	currency, err := s.requestCurrency(r, userID)
+	if err != nil {
+		serverError(w, r, "Error fetching user settings", err)
+		return "", 0, false
+	}
+	rate, err := getCoinCapRate(r.Context(), currency)
+	if err != nil {
+		if errors.Is(err, errUnknownCurrency) {
+			http.Error(w, "Unsupported currency", http.StatusBadRequest)
+			return "", 0, false
+		}
+		if errors.Is(err, errDenominationUnavailable) {
+			reportError("coincap", "Error pricing display currency", "currency", currency, "err", err)
+			http.Error(w, "BTC price unavailable", http.StatusServiceUnavailable)
+			return "", 0, false
+		}
+		serverError(w, r, "Error fetching currency rates", err)
+		return "", 0, false
+	}
+	return currency, rate, true
+}
+
+// handleCurrencies lists the fiat currencies valuations can be shown in,
+// with the USD value of one unit of each
+func handleCurrencies(w http.ResponseWriter, r *http.Request) {
+	rates, err := getCoinCapRates(r.Context())
+	if err != nil {
+		serverError(w, r, "Error fetching currency rates", err)
+		return
+	}
+
+	currencies := []currencyRate{{Symbol: defaultCurrency, Name: "united-states-dollar", CurrencySymbol: "$", Type: "fiat", RateUsd: 1}}
+	for _, rate := range rates {
+		if rate.Type == "fiat" && rate.Symbol != defaultCurrency {
+			currencies = append(currencies, rate)
+		}
+	}
+	sort.Slice(currencies[1:], func(i, j int) bool {
+		return currencies[i+1].Symbol < currencies[j+1].Symbol
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(currencies)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
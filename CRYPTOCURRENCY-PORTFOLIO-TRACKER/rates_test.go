@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPortfolioValueInConfiguredCurrency(t *testing.T) {
+	store := &fakeStore{rows: []Portfolio{{ID: 1, UserID: 1, Symbol: "BTC", Amount: 2}}}
+	_, mux := newTestServer(t, store, fakePrices{"BTC": 100})
+	stub := newCoinCapStub(t, nil)
+	stub.rates = map[string]string{"EUR": "1.25", "GBP": "1.6"}
+
+	for _, c := range []struct {
+		configured, query string
+		want              float64
+		currency          string
+	}{
+		{"", "", 200, "USD"},
+		{"", "?currency=eur", 160, "EUR"},
+		{"EUR", "", 160, "EUR"},
+		{"EUR", "?currency=GBP", 125, "GBP"}, // The query overrides the config
+	} {
+		cfg.Currency = c.configured
+		sep := "?"
+		if c.query != "" {
+			sep = "&"
+		}
+		rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/value"+c.query+sep+"user_id=1", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q %q: status = %d, body %s", c.configured, c.query, rec.Code, rec.Body)
+		}
+		var got struct {
+			TotalValue float64 `json:"total_value"`
+			Currency   string  `json:"currency"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.TotalValue != c.want || got.Currency != c.currency {
+			t.Errorf("config %q, query %q: got %v %s, want %v %s", c.configured, c.query, got.TotalValue, got.Currency, c.want, c.currency)
+		}
+	}
+	// The rates are fetched once and cached
+	if n := stub.calls("/rates"); n != 1 {
+		t.Errorf("rates fetched %d times, want 1", n)
+	}
+}
+
+func TestPortfolioValueRejectsUnknownCurrency(t *testing.T) {
+	store := &fakeStore{rows: []Portfolio{{ID: 1, UserID: 1, Symbol: "BTC", Amount: 2}}}
+	_, mux := newTestServer(t, store, fakePrices{"BTC": 100})
+	newCoinCapStub(t, nil).rates = map[string]string{"EUR": "1.25"}
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/value?user_id=1&currency=XYZ", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Flags of the threshold dry run, which replays price_history instead of
+// starting the tracker
+var (
+	replaySymbol     = flag.String("replay", "", "replay the recorded price history of `symbol` against -threshold, print the crossings and exit")
+	replayPrice      = flag.String("threshold", "", "threshold `price` for -replay")
+	replayDirection  = flag.String("direction", directionAbove, "alert direction for -replay, \"above\" or \"below\"")
+	replayHysteresis = flag.String("hysteresis", "0", "re-arm margin for -replay, as in the token config")
+	replaySince      = flag.Duration("since", 0, "how far back -replay looks, all recorded history if 0")
+)
+
+// thresholdCrossing is one move of the price across a threshold
+type thresholdCrossing struct {
+	Time  time.Time `json:"time"`
+	Price float64   `json:"price"`
+	// Direction is the side of the threshold the price moved to
+	Direction string `json:"direction"`
+	// Alert is true for crossings the monitor would notify, false for the
+	// moves back that re-arm it
+	Alert bool `json:"alert"`
+}
+
+// replayThreshold walks points, oldest first, through the monitor's alert
+// logic for bound and returns the crossings without notifying anyone. The
+// first point only sets the starting side, since no crossing was seen.
+func replayThreshold(points []pricePoint, bound priceBound, hysteresis decimal) []thresholdCrossing {
+	away := directionAbove
+	if !bound.Below {
+		away = directionBelow
+	}
+
+	var crossings []thresholdCrossing
+	triggered := false
+	for i, p := range points {
+		crossed, cleared := thresholdState(decimalFromFloat(p.Price), bound.Price, hysteresis, bound.Below)
+		switch {
+		case i == 0:
+			triggered = crossed
+		case crossed && !triggered:
+			crossings = append(crossings, thresholdCrossing{Time: p.Time, Price: p.Price, Direction: bound.direction(), Alert: true})
+			triggered = true
+		case cleared && triggered:
+			crossings = append(crossings, thresholdCrossing{Time: p.Time, Price: p.Price, Direction: away})
+			triggered = false
+		}
+	}
+	return crossings
+}
+
+// replayReport is what -replay prints
+type replayReport struct {
+	Symbol     string              `json:"symbol"`
+	Threshold  decimal             `json:"threshold"`
+	Direction  string              `json:"direction"`
+	Hysteresis decimal             `json:"hysteresis"`
+	Points     int                 `json:"points"`
+	Alerts     int                 `json:"alerts"`
+	Crossings  []thresholdCrossing `json:"crossings"`
+}
+
+// runReplay performs the dry run the -replay flags describe against the
+// price history in store
+func runReplay(store Store) error {
+	if *replayPrice == "" {
+		return fmt.Errorf("-replay requires -threshold")
+	}
+	threshold, err := parseDecimal(*replayPrice)
+	if err != nil {
+		return fmt.Errorf("invalid -threshold: %v", err)
+	}
+	hysteresis, err := parseDecimal(*replayHysteresis)
+	if err != nil || hysteresis.Sign() < 0 {
+		return fmt.Errorf("invalid -hysteresis %q", *replayHysteresis)
+	}
+	if *replayDirection != directionAbove && *replayDirection != directionBelow {
+		return fmt.Errorf("invalid -direction %q", *replayDirection)
+	}
+
+	to := time.Now()
+	var from time.Time
+	if *replaySince > 0 {
+		from = to.Add(-*replaySince)
+	}
+	symbol := normalizeSymbol(*replaySymbol)
+	points, err := store.PriceHistory(symbol, from, to)
+	if err != nil {
+		return err
+	}
+
+	bound := priceBound{Price: threshold, Below: *replayDirection == directionBelow}
+	report := replayReport{
+		Symbol:     symbol,
+		Threshold:  threshold,
+		Direction:  *replayDirection,
+		Hysteresis: hysteresis,
+		Points:     len(points),
+		Crossings:  replayThreshold(points, bound, hysteresis),
+	}
+	for _, c := range report.Crossings {
+		if c.Alert {
+			report.Alerts++
+		}
+	}
+	if report.Crossings == nil {
+		report.Crossings = []thresholdCrossing{}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// syntheticHistory is prices recorded an hour apart from start
+func syntheticHistory(start time.Time, prices ...float64) []pricePoint {
+	points := make([]pricePoint, len(prices))
+	for i, price := range prices {
+		points[i] = pricePoint{Time: start.Add(time.Duration(i) * time.Hour), Price: price}
+	}
+	return points
+}
+
+// describeCrossings renders crossings as "hour:direction[!]", with ! marking alerts
+func describeCrossings(start time.Time, crossings []thresholdCrossing) string {
+	var parts []string
+	for _, c := range crossings {
+		part := fmt.Sprintf("%d:%s", int(c.Time.Sub(start).Hours()), c.Direction)
+		if c.Alert {
+			part += "!"
+		}
+		parts = append(parts, part)
+	}
+	return fmt.Sprint(parts)
+}
+
+func TestReplayThreshold(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	above := priceBound{Price: decimalFromFloat(100)}
+	below := priceBound{Price: decimalFromFloat(100), Below: true}
+
+	for _, c := range []struct {
+		name       string
+		bound      priceBound
+		hysteresis float64
+		prices     []float64
+		want       string
+	}{
+		{"no crossing", above, 0, []float64{90, 95, 99}, "[]"},
+		{"up and back", above, 0, []float64{90, 105, 110, 95, 101}, "[1:above! 3:below 4:above!]"},
+		{"already above at the start", above, 0, []float64{120, 130, 90, 110}, "[2:below 3:above!]"},
+		{"flapping inside the hysteresis", above, 5, []float64{90, 101, 97, 102, 94, 101}, "[1:above! 4:below 5:above!]"},
+		{"falling through a lower bound", below, 0, []float64{120, 99, 98, 101, 80}, "[1:below! 3:above 4:below!]"},
+		{"a single point", above, 0, []float64{150}, "[]"},
+	} {
+		crossings := replayThreshold(syntheticHistory(start, c.prices...), c.bound, decimalFromFloat(c.hysteresis))
+		if got := describeCrossings(start, crossings); got != c.want {
+			t.Errorf("%s: crossings %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestReplayCrossingsCarryTheirPrice(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	crossings := replayThreshold(syntheticHistory(start, 90, 104.5), priceBound{Price: decimalFromFloat(100)}, decimal{})
+	if len(crossings) != 1 || crossings[0].Price != 104.5 || !crossings[0].Time.Equal(start.Add(time.Hour)) {
+		t.Errorf("crossings = %+v, want one at 104.5 an hour in", crossings)
+	}
+}
+
+func TestRunReplayValidatesFlags(t *testing.T) {
+	saved := [...]string{*replayPrice, *replayDirection, *replayHysteresis}
+	t.Cleanup(func() { *replayPrice, *replayDirection, *replayHysteresis = saved[0], saved[1], saved[2] })
+
+	for _, c := range []struct{ threshold, direction, hysteresis string }{
+		{"", directionAbove, "0"},
+		{"lots", directionAbove, "0"},
+		{"100", "sideways", "0"},
+		{"100", directionAbove, "-1"},
+	} {
+		*replayPrice, *replayDirection, *replayHysteresis = c.threshold, c.direction, c.hysteresis
+		if err := runReplay(newSQLStore(t)); err == nil {
+			t.Errorf("%+v: accepted", c)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultPortfolioRuleMinutes = 15
+
+// Metrics a portfolio rule can test for each holding
+const (
+	ruleMetricChange24h    = "change_24h_percent"     // Signed 24 hour price change
+	ruleMetricAbsChange24h = "abs_change_24h_percent" // Size of the 24 hour move, either way
+	ruleMetricValue        = "value_usd"              // Current value of the holding
+	ruleMetricGain         = "gain_percent"           // Unrealized gain against the average cost
+)
+
+// portfolioRule is a condition checked against every holding of every
+// user, e.g. {"metric": "abs_change_24h_percent", "above": 10} for "any
+// holding moved more than 10% today". At least one bound must be set.
+type portfolioRule struct {
+	Name   string   `json:"name"`
+	Metric string   `json:"metric"`
+	Above  *float64 `json:"above,omitempty"`
+	Below  *float64 `json:"below,omitempty"`
+}
+
+// validate checks the metric and that the rule has a bound
+func (pr portfolioRule) validate() error {
+	switch pr.Metric {
+	case ruleMetricChange24h, ruleMetricAbsChange24h, ruleMetricValue, ruleMetricGain:
+	default:
+		return fmt.Errorf("invalid metric %q", pr.Metric)
+	}
+	if pr.Above == nil && pr.Below == nil {
+		return errors.New("above or below is required")
+	}
+	return nil
+}
+
+// matches reports whether value satisfies the rule's bounds
+func (pr portfolioRule) matches(value float64) bool {
+	if pr.Above != nil && value <= *pr.Above {
+		return false
+	}
+	if pr.Below != nil && value >= *pr.Below {
+		return false
+	}
+	return true
+}
+
+// portfolioRuleInterval is how often the portfolio rules are evaluated
+func portfolioRuleInterval() time.Duration {
+	if cfg.PortfolioRuleMinutes <= 0 {
+		return defaultPortfolioRuleMinutes * time.Minute
+	}
+	return time.Duration(cfg.PortfolioRuleMinutes) * time.Minute
+}
+
+var (
+	ruleMatchesMu sync.Mutex
+	// ruleMatches holds the "rule/user/symbol" matches already notified, so
+	// a holding is reported once when it starts matching, not every cycle
+	ruleMatches = make(map[string]bool)
+)
+
+// ruleMatch is a holding that satisfied a rule
+type ruleMatch struct {
+	rule   string
+	symbol string
+	value  float64
+}
+
+// evaluatePortfolioRules is the scheduled job checking every rule against
+// each user's holdings. Newly matching holdings are sent as a single
+// notification per user.
+func (s *Server) evaluatePortfolioRules(ctx context.Context) error {
+	if paused, _ := monitoringPaused(); paused {
+		return nil
+	}
+	h, err := s.store.Holdings(0)
+	if err != nil {
+		return err
+	}
+	if len(h.bySymbol) == 0 {
+		return nil
+	}
+	// One snapshot prices every holding at the same moment where the
+	// providers allow it
+	v := valueHoldingsSnapshot(ctx, h.bySymbol)
+	var changes map[string]float64
+	if rulesUseChange() {
+		if changes, err = coinCapChanges(ctx); err != nil {
+			// Only the change rules depend on it; the others still apply
+			reportError("rules", "Error fetching 24h changes", "err", err)
+		}
+	}
+
+	users := make([]int, 0, len(h.byUser))
+	for userID := range h.byUser {
+		users = append(users, userID)
+	}
+	sort.Ints(users)
+
+	needCosts := false
+	for _, rule := range cfg.PortfolioRules {
+		needCosts = needCosts || rule.Metric == ruleMetricGain
+	}
+
+	ruleMatchesMu.Lock()
+	defer ruleMatchesMu.Unlock()
+	current := make(map[string]bool)
+	for _, userID := range users {
+		amounts := h.byUser[userID]
+		var costs map[string]float64
+		if needCosts {
+			if costs, err = s.store.HoldingCosts(userID); err != nil {
+				return err
+			}
+		}
+
+		var fresh []ruleMatch
+		symbols := make([]string, 0, len(amounts))
+		for symbol := range amounts {
+			symbols = append(symbols, symbol)
+		}
+		sort.Strings(symbols)
+		for _, rule := range cfg.PortfolioRules {
+			for _, symbol := range symbols {
+				value, ok := ruleMetric(rule.Metric, symbol, amounts[symbol], v, changes, costs)
+				if !ok || !rule.matches(value) {
+					continue
+				}
+				key := fmt.Sprintf("%s/%d/%s", rule.Name, userID, symbol)
+				current[key] = true
+				if !ruleMatches[key] {
+					fresh = append(fresh, ruleMatch{rule: rule.Name, symbol: symbol, value: value})
+				}
+			}
+		}
+		if len(fresh) > 0 {
+			notify(formatRuleMatches(userID, fresh))
+		}
+	}
+	ruleMatches = current
+	return nil
+}
+
+// ruleMetric returns the metric of a holding, or false if it isn't known
+func ruleMetric(metric, symbol string, amount float64, v valuation, changes, costs map[string]float64) (float64, bool) {
+	if amount <= 0 {
+		return 0, false
+	}
+	switch metric {
+	case ruleMetricChange24h:
+		change, ok := changes[symbol]
+		return change, ok
+	case ruleMetricAbsChange24h:
+		change, ok := changes[symbol]
+		return math.Abs(change), ok
+	case ruleMetricValue:
+		price, ok := v.Prices[symbol]
+		return price * amount, ok
+	case ruleMetricGain:
+		price, priced := v.Prices[symbol]
+		cost, known := costs[symbol]
+		if !priced || !known || cost <= 0 {
+			return 0, false
+		}
+		return (price/cost - 1) * 100, true
+	}
+	return 0, false
+}
+
+// rulesUseChange reports whether any rule is on the 24 hour change
+func rulesUseChange() bool {
+	for _, rule := range cfg.PortfolioRules {
+		if rule.Metric == ruleMetricChange24h || rule.Metric == ruleMetricAbsChange24h {
+			return true
+		}
+	}
+	return false
+}
+
+// coinCapChanges returns the 24 hour change percentage of every CoinCap
+// asset. Only CoinCap reports it, whichever provider supplies the prices.
+func coinCapChanges(ctx context.Context) (map[string]float64, error) {
+	assets, err := withPriceRetry(ctx, getCoinCapAssets)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(map[string]float64, len(assets.Data))
+	for _, asset := range assets.Data {
+		if change, err := strconv.ParseFloat(asset.ChangePercent24Hr, 64); err == nil {
+			changes[asset.Symbol] = change
+		}
+	}
+	return changes, nil
+}
+
+// formatRuleMatches builds the consolidated notification for one user
+func formatRuleMatches(userID int, matches []ruleMatch) string {
+	lines := make([]string, 0, len(matches))
+	for _, m := range matches {
+		lines = append(lines, fmt.Sprintf("%s: %s (%.2f)", m.rule, m.symbol, m.value))
+	}
+	return fmt.Sprintf("Portfolio rules matched for user %d: %s", userID, strings.Join(lines, "; "))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jobs runs the service's periodic background tasks
+var jobs = newScheduler()
+
+// schedule decides when a job runs next
+type schedule interface {
+	next(from time.Time) time.Time
+}
+
+// every runs a job at a fixed interval
+type every time.Duration
+
+func (e every) next(from time.Time) time.Time {
+	return from.Add(time.Duration(e))
+}
+
+// dailyAt runs a job once a day at a wall clock time in a time zone
+type dailyAt struct {
+	hour, minute int
+	loc          *time.Location
+}
+
+func (d dailyAt) next(from time.Time) time.Time {
+	local := from.In(d.loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), d.hour, d.minute, 0, 0, d.loc)
+	if !next.After(from) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, d.hour, d.minute, 0, 0, d.loc)
+	}
+	return next
+}
+
+// job is a named periodic task and the outcome of its last run
+type job struct {
+	name  string
+	sched schedule
+	run   func(ctx context.Context) error
+
+	mu      sync.Mutex
+	lastRun time.Time
+	nextRun time.Time
+	lastErr error
+	runs    int
+}
+
+// jobStatus is the externally visible state of a job
+type jobStatus struct {
+	Name      string     `json:"name"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	NextRun   time.Time  `json:"next_run"`
+	LastError string     `json:"last_error,omitempty"`
+	Runs      int        `json:"runs"`
+}
+
+// scheduler runs registered jobs on their schedules until its context is
+// cancelled. Each job runs in its own goroutine and a panicking job is
+// recovered and recorded as a failed run.
+type scheduler struct {
+	mu   sync.Mutex
+	jobs []*job
+	ctx  context.Context // Set once run has been called
+	wg   sync.WaitGroup
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{}
+}
+
+// add registers a job, first due at its schedule's next time from now. Jobs
+// added after run has started are scheduled straight away on the same terms
+// rather than waiting for a restart; none runs the moment it is added.
+func (s *scheduler) add(name string, sched schedule, run func(ctx context.Context) error) {
+	j := &job{name: name, sched: sched, run: run, nextRun: sched.next(time.Now())}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, j)
+	if s.ctx != nil {
+		s.start(s.ctx, j)
+	}
+}
+
+// run starts all jobs and blocks until ctx is cancelled and they have stopped
+func (s *scheduler) run(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	for _, j := range s.jobs {
+		s.start(ctx, j)
+	}
+	s.mu.Unlock()
+
+	<-ctx.Done()
+	s.wg.Wait()
+}
+
+// start runs j's loop in a new goroutine; s.mu must be held
+func (s *scheduler) start(ctx context.Context, j *job) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			j.mu.Lock()
+			wait := time.Until(j.nextRun)
+			j.mu.Unlock()
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			err := runJob(ctx, j)
+			if err != nil {
+				reportError("job", "Job failed", "job", j.name, "err", err)
+			}
+
+			now := time.Now()
+			j.mu.Lock()
+			j.lastRun = now
+			j.lastErr = err
+			j.runs++
+			j.nextRun = j.sched.next(now)
+			j.mu.Unlock()
+		}
+	}()
+}
+
+// runJob runs a job once, converting a panic into an error
+func runJob(ctx context.Context, j *job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return j.run(ctx)
+}
+
+// status returns the state of every registered job
+func (s *scheduler) status() []jobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]jobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		st := jobStatus{Name: j.name, NextRun: j.nextRun, Runs: j.runs}
+		if !j.lastRun.IsZero() {
+			lastRun := j.lastRun
+			st.LastRun = &lastRun
+		}
+		if j.lastErr != nil {
+			st.LastError = j.lastErr.Error()
+		}
+		j.mu.Unlock()
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// handleJobs lists the background jobs with their last and next runs
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(jobs.status())
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
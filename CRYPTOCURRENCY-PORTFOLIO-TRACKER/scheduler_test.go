@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJobAddedAfterRunWaitsForItsSchedule(t *testing.T) {
+	s := newScheduler()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	var runs atomic.Int32
+	ran := make(chan struct{}, 10)
+	s.add("late", every(50*time.Millisecond), func(ctx context.Context) error {
+		runs.Add(1)
+		ran <- struct{}{}
+		return nil
+	})
+	time.Sleep(10 * time.Millisecond)
+	if n := runs.Load(); n != 0 {
+		t.Fatalf("job ran %d times before its first interval", n)
+	}
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job added after run never ran")
+	}
+}
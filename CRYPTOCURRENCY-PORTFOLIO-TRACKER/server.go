@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// PriceClient prices holdings for the HTTP handlers
+type PriceClient interface {
+	// Price returns the current USD price of symbol
+	Price(ctx context.Context, symbol string) (float64, error)
+	// Value prices every holding, listing those it couldn't in FailedSymbols
+	Value(ctx context.Context, amounts map[string]float64) valuation
+	// ValueSnapshot prices every holding from a single observation, setting
+	// AsOf, or like Value with AsOf zero if no provider can take one
+	ValueSnapshot(ctx context.Context, amounts map[string]float64) valuation
+}
+
+// providerPriceClient is the PriceClient backed by priceProvider
+type providerPriceClient struct{}
+
+func (providerPriceClient) Price(ctx context.Context, symbol string) (float64, error) {
+	return priceProvider.Price(ctx, symbol)
+}
+
+func (providerPriceClient) Value(ctx context.Context, amounts map[string]float64) valuation {
+	return valueHoldings(ctx, amounts)
+}
+
+func (providerPriceClient) ValueSnapshot(ctx context.Context, amounts map[string]float64) valuation {
+	return valueHoldingsSnapshot(ctx, amounts)
+}
+
+// Server serves the endpoints and runs the monitors and jobs that need a
+// Store and a PriceClient, so either can be swapped out
+type Server struct {
+	store  Store
+	prices PriceClient
+
+	// strictAccept answers 406 rather than JSON to reads accepting neither
+	// JSON nor XML
+	strictAccept bool
+}
+
+// NewServer returns a Server using store and prices
+func NewServer(store Store, prices PriceClient) *Server {
+	return &Server{store: store, prices: prices}
+}
+
+// routes registers the Server's handlers on mux
+func (s *Server) routes(mux *http.ServeMux) {
+	mux.HandleFunc("/portfolio", s.handlePortfolio)
+	mux.HandleFunc("/portfolio/add", requireJSON(s.handleAddToPortfolio))
+	mux.HandleFunc("/portfolio/remove", requireJSON(s.handleRemoveFromPortfolio))
+	mux.HandleFunc("/portfolio/update", requireJSON(s.handleUpdatePortfolio))
+	mux.HandleFunc("/portfolio/value", s.handlePortfolioValue)
+	mux.HandleFunc("/portfolio/export", s.handleExportPortfolio)
+	mux.HandleFunc("/portfolio/import", s.handleImportHoldings)
+	mux.HandleFunc("/register", requireJSON(s.handleRegister))
+	mux.HandleFunc("/login", requireJSON(s.handleLogin))
+	mux.HandleFunc("/portfolio/preview-add", s.handlePreviewAdd)
+	mux.HandleFunc("/portfolio/performers", s.handlePerformers)
+	mux.HandleFunc("/portfolio/pnl", s.handlePortfolioPnL)
+	mux.HandleFunc("/portfolio/target", s.handleTargetPrice)
+	mux.HandleFunc("/portfolio/stats/sharpe", s.handleSharpe)
+	mux.HandleFunc("/portfolio/twr", s.handleTWR)
+	mux.HandleFunc("/portfolio/thresholds", requireJSON(s.handleHoldingThresholds))
+	mux.HandleFunc("/analytics/correlation", s.handleCorrelation)
+	mux.HandleFunc("/transactions/import", s.handleImportTransactions)
+	mux.HandleFunc("/transactions/pnl", s.handleLedgerPnL)
+	mux.HandleFunc("/admin/backup", s.handleBackup)
+	mux.HandleFunc("/history", s.handlePriceHistory)
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/users/settings", requireJSON(s.handleUserSettings))
+	mux.HandleFunc("/config/thresholds", requireJSON(s.handleBulkThresholds))
+}
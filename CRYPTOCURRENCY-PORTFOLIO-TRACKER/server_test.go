@@ -0,0 +1,672 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore is an in-memory Store for handler tests. Methods a test
+// doesn't need are left to the nil embedded Store and panic if called.
+type fakeStore struct {
+	Store
+
+	mu       sync.Mutex
+	rows     []Portfolio
+	costs    map[string]float64
+	currency map[int]string
+	pingErr  error
+	states   map[string]persistedState
+}
+
+func (f *fakeStore) ListPortfolio(userID, limit, offset int) ([]Portfolio, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var matched []Portfolio
+	for _, p := range f.rows {
+		if userID == 0 || p.UserID == userID {
+			matched = append(matched, p)
+		}
+	}
+	page := []Portfolio{}
+	for i := offset; i < len(matched) && len(page) < limit; i++ {
+		page = append(page, matched[i])
+	}
+	return page, len(matched), nil
+}
+
+func (f *fakeStore) AddHolding(p Portfolio) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p.ID = len(f.rows) + 1
+	f.rows = append(f.rows, p)
+	return nil
+}
+
+func (f *fakeStore) RemoveHolding(id, userID int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, p := range f.rows {
+		if p.ID == id && (userID == 0 || p.UserID == userID) {
+			f.rows = append(f.rows[:i], f.rows[i+1:]...)
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (f *fakeStore) Holdings(userID int) (holdings, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h := holdings{bySymbol: make(map[string]float64), byUser: make(map[int]map[string]float64)}
+	for _, p := range f.rows {
+		if userID != 0 && p.UserID != userID {
+			continue
+		}
+		h.bySymbol[p.Symbol] += p.Amount
+		if h.byUser[p.UserID] == nil {
+			h.byUser[p.UserID] = make(map[string]float64)
+		}
+		h.byUser[p.UserID][p.Symbol] += p.Amount
+	}
+	return h, nil
+}
+
+func (f *fakeStore) HoldingCosts(userID int) (map[string]float64, error) {
+	return f.costs, nil
+}
+
+func (f *fakeStore) PreferredCurrency(userID int) (string, error) {
+	return f.currency[userID], nil
+}
+
+func (f *fakeStore) RecordPrice(symbol string, price float64, at time.Time) error {
+	return nil
+}
+
+func (f *fakeStore) TokenState(symbol string) (persistedState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ps, ok := f.states[symbol]
+	if !ok {
+		return persistedState{}, sql.ErrNoRows
+	}
+	return ps, nil
+}
+
+func (f *fakeStore) SaveTokenState(symbol string, ps persistedState) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.states == nil {
+		f.states = make(map[string]persistedState)
+	}
+	f.states[symbol] = ps
+	return nil
+}
+
+func (f *fakeStore) Ping(ctx context.Context) error {
+	return f.pingErr
+}
+
+// fakePrices is a PriceClient with fixed USD prices
+type fakePrices map[string]float64
+
+func (f fakePrices) quote(symbol string) (priceQuote, error) {
+	price, ok := f[symbol]
+	if !ok {
+		return priceQuote{}, fmt.Errorf("%w %s", ErrSymbolNotFound, symbol)
+	}
+	return priceQuote{Provider: "fake", Price: price}, nil
+}
+
+func (f fakePrices) Price(ctx context.Context, symbol string) (float64, error) {
+	q, err := f.quote(symbol)
+	return q.Price, err
+}
+
+func (f fakePrices) Value(ctx context.Context, amounts map[string]float64) valuation {
+	return valueHoldingsWith(amounts, f.quote)
+}
+
+func (f fakePrices) ValueSnapshot(ctx context.Context, amounts map[string]float64) valuation {
+	v := valueHoldingsWith(amounts, f.quote)
+	v.AsOf = time.Now().UTC()
+	return v
+}
+
+// useConfig sets cfg to c until the test ends
+func useConfig(t testing.TB, c *config) {
+	saved := cfg
+	cfg = c
+	t.Cleanup(func() { cfg = saved })
+}
+
+// newTestServer returns a Server on store and prices with an empty config,
+// restored when the test ends
+func newTestServer(t *testing.T, store Store, prices PriceClient) (*Server, *http.ServeMux) {
+	t.Helper()
+	useConfig(t, &config{})
+
+	s := NewServer(store, prices)
+	mux := http.NewServeMux()
+	s.routes(mux)
+	return s, mux
+}
+
+// stopMonitors cancels appCtx for the test, so monitors started by config
+// updates stop straight away, and waits for them when the test ends
+func stopMonitors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	saved := appCtx
+	appCtx = ctx
+	t.Cleanup(func() {
+		wg.Wait()
+		appCtx = saved
+	})
+}
+
+// serve runs req through handler and returns the recorded response
+func serve(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// postJSON sends body to path on handler as a JSON POST
+func postJSON(handler http.Handler, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	return serve(handler, req)
+}
+
+// addHoldings stores each of rows, failing the test on an error
+func addHoldings(t *testing.T, store Store, rows ...Portfolio) {
+	t.Helper()
+	for _, p := range rows {
+		if err := store.AddHolding(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPortfolioFiltersByUser(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{})
+	addHoldings(t, store,
+		Portfolio{UserID: 1, Symbol: "BTC", Amount: 1},
+		Portfolio{UserID: 2, Symbol: "ETH", Amount: 2},
+		Portfolio{UserID: 1, Symbol: "SOL", Amount: 3},
+	)
+
+	for _, c := range []struct {
+		query string
+		want  []string
+	}{
+		{"?user_id=1", []string{"BTC", "SOL"}},
+		{"?user_id=2", []string{"ETH"}},
+		{"?user_id=3", nil},
+		{"", []string{"BTC", "ETH", "SOL"}}, // Everyone's, as before users existed
+	} {
+		rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio"+c.query, nil))
+		var page portfolioPage
+		if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, p := range page.Items {
+			got = append(got, p.Symbol)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) || page.Total != len(c.want) {
+			t.Errorf("/portfolio%s: got %v of %d, want %v", c.query, got, page.Total, c.want)
+		}
+	}
+}
+
+func TestPortfolioFromFakeStore(t *testing.T) {
+	store := &fakeStore{rows: []Portfolio{
+		{ID: 1, UserID: 1, Symbol: "BTC", Amount: 2},
+		{ID: 2, UserID: 1, Symbol: "ETH", Amount: 10},
+	}}
+	_, mux := newTestServer(t, store, fakePrices{})
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio?user_id=1&limit=1&offset=1", nil))
+	var page portfolioPage
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || len(page.Items) != 1 || page.Items[0].Symbol != "ETH" || page.Total != 2 {
+		t.Errorf("status %d, page %+v; want ETH of 2", rec.Code, page)
+	}
+}
+
+// brokenStore fails every read and write the portfolio handlers make
+type brokenStore struct {
+	Store
+}
+
+var errStoreDown = errors.New("store down")
+
+func (brokenStore) ListPortfolio(userID, limit, offset int) ([]Portfolio, int, error) {
+	return nil, 0, errStoreDown
+}
+
+func (brokenStore) AddHolding(p Portfolio) error {
+	return errStoreDown
+}
+
+func (brokenStore) Holdings(userID int) (holdings, error) {
+	return holdings{}, errStoreDown
+}
+
+func (brokenStore) RemoveHolding(id, userID int) (int64, error) {
+	return 0, errStoreDown
+}
+
+func (brokenStore) PreferredCurrency(userID int) (string, error) {
+	return "", nil
+}
+
+func (brokenStore) HoldingCosts(userID int) (map[string]float64, error) {
+	return nil, errStoreDown
+}
+
+func TestHandlersReportStoreErrors(t *testing.T) {
+	_, mux := newTestServer(t, brokenStore{}, fakePrices{"BTC": 100})
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/portfolio", nil),
+		httptest.NewRequest(http.MethodGet, "/portfolio/value?user_id=1", nil),
+		httptest.NewRequest(http.MethodGet, "/portfolio/pnl?user_id=1", nil),
+		httptest.NewRequest(http.MethodPost, "/portfolio/add", bytes.NewBufferString(`{"user_id": 1, "symbol": "BTC", "amount": 1}`)),
+		httptest.NewRequest(http.MethodDelete, "/portfolio/remove", bytes.NewBufferString(`{"id": 1, "user_id": 1}`)),
+	} {
+		req.Header.Set("Content-Type", "application/json")
+		rec := serve(mux, req)
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("%s %s: status = %d, want 500", req.Method, req.URL, rec.Code)
+		}
+		// The cause is logged, not shown to the client
+		if strings.Contains(rec.Body.String(), errStoreDown.Error()) {
+			t.Errorf("%s %s: body %q leaks the error", req.Method, req.URL, rec.Body)
+		}
+	}
+}
+
+func TestPortfolioPages(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{})
+	rows := make([]Portfolio, 250)
+	for i := range rows {
+		rows[i] = Portfolio{UserID: 1, Symbol: fmt.Sprintf("C%d", i+1), Amount: 1}
+	}
+	addHoldings(t, store, rows...)
+
+	for _, c := range []struct {
+		query       string
+		first, last int // IDs of the first and last holding on the page
+		count       int
+	}{
+		{"", 1, 100, 100},
+		{"?limit=100&offset=100", 101, 200, 100},
+		{"?limit=100&offset=200", 201, 250, 50},
+		{"?limit=7&offset=249", 250, 250, 1},
+		{"?limit=5000", 1, 250, 250}, // Capped at maxPageLimit, which is more than there are
+	} {
+		rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio"+c.query, nil))
+		var page portfolioPage
+		if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 250 || len(page.Items) != c.count ||
+			page.Items[0].ID != c.first || page.Items[len(page.Items)-1].ID != c.last {
+			t.Errorf("/portfolio%s: got %d items of %d, want %d from %d to %d", c.query, len(page.Items), page.Total, c.count, c.first, c.last)
+		}
+	}
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio?offset=300", nil))
+	var page portfolioPage
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 0 || page.Total != 250 {
+		t.Errorf("past the end: got %d items of %d, want none of 250", len(page.Items), page.Total)
+	}
+}
+
+func TestPortfolioRejectsBadPageParams(t *testing.T) {
+	_, mux := newTestServer(t, &fakeStore{}, fakePrices{})
+	for _, query := range []string{"limit=-1", "limit=0", "limit=ten", "offset=-5", "offset=1.5"} {
+		rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestPortfolioValueFromFakeStore(t *testing.T) {
+	store := &fakeStore{rows: []Portfolio{
+		{ID: 1, UserID: 1, Symbol: "BTC", Amount: 2},
+		{ID: 2, UserID: 1, Symbol: "ETH", Amount: 10},
+		{ID: 3, UserID: 2, Symbol: "BTC", Amount: 5},
+	}}
+	_, mux := newTestServer(t, store, fakePrices{"BTC": 100, "ETH": 10})
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/value?user_id=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		TotalValue float64 `json:"total_value"`
+		Currency   string  `json:"currency"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.TotalValue != 300 || got.Currency != "USD" {
+		t.Errorf("got total %v %s, want 300 USD", got.TotalValue, got.Currency)
+	}
+}
+
+func TestPortfolioValueBreakdownLargestFirst(t *testing.T) {
+	store := &fakeStore{rows: []Portfolio{
+		{ID: 1, UserID: 1, Symbol: "BTC", Amount: 2},
+		{ID: 2, UserID: 1, Symbol: "ETH", Amount: 30},
+		{ID: 3, UserID: 1, Symbol: "BTC", Amount: 1},
+	}}
+	_, mux := newTestServer(t, store, fakePrices{"BTC": 100, "ETH": 20})
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/value?user_id=1", nil))
+	type holding struct {
+		Symbol string  `json:"symbol"`
+		Amount float64 `json:"amount"`
+		Price  float64 `json:"price"`
+		Value  float64 `json:"value"`
+	}
+	var got struct {
+		TotalValue float64   `json:"total_value"`
+		Holdings   []holding `json:"holdings"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []holding{{"ETH", 30, 20, 600}, {"BTC", 3, 100, 300}}
+	if got.TotalValue != 900 || fmt.Sprint(got.Holdings) != fmt.Sprint(want) {
+		t.Errorf("got total %v, holdings %+v; want 900 and %+v", got.TotalValue, got.Holdings, want)
+	}
+}
+
+func TestPortfolioValueAllUnpriced(t *testing.T) {
+	store := &fakeStore{rows: []Portfolio{{ID: 1, UserID: 1, Symbol: "NOPE", Amount: 1}}}
+	_, mux := newTestServer(t, store, fakePrices{})
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/value?user_id=1", nil))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestPortfolioValueAllUnpricedBesidesSoldHoldings(t *testing.T) {
+	store := &fakeStore{rows: []Portfolio{
+		{ID: 1, UserID: 1, Symbol: "NOPE", Amount: 1},
+		{ID: 2, UserID: 1, Symbol: "BTC", Amount: 0},
+	}}
+	_, mux := newTestServer(t, store, fakePrices{"BTC": 100})
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/value?user_id=1", nil))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestAddToPortfolioUsesCurrentPriceAsCost(t *testing.T) {
+	store := &fakeStore{}
+	_, mux := newTestServer(t, store, fakePrices{"BTC": 100})
+
+	body := bytes.NewBufferString(`{"user_id": 1, "symbol": "btc", "amount": 2}`)
+	req := httptest.NewRequest(http.MethodPost, "/portfolio/add", body)
+	req.Header.Set("Content-Type", "application/json")
+	rec := serve(mux, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if len(store.rows) != 1 {
+		t.Fatalf("stored %d rows, want 1", len(store.rows))
+	}
+	p := store.rows[0]
+	if p.Symbol != "BTC" || p.Amount != 2 || p.CostBasis == nil || *p.CostBasis != 100 {
+		t.Errorf("stored %+v, want 2 BTC at cost 100", p)
+	}
+}
+
+func TestAddToPortfolioValidation(t *testing.T) {
+	store := &fakeStore{}
+	_, mux := newTestServer(t, store, fakePrices{"BTC": 100})
+
+	cases := []struct {
+		body, field string
+	}{
+		{`{"user_id": 1, "symbol": "BTC", "amount": -1}`, "amount"},
+		{`{"user_id": 1, "symbol": "BTC", "amount": 0}`, "amount"},
+		{`{"user_id": 1, "symbol": "  ", "amount": 1}`, "symbol"},
+		{`{"user_id": 1, "amount": 1}`, "symbol"},
+		{`{"symbol": "BTC", "amount": 1}`, "user_id"},
+		{`{"user_id": -3, "symbol": "BTC", "amount": 1}`, "user_id"},
+		{`{"user_id": 1, "symbol": "BTC", "amount": 1, "cost_basis": -5}`, "cost_basis"},
+	}
+	for _, c := range cases {
+		rec := postJSON(mux, "/portfolio/add", c.body)
+		var got struct {
+			Errors validationErrors `json:"errors"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("%s: %v", c.body, err)
+		}
+		if rec.Code != http.StatusBadRequest || len(got.Errors) != 1 || got.Errors[0].Field != c.field {
+			t.Errorf("%s: status %d, errors %+v, want one on %s", c.body, rec.Code, got.Errors, c.field)
+		}
+	}
+
+	for _, body := range []string{`{"user_id": 1, "symbol": "BTC", "amount": 1, "amout": 2}`, `{"user_id": 1,`} {
+		if rec := postJSON(mux, "/portfolio/add", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+	if len(store.rows) != 0 {
+		t.Errorf("invalid requests stored %+v", store.rows)
+	}
+
+	rec := postJSON(mux, "/portfolio/add", `{"user_id": 1, "symbol": " eth ", "amount": 1.5, "cost_basis": 2000}`)
+	if rec.Code != http.StatusCreated || len(store.rows) != 1 {
+		t.Fatalf("valid request: status %d, stored %d rows", rec.Code, len(store.rows))
+	}
+	if p := store.rows[0]; p.UserID != 1 || p.Symbol != "ETH" || p.Amount != 1.5 || p.CostBasis == nil || *p.CostBasis != 2000 {
+		t.Errorf("stored %+v, want 1.5 ETH at 2000 for user 1", p)
+	}
+}
+
+func TestRemoveFromPortfolioNotFound(t *testing.T) {
+	store := &fakeStore{rows: []Portfolio{{ID: 1, UserID: 1, Symbol: "BTC", Amount: 1}}}
+	_, mux := newTestServer(t, store, fakePrices{})
+
+	if rec := postJSON(mux, "/portfolio/remove", `{"id": 1, "user_id": 2}`); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if len(store.rows) != 1 {
+		t.Error("another user's holding was removed")
+	}
+}
+
+func TestRemoveFromPortfolio(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{})
+	addHoldings(t, store, Portfolio{UserID: 1, Symbol: "BTC", Amount: 1}, Portfolio{UserID: 1, Symbol: "ETH", Amount: 2})
+
+	rec := postJSON(mux, "/portfolio/remove", `{"id": 1, "user_id": 1}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		Deleted int64 `json:"deleted"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Deleted != 1 {
+		t.Errorf("deleted = %d, want 1", got.Deleted)
+	}
+	rows, total, err := store.ListPortfolio(0, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || rows[0].Symbol != "ETH" {
+		t.Errorf("left %+v, want only ETH", rows)
+	}
+
+	if rec := postJSON(mux, "/portfolio/remove", `{"id": 1}`); rec.Code != http.StatusNotFound {
+		t.Errorf("removing it again: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestRemoveFromPortfolioRejectsBadBodies(t *testing.T) {
+	_, mux := newTestServer(t, &fakeStore{}, fakePrices{})
+	for _, body := range []string{`{"id": `, `{"id": "one"}`, `{}`} {
+		if rec := postJSON(mux, "/portfolio/remove", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// putJSON sends body to path on handler as a JSON PUT
+func putJSON(handler http.Handler, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	return serve(handler, req)
+}
+
+func TestUpdatePortfolioSetsAmountAndUpdatedAt(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{})
+	addHoldings(t, store, Portfolio{UserID: 1, Symbol: "BTC", Amount: 1})
+	rows, _, err := store.ListPortfolio(0, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[0].UpdatedAt.Valid {
+		t.Fatalf("new holding already has updated_at %v", rows[0].UpdatedAt.Time)
+	}
+
+	rec := putJSON(mux, "/portfolio/update", `{"id": 1, "symbol": "btc", "amount": 2.5}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	rows, _, err = store.ListPortfolio(0, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := rows[0]; p.Amount != 2.5 || p.Symbol != "BTC" || !p.UpdatedAt.Valid {
+		t.Errorf("after update: %+v, want 2.5 BTC with updated_at set", p)
+	}
+}
+
+func TestUpdatePortfolioNotFound(t *testing.T) {
+	_, mux := newTestServer(t, newSQLStore(t), fakePrices{})
+	if rec := putJSON(mux, "/portfolio/update", `{"id": 7, "symbol": "BTC", "amount": 1}`); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestPortfolioPnLFromFakeStore(t *testing.T) {
+	store := &fakeStore{
+		rows:  []Portfolio{{ID: 1, UserID: 1, Symbol: "BTC", Amount: 2}},
+		costs: map[string]float64{"BTC": 50},
+	}
+	_, mux := newTestServer(t, store, fakePrices{"BTC": 100})
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/pnl?user_id=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		TotalCostBasis float64 `json:"total_cost_basis"`
+		TotalGain      float64 `json:"total_gain"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.TotalCostBasis != 100 || got.TotalGain != 100 {
+		t.Errorf("got cost %v gain %v, want 100 and 100", got.TotalCostBasis, got.TotalGain)
+	}
+}
+
+func TestPortfolioPnLPerHoldingWithUnknownCost(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{"BTC": 300, "ETH": 10, "SOL": 5})
+	cost := func(c float64) *float64 { return &c }
+	addHoldings(t, store,
+		Portfolio{UserID: 1, Symbol: "BTC", Amount: 2, CostBasis: cost(100)},
+		Portfolio{UserID: 1, Symbol: "BTC", Amount: 2, CostBasis: cost(200)},
+		Portfolio{UserID: 1, Symbol: "SOL", Amount: 4, CostBasis: cost(10)},
+		Portfolio{UserID: 1, Symbol: "ETH", Amount: 1}, // Added before cost basis was recorded
+	)
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/pnl?user_id=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		Holdings       []pnlEntry `json:"holdings"`
+		TotalCostBasis float64    `json:"total_cost_basis"`
+		TotalValue     float64    `json:"total_value"`
+		TotalGain      float64    `json:"total_gain"`
+		GainPercent    *float64   `json:"gain_percent"`
+		NoCostBasis    []string   `json:"no_cost_basis"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	bySymbol := make(map[string]pnlEntry)
+	for _, e := range got.Holdings {
+		bySymbol[e.Symbol] = e
+	}
+	btc, sol := bySymbol["BTC"], bySymbol["SOL"]
+	if len(got.Holdings) != 2 || btc.AverageCost != 150 || btc.Value != 1200 || btc.Gain != 600 || sol.Gain != -20 {
+		t.Errorf("holdings = %+v, want BTC up 600 on an average cost of 150 and SOL down 20", got.Holdings)
+	}
+	if got.TotalCostBasis != 640 || got.TotalValue != 1220 || got.TotalGain != 580 || got.GainPercent == nil || *got.GainPercent != 90.63 {
+		t.Errorf("totals = cost %v, value %v, gain %v (%v%%); want 640, 1220, 580 (90.63%%)",
+			got.TotalCostBasis, got.TotalValue, got.TotalGain, got.GainPercent)
+	}
+	if len(got.NoCostBasis) != 1 || got.NoCostBasis[0] != "ETH" {
+		t.Errorf("no_cost_basis = %v, want [ETH]", got.NoCostBasis)
+	}
+}
+
+func TestHealthReportsStoreFailure(t *testing.T) {
+	coinCap := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer coinCap.Close()
+	store := &fakeStore{pingErr: errors.New("disk on fire")}
+	_, mux := newTestServer(t, store, fakePrices{})
+	cfg.APIBaseURL = coinCap.URL
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	var report healthReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.DB != "error: disk on fire" || report.API != "ok" {
+		t.Errorf("report = %+v", report)
+	}
+}
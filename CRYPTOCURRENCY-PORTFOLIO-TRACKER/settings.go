@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// UserSettings holds per-user preferences
+type UserSettings struct {
+	UserID            int    `json:"user_id"`
+	PreferredCurrency string `json:"preferred_currency"`
+}
+
+func (s *SQLStore) PreferredCurrency(userID int) (string, error) {
+	var currency string
+	err := s.db.QueryRow("SELECT preferred_currency FROM user_settings WHERE user_id = ?", userID).Scan(&currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return currency, err
+}
+
+// handleUserSettings gets (GET ?user_id=) or saves (POST) a user's
+// settings. A logged-in user only ever gets and saves their own.
+func (s *Server) handleUserSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userID, ok := optionalUserID(w, r)
+		if !ok {
+			return
+		}
+		if userID, ok = scopeUserID(w, r, userID); !ok {
+			return
+		}
+		if userID == 0 {
+			http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+			return
+		}
+		currency, err := s.store.PreferredCurrency(userID)
+		if err != nil {
+			serverError(w, r, "Error fetching user settings", err)
+			return
+		}
+		if currency == "" {
+			currency = cfg.currency()
+		}
+		writeUserSettings(w, UserSettings{UserID: userID, PreferredCurrency: currency})
+
+	case http.MethodPost, http.MethodPut:
+		var settings UserSettings
+		err := json.NewDecoder(r.Body).Decode(&settings)
+		if err != nil {
+			http.Error(w, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+		var ok bool
+		if settings.UserID, ok = scopeUserID(w, r, settings.UserID); !ok {
+			return
+		}
+		if settings.UserID <= 0 {
+			http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+			return
+		}
+		settings.PreferredCurrency = strings.ToUpper(settings.PreferredCurrency)
+		if _, err := getCoinCapRate(r.Context(), settings.PreferredCurrency); err != nil && !errors.Is(err, errDenominationUnavailable) {
+			if errors.Is(err, errUnknownCurrency) {
+				http.Error(w, "Unsupported currency", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Error fetching currency rates", http.StatusInternalServerError)
+			return
+		}
+
+		if err := s.store.SetPreferredCurrency(settings.UserID, settings.PreferredCurrency); err != nil {
+			serverError(w, r, "Error saving user settings", err)
+			return
+		}
+		writeUserSettings(w, settings)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *SQLStore) SetPreferredCurrency(userID int, currency string) error {
+	_, err := execWriteOn(s.db,
+		"INSERT INTO user_settings (user_id, preferred_currency) VALUES (?, ?) ON CONFLICT(user_id) DO UPDATE SET preferred_currency = excluded.preferred_currency",
+		userID, currency,
+	)
+	return err
+}
+
+func writeUserSettings(w http.ResponseWriter, settings UserSettings) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(settings)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
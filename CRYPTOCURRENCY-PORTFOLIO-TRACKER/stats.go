@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultSharpeDays = 30
+	hoursPerYear      = 365 * 24 // Crypto trades every day of the year
+)
+
+func (s *SQLStore) ValueHistory(from time.Time) ([]valueSnapshot, error) {
+	rows, err := s.db.Query(
+		"SELECT total_value, recorded_at FROM value_history WHERE recorded_at >= ? ORDER BY recorded_at",
+		from.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []valueSnapshot
+	for rows.Next() {
+		var v valueSnapshot
+		if err := rows.Scan(&v.TotalValue, &v.RecordedAt); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, v)
+	}
+	return snapshots, rows.Err()
+}
+
+// sharpeRatio computes a simplified, annualized Sharpe ratio from value
+// snapshots. The assumptions are deliberately simple:
+//
+//   - Each return is the change between consecutive snapshots, so holdings
+//     added or removed in between count as gains or losses.
+//   - Snapshots are treated as evenly spaced at their average interval,
+//     which is also used to scale the risk-free rate to one period.
+//   - The ratio is annualized by the square root of periods per year,
+//     assuming independent returns and a market open all year round.
+//
+// It returns the number of returns used, and ok=false with fewer than two
+// of them or no volatility at all.
+func sharpeRatio(snapshots []valueSnapshot, riskFreePercent float64) (ratio float64, returns int, ok bool) {
+	var rs []float64
+	for i := 1; i < len(snapshots); i++ {
+		if prev := snapshots[i-1].TotalValue; prev > 0 {
+			rs = append(rs, snapshots[i].TotalValue/prev-1)
+		}
+	}
+	if len(rs) < 2 {
+		return 0, len(rs), false
+	}
+
+	span := snapshots[len(snapshots)-1].RecordedAt.Sub(snapshots[0].RecordedAt)
+	periodHours := span.Hours() / float64(len(snapshots)-1)
+	if periodHours <= 0 {
+		return 0, len(rs), false
+	}
+	periodsPerYear := hoursPerYear / periodHours
+	riskFree := riskFreePercent / 100 / periodsPerYear
+
+	var mean float64
+	for _, r := range rs {
+		mean += r - riskFree
+	}
+	mean /= float64(len(rs))
+	var variance float64
+	for _, r := range rs {
+		d := r - riskFree - mean
+		variance += d * d
+	}
+	stddev := math.Sqrt(variance / float64(len(rs)-1))
+	if stddev == 0 {
+		return 0, len(rs), false
+	}
+	return mean / stddev * math.Sqrt(periodsPerYear), len(rs), true
+}
+
+// handleSharpe returns a Sharpe-like ratio over the last ?days of value
+// history, against an annual ?risk_free rate in percent (default from config)
+func (s *Server) handleSharpe(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	days := defaultSharpeDays
+	if value := query.Get("days"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days <= 0 {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+	}
+	riskFree := cfg.RiskFreeRatePercent
+	if value := query.Get("risk_free"); value != "" {
+		var err error
+		riskFree, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			http.Error(w, "Invalid risk_free", http.StatusBadRequest)
+			return
+		}
+	}
+
+	from := time.Now().AddDate(0, 0, -days)
+	snapshots, err := s.store.ValueHistory(from)
+	if err != nil {
+		serverError(w, r, "Error fetching value history", err)
+		return
+	}
+	ratio, returns, ok := sharpeRatio(snapshots, riskFree)
+
+	response := struct {
+		Days                int      `json:"days"`
+		RiskFreeRatePercent float64  `json:"risk_free_rate_percent"`
+		Snapshots           int      `json:"snapshots"`
+		Returns             int      `json:"returns"`
+		Sharpe              *float64 `json:"sharpe"` // Null without enough history or volatility
+	}{
+		Days:                days,
+		RiskFreeRatePercent: riskFree,
+		Snapshots:           len(snapshots),
+		Returns:             returns,
+	}
+	if ok {
+		response.Sharpe = &ratio
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
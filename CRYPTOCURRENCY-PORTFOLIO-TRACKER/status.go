@@ -0,0 +1,264 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// pauseState is the runtime pause switch shared by all monitors
+type pauseState struct {
+	Paused        bool       `json:"paused"`         // Alerts are suppressed
+	PollingPaused bool       `json:"polling_paused"` // Prices aren't fetched either
+	Since         *time.Time `json:"since,omitempty"`
+}
+
+// tokenStatus is the last thing a monitor observed for its token
+type tokenStatus struct {
+	Symbol      string     `json:"symbol"`
+	UserID      int        `json:"user_id,omitempty"` // The holder of a portfolio threshold
+	Name        string     `json:"name"`
+	LastPrice   float64    `json:"last_price,omitempty"`
+	LastChecked *time.Time `json:"last_checked,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	Triggered   bool       `json:"triggered"`
+
+	// ConsecutiveFailures counts fetches failed since the last success and
+	// drives the monitor's backoff
+	ConsecutiveFailures int `json:"consecutive_failures,omitempty"`
+
+	// Delisted is set once CoinCap has stopped listing the symbol
+	Delisted bool `json:"delisted,omitempty"`
+}
+
+var (
+	statusMu      sync.RWMutex
+	monitorPause  pauseState
+	tokenStatuses = make(map[string]tokenStatus)
+)
+
+// monitoringPaused reports whether alerts and polling are paused
+func monitoringPaused() (alerts, polling bool) {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+	return monitorPause.Paused, monitorPause.PollingPaused
+}
+
+// updateTokenStatus replaces the recorded status of a token
+func updateTokenStatus(st tokenStatus) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	tokenStatuses[monitorKey(st.Symbol, st.UserID)] = st
+}
+
+// removeTokenStatus forgets a monitor, by monitorKey, that has stopped
+func removeTokenStatus(key string) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	delete(tokenStatuses, key)
+}
+
+// maxRestoredPriceAge is how old a persisted price can be and still serve
+// as the previous poll for change alerts after a restart
+const maxRestoredPriceAge = 10 * time.Minute
+
+// persistedState is a monitor's state as saved to token_state
+type persistedState struct {
+	LastPrice float64
+	Triggered bool
+	CheckedAt *time.Time
+
+	// Threshold and Direction are the token's thresholdKey when saved
+	Threshold string
+	Direction string
+}
+
+// saveTokenState persists what the monitor last saw, so it can resume
+// after a restart. The threshold is stored to tell when the saved
+// triggered flag no longer applies.
+func saveTokenState(store Store, token tokenConfig, st tokenStatus) error {
+	threshold, direction := token.thresholdKey()
+	return store.SaveTokenState(monitorKey(token.Symbol, token.UserID), persistedState{
+		LastPrice: st.LastPrice,
+		Triggered: st.Triggered,
+		CheckedAt: st.LastChecked,
+		Threshold: threshold,
+		Direction: direction,
+	})
+}
+
+func (s *SQLStore) SaveTokenState(key string, ps persistedState) error {
+	_, err := execWriteOn(s.db, `
+		INSERT INTO token_state (symbol, last_price, triggered, threshold, direction, checked_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(symbol) DO UPDATE SET
+			last_price = excluded.last_price,
+			triggered = excluded.triggered,
+			threshold = excluded.threshold,
+			direction = excluded.direction,
+			checked_at = excluded.checked_at
+	`, key, ps.LastPrice, ps.Triggered, ps.Threshold, ps.Direction, ps.CheckedAt)
+	return err
+}
+
+// loadTokenState returns the persisted state of token, or false if there
+// is none or it was saved for a different threshold
+func loadTokenState(store Store, token tokenConfig) (persistedState, bool, error) {
+	ps, err := store.TokenState(monitorKey(token.Symbol, token.UserID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return persistedState{}, false, nil
+	}
+	if err != nil {
+		return persistedState{}, false, err
+	}
+	if threshold, direction := token.thresholdKey(); ps.Threshold != threshold || ps.Direction != direction || ps.CheckedAt == nil {
+		return persistedState{}, false, nil
+	}
+	return ps, true, nil
+}
+
+func (s *SQLStore) TokenState(key string) (persistedState, error) {
+	var ps persistedState
+	err := s.db.QueryRow(
+		"SELECT last_price, triggered, threshold, direction, checked_at FROM token_state WHERE symbol = ?",
+		key,
+	).Scan(&ps.LastPrice, &ps.Triggered, &ps.Threshold, &ps.Direction, &ps.CheckedAt)
+	return ps, err
+}
+
+// handlePauseMonitoring suppresses all alerts until resumed. With
+// ?polling=true the monitors also stop fetching prices.
+func handlePauseMonitoring(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	statusMu.Lock()
+	if !monitorPause.Paused {
+		now := time.Now().UTC()
+		monitorPause.Since = &now
+	}
+	monitorPause.Paused = true
+	monitorPause.PollingPaused = r.URL.Query().Get("polling") == "true"
+	state := monitorPause
+	statusMu.Unlock()
+
+	slog.Info("Monitoring paused", "component", "monitor", "polling_paused", state.PollingPaused)
+	writePauseState(w, state)
+}
+
+// handleResumeMonitoring re-enables alerts and polling
+func handleResumeMonitoring(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	statusMu.Lock()
+	monitorPause = pauseState{}
+	state := monitorPause
+	statusMu.Unlock()
+
+	slog.Info("Monitoring resumed", "component", "monitor")
+	writePauseState(w, state)
+}
+
+func writePauseState(w http.ResponseWriter, state pauseState) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(state)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleStatus reports the pause state and what each monitor last saw
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	statusMu.RLock()
+	response := struct {
+		Monitoring pauseState    `json:"monitoring"`
+		Tokens     []tokenStatus `json:"tokens"`
+	}{
+		Monitoring: monitorPause,
+		Tokens:     make([]tokenStatus, 0, len(tokenStatuses)),
+	}
+	for _, st := range tokenStatuses {
+		response.Tokens = append(response.Tokens, st)
+	}
+	statusMu.RUnlock()
+	sort.Slice(response.Tokens, func(i, j int) bool {
+		a, b := response.Tokens[i], response.Tokens[j]
+		return a.Symbol < b.Symbol || a.Symbol == b.Symbol && a.UserID < b.UserID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PriceSnapshot is the latest price a monitor fetched for its token
+type PriceSnapshot struct {
+	Symbol string    `json:"symbol"`
+	Price  float64   `json:"price"`
+	Time   time.Time `json:"time"`
+}
+
+// latestPrices returns the last fetched price of every monitored token,
+// keyed by symbol and taken from whichever monitor of it fetched last.
+// Tokens not fetched yet are left out.
+func latestPrices() map[string]PriceSnapshot {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+	prices := make(map[string]PriceSnapshot, len(tokenStatuses))
+	for _, st := range tokenStatuses {
+		if st.LastChecked == nil {
+			continue
+		}
+		if p, ok := prices[st.Symbol]; ok && !st.LastChecked.After(p.Time) {
+			continue
+		}
+		prices[st.Symbol] = PriceSnapshot{Symbol: st.Symbol, Price: st.LastPrice, Time: *st.LastChecked}
+	}
+	return prices
+}
+
+// handlePrices serves the latest monitored prices, or with ?symbol= the
+// price of one token
+func handlePrices(w http.ResponseWriter, r *http.Request) {
+	prices := latestPrices()
+
+	var response any
+	if symbol := r.URL.Query().Get("symbol"); symbol != "" {
+		snapshot, ok := prices[normalizeSymbol(symbol)]
+		if !ok {
+			http.Error(w, "No price for "+normalizeSymbol(symbol)+"; it is not monitored or not fetched yet", http.StatusNotFound)
+			return
+		}
+		response = snapshot
+	} else {
+		list := make([]PriceSnapshot, 0, len(prices))
+		for _, p := range prices {
+			list = append(list, p)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Symbol < list[j].Symbol
+		})
+		response = list
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Store is the persistence the HTTP handlers, monitors and jobs depend on
+type Store interface {
+	// ListPortfolio returns a page of holdings ordered by id and how many
+	// there are in all. A userID of 0 lists every user's.
+	ListPortfolio(userID, limit, offset int) ([]Portfolio, int, error)
+	// AddHolding inserts a new holding
+	AddHolding(p Portfolio) error
+	// ImportHoldings inserts rows for userID, all or none of them
+	ImportHoldings(userID int, rows []Portfolio) error
+	// UpdateHolding replaces the symbol and amount of holding p.ID, only if
+	// it belongs to p.UserID unless that is 0, and returns it as stored, or
+	// sql.ErrNoRows if there is no such holding
+	UpdateHolding(p Portfolio) (Portfolio, error)
+	// RemoveHolding deletes holding id, only if it belongs to userID unless
+	// that is 0, and returns how many rows were deleted
+	RemoveHolding(id, userID int) (int64, error)
+	// Holdings returns the amounts held by userID, or by everyone if 0
+	Holdings(userID int) (holdings, error)
+	// EachHolding calls fn with each of userID's holdings, or everyone's if
+	// 0, in id order as they are read, stopping at the first error
+	EachHolding(userID int, fn func(Portfolio) error) error
+
+	// PriceAt returns the last price of symbol recorded at or before at,
+	// or sql.ErrNoRows if there is none
+	PriceAt(symbol string, at time.Time) (pricePoint, error)
+
+	// CreateUser registers an account, or returns errUsernameTaken
+	CreateUser(username, passwordHash string) (user, error)
+	// UserByName returns the account, or sql.ErrNoRows if there is none
+	UserByName(username string) (user, error)
+	// CreateSession records a login until expiresAt
+	CreateSession(tokenHash string, userID int, expiresAt time.Time) error
+	// SessionUser returns who a live session belongs to, or sql.ErrNoRows
+	SessionUser(tokenHash string, now time.Time) (int, error)
+
+	// HoldingCosts returns the average cost basis per symbol recorded on
+	// the holdings, leaving out symbols with any row of unknown cost. A
+	// userID of 0 averages across all users.
+	HoldingCosts(userID int) (map[string]float64, error)
+	// Transactions returns userID's ledger, or everyone's if 0, in date order
+	Transactions(userID int) ([]Transaction, error)
+	// ApplyTransactions records txs in order in a single transaction,
+	// adjusting the holdings and setting each one's ID. One the user
+	// doesn't hold enough for is skipped, with errInsufficientHoldings at
+	// its index in the returned slice.
+	ApplyTransactions(txs []Transaction) ([]error, error)
+
+	// RecordPrice stores a price observed by a monitor
+	RecordPrice(symbol string, price float64, at time.Time) error
+	// PriceHistory returns the prices of symbol recorded between from and
+	// to, oldest first
+	PriceHistory(symbol string, from, to time.Time) ([]pricePoint, error)
+	// PrunePriceHistory deletes prices recorded before cutoff and returns
+	// how many there were
+	PrunePriceHistory(cutoff time.Time) (int64, error)
+
+	// RecordValue stores a snapshot of the total portfolio value
+	RecordValue(v valueSnapshot) error
+	// ValueHistory returns the snapshots recorded since from, oldest first
+	ValueHistory(from time.Time) ([]valueSnapshot, error)
+	// ValueBefore returns the latest snapshot at or before t, or
+	// sql.ErrNoRows if there is none
+	ValueBefore(t time.Time) (valueSnapshot, error)
+
+	// PreferredCurrency returns the user's saved currency, "" if none is set
+	PreferredCurrency(userID int) (string, error)
+	// SetPreferredCurrency saves the user's currency
+	SetPreferredCurrency(userID int, currency string) error
+
+	// TokenState returns a monitor's saved state by monitorKey, or
+	// sql.ErrNoRows
+	TokenState(key string) (persistedState, error)
+	// SaveTokenState replaces a monitor's saved state
+	SaveTokenState(key string, ps persistedState) error
+
+	// HoldingThresholds returns the user's thresholds, by symbol, or with
+	// a userID of 0 every user's
+	HoldingThresholds(userID int) ([]holdingThreshold, error)
+	// SetHoldingThreshold saves the user's threshold of symbol, removing it
+	// if zero
+	SetHoldingThreshold(userID int, symbol string, threshold decimal) error
+
+	// Ping checks that the database can be reached
+	Ping(ctx context.Context) error
+	// Backup writes a consistent copy of the database to path
+	Backup(path string) error
+}
+
+// SQLStore is the Store backed by the SQLite database
+type SQLStore struct {
+	db *sql.DB
+}
+
+// NewSQLStore returns a Store reading and writing handle
+func NewSQLStore(handle *sql.DB) *SQLStore {
+	return &SQLStore{db: handle}
+}
+
+// Columns are listed explicitly so scans keep working when the table gains
+// new ones
+const portfolioColumns = "id, user_id, symbol, amount, created_at, updated_at, cost_basis"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPortfolio(row rowScanner) (Portfolio, error) {
+	var p Portfolio
+	err := row.Scan(&p.ID, &p.UserID, &p.Symbol, &p.Amount, &p.CreatedAt, &p.UpdatedAt, &p.CostBasis)
+	return p, err
+}
+
+func (s *SQLStore) ListPortfolio(userID, limit, offset int) ([]Portfolio, int, error) {
+	where := ""
+	var args []any
+	if userID != 0 {
+		where = " WHERE user_id = ?"
+		args = append(args, userID)
+	}
+	var total int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM portfolio"+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query("SELECT "+portfolioColumns+" FROM portfolio"+where+" ORDER BY id LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	portfolio := []Portfolio{}
+	for rows.Next() {
+		p, err := scanPortfolio(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		portfolio = append(portfolio, p)
+	}
+	return portfolio, total, rows.Err()
+}
+
+func (s *SQLStore) EachHolding(userID int, fn func(Portfolio) error) error {
+	query := "SELECT " + portfolioColumns + " FROM portfolio"
+	var args []any
+	if userID != 0 {
+		query += " WHERE user_id = ?"
+		args = append(args, userID)
+	}
+	rows, err := s.db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p, err := scanPortfolio(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLStore) AddHolding(p Portfolio) error {
+	return withTxOn(s.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"INSERT INTO portfolio (user_id, symbol, amount, cost_basis) VALUES (?, ?, ?, ?)",
+			p.UserID, p.Symbol, p.Amount, p.CostBasis,
+		)
+		return err
+	})
+}
+
+func (s *SQLStore) UpdateHolding(p Portfolio) (Portfolio, error) {
+	// Read the row back in the same transaction so the result is exactly
+	// what this update wrote
+	var updated Portfolio
+	err := withTxOn(s.db, func(tx *sql.Tx) error {
+		query := "UPDATE portfolio SET symbol = ?, amount = ?, updated_at = ? WHERE id = ?"
+		args := []any{p.Symbol, p.Amount, time.Now().UTC(), p.ID}
+		if p.UserID != 0 {
+			query += " AND user_id = ?"
+			args = append(args, p.UserID)
+		}
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			if err == nil {
+				err = sql.ErrNoRows
+			}
+			return err
+		}
+		updated, err = scanPortfolio(tx.QueryRow("SELECT "+portfolioColumns+" FROM portfolio WHERE id = ?", p.ID))
+		return err
+	})
+	return updated, err
+}
+
+func (s *SQLStore) RemoveHolding(id, userID int) (int64, error) {
+	query := "DELETE FROM portfolio WHERE id = ?"
+	args := []any{id}
+	if userID != 0 {
+		query += " AND user_id = ?"
+		args = append(args, userID)
+	}
+	result, err := execWriteOn(s.db, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SQLStore) Holdings(userID int) (holdings, error) {
+	return queryHoldings(s.db, userID)
+}
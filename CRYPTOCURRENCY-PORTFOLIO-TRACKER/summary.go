@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dailySummaryConfig schedules the daily portfolio digest. It is disabled
+// unless Time is set.
+type dailySummaryConfig struct {
+	Time     string `json:"time"`     // Local time to send it, "15:04" format
+	Timezone string `json:"timezone"` // IANA zone, defaults to UTC
+}
+
+// schedule returns when the digest runs, or an error if misconfigured
+func (c dailySummaryConfig) schedule() (dailyAt, error) {
+	at, err := time.Parse("15:04", c.Time)
+	if err != nil {
+		return dailyAt{}, fmt.Errorf("invalid daily summary time %q", c.Time)
+	}
+	loc := time.UTC
+	if c.Timezone != "" {
+		if loc, err = time.LoadLocation(c.Timezone); err != nil {
+			return dailyAt{}, fmt.Errorf("invalid daily summary timezone %q: %v", c.Timezone, err)
+		}
+	}
+	return dailyAt{hour: at.Hour(), minute: at.Minute(), loc: loc}, nil
+}
+
+// sendDailySummary is the scheduled job notifying the total portfolio
+// value, its change over the last day and the best and worst holdings by
+// unrealized gain
+func (s *Server) sendDailySummary(ctx context.Context) error {
+	h, err := s.store.Holdings(0)
+	if err != nil {
+		return err
+	}
+	costs, err := s.store.HoldingCosts(0)
+	if err != nil {
+		return err
+	}
+	v := s.prices.Value(ctx, h.bySymbol)
+
+	parts := []string{fmt.Sprintf("Daily summary: portfolio value $%.2f", v.TotalValue)}
+	previous, err := s.store.ValueBefore(time.Now().Add(-24 * time.Hour))
+	switch {
+	case err == nil && previous.TotalValue > 0:
+		change := v.TotalValue - previous.TotalValue
+		parts = append(parts, fmt.Sprintf("%+.2f (%+.2f%%) over the day", change, change/previous.TotalValue*100))
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+
+	entries, _ := unrealizedPnL(h.bySymbol, v, costs)
+	if len(entries) > 0 {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].gainRatio > entries[j].gainRatio })
+		parts = append(parts,
+			"best "+formatPerformer(entries[0]),
+			"worst "+formatPerformer(entries[len(entries)-1]))
+	}
+	if len(v.FailedSymbols) > 0 {
+		parts = append(parts, "unpriced: "+strings.Join(v.FailedSymbols, ", "))
+	}
+
+	notify(strings.Join(parts, "; "))
+	return nil
+}
+
+// formatPerformer describes a holding's gain for the summary
+func formatPerformer(e pnlEntry) string {
+	if e.GainPercent == nil {
+		return fmt.Sprintf("%s (no cost)", e.Symbol)
+	}
+	return fmt.Sprintf("%s (%+.2f%%)", e.Symbol, *e.GainPercent)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDailySummaryReportsValueChangeAndPerformers(t *testing.T) {
+	store := newSQLStore(t)
+	s, _ := newTestServer(t, store, fakePrices{"BTC": 150, "ETH": 5})
+	n := &recordingNotifier{}
+	q := useNotifier(t, n, 10)
+
+	now := time.Now().UTC()
+	applyAll(t, store,
+		Transaction{UserID: 1, Type: txBuy, Symbol: "BTC", Amount: 1, Price: 100, OccurredAt: now},
+		Transaction{UserID: 1, Type: txBuy, Symbol: "ETH", Amount: 10, Price: 10, OccurredAt: now},
+	)
+	if err := store.RecordValue(valueSnapshot{TotalValue: 160, RecordedAt: now.Add(-25 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.sendDailySummary(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	q.close(context.Background())
+
+	want := "Daily summary: portfolio value $200.00; +40.00 (+25.00%) over the day; best BTC (+50.00%); worst ETH (-50.00%)"
+	if got := n.sent(); len(got) != 1 || got[0] != want {
+		t.Errorf("sent %q, want [%q]", got, want)
+	}
+}
+
+func TestDailySummaryWithoutHistory(t *testing.T) {
+	s, _ := newTestServer(t, newSQLStore(t), fakePrices{})
+	n := &recordingNotifier{}
+	q := useNotifier(t, n, 10)
+
+	if err := s.sendDailySummary(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	q.close(context.Background())
+
+	want := "Daily summary: portfolio value $0.00"
+	if got := n.sent(); len(got) != 1 || got[0] != want {
+		t.Errorf("sent %q, want [%q]", got, want)
+	}
+}
+
+func TestDailySummarySchedule(t *testing.T) {
+	at, err := dailySummaryConfig{Time: "07:30", Timezone: "Asia/Tokyo"}.schedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 23:00 UTC is already 08:00 the next day in Tokyo
+	from := time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)
+	want := time.Date(2026, 3, 2, 22, 30, 0, 0, time.UTC)
+	if got := at.next(from); !got.Equal(want) {
+		t.Errorf("next = %v, want %v", got, want)
+	}
+
+	for _, c := range []dailySummaryConfig{{Time: "7pm"}, {Time: "07:30", Timezone: "Nowhere/City"}} {
+		if _, err := c.schedule(); err == nil {
+			t.Errorf("%+v: accepted", c)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// checkSymbols compares the configured tokens with the CoinCap asset list.
+// It returns the symbols CoinCap doesn't list, and for symbols several
+// assets share, the IDs of those assets in CoinCap's rank order.
+func checkSymbols(tokens []tokenConfig, assets coinCapAsset) (unknown []string, shared map[string][]string) {
+	ids := make(map[string][]string)
+	for _, asset := range assets.Data {
+		ids[asset.Symbol] = append(ids[asset.Symbol], asset.ID)
+	}
+	shared = make(map[string][]string)
+	for _, token := range tokens {
+		switch matches := ids[token.Symbol]; {
+		case len(matches) == 0:
+			unknown = append(unknown, token.Symbol)
+		case len(matches) > 1:
+			shared[token.Symbol] = matches
+		}
+	}
+	return unknown, shared
+}
+
+// validateSymbols warns about configured symbols CoinCap doesn't list, or
+// fails when strict_symbols is set. Symbols shared by several assets are
+// priced from the highest ranked one, which is logged so a mismatch is
+// noticed. An unreachable CoinCap only skips the check.
+func validateSymbols(ctx context.Context, c *config) error {
+	assets, err := getCoinCapAssets(ctx)
+	if err != nil {
+		slog.Warn("Could not validate symbols against CoinCap", "component", "config", "error", err)
+		return nil
+	}
+
+	unknown, shared := checkSymbols(c.Tokens, assets)
+	symbols := make([]string, 0, len(shared))
+	for symbol := range shared {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		ids := shared[symbol]
+		slog.Warn("Symbol is shared by several CoinCap assets", "component", "config", "symbol", symbol, "assets", ids, "using", ids[0])
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	if c.StrictSymbols {
+		return fmt.Errorf("symbols not listed by CoinCap: %s", strings.Join(unknown, ", "))
+	}
+	slog.Warn("Symbols not listed by CoinCap, check the config for typos", "component", "config", "symbols", unknown)
+	return nil
+}
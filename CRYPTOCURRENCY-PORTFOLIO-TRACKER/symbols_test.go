@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckSymbols(t *testing.T) {
+	var assets coinCapAsset
+	err := json.Unmarshal([]byte(`{"data": [
+		{"id": "bitcoin", "symbol": "BTC"},
+		{"id": "uniswap", "symbol": "UNI"},
+		{"id": "unicorn-token", "symbol": "UNI"}
+	]}`), &assets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := []tokenConfig{{Symbol: "BTC"}, {Symbol: "BTCC"}, {Symbol: "UNI"}}
+
+	unknown, shared := checkSymbols(tokens, assets)
+	if !reflect.DeepEqual(unknown, []string{"BTCC"}) {
+		t.Errorf("unknown = %v, want [BTCC]", unknown)
+	}
+	// The first listed, highest ranked asset is the one priced
+	if want := map[string][]string{"UNI": {"uniswap", "unicorn-token"}}; !reflect.DeepEqual(shared, want) {
+		t.Errorf("shared = %v, want %v", shared, want)
+	}
+}
+
+func TestValidateSymbols(t *testing.T) {
+	useConfig(t, &config{})
+	newCoinCapStub(t, map[string]string{"BTC": "65000"})
+
+	valid := &config{Tokens: []tokenConfig{{Symbol: "BTC"}}, StrictSymbols: true}
+	if err := validateSymbols(context.Background(), valid); err != nil {
+		t.Errorf("listed symbol: %v", err)
+	}
+	typo := &config{Tokens: []tokenConfig{{Symbol: "BTC"}, {Symbol: "BTCC"}}}
+	if err := validateSymbols(context.Background(), typo); err != nil {
+		t.Errorf("unknown symbol without strict_symbols: %v", err)
+	}
+	typo.StrictSymbols = true
+	if err := validateSymbols(context.Background(), typo); err == nil || !strings.Contains(err.Error(), "BTCC") {
+		t.Errorf("unknown symbol with strict_symbols: err = %v, want one naming BTCC", err)
+	}
+}
+
+func TestValidateSymbolsSkipsUnreachableCoinCap(t *testing.T) {
+	useConfig(t, &config{})
+	newCoinCapStub(t, nil).Close()
+
+	c := &config{Tokens: []tokenConfig{{Symbol: "BTCC"}}, StrictSymbols: true}
+	if err := validateSymbols(context.Background(), c); err != nil {
+		t.Errorf("err = %v, want the check skipped", err)
+	}
+}
+
+func TestMixedCaseSymbolsStillValue(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, providerPriceClient{})
+	// CoinCap's own symbols aren't always upper case
+	newCoinCapStub(t, map[string]string{"btc": "100", "Eth": "10"})
+	useProvider(t, CoinCapProvider{})
+
+	for _, body := range []string{
+		`{"user_id": 1, "symbol": "bTc", "amount": 1, "cost_basis": 1}`,
+		`{"user_id": 1, "symbol": "BTC", "amount": 2, "cost_basis": 1}`,
+		`{"user_id": 1, "symbol": "eth ", "amount": 5, "cost_basis": 1}`,
+	} {
+		if rec := postJSON(mux, "/portfolio/add", body); rec.Code != http.StatusCreated {
+			t.Fatalf("%s: status = %d, body %s", body, rec.Code, rec.Body)
+		}
+	}
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/value?user_id=1", nil))
+	var got struct {
+		TotalValue    float64  `json:"total_value"`
+		FailedSymbols []string `json:"failed_symbols"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || got.TotalValue != 350 || len(got.FailedSymbols) != 0 {
+		t.Errorf("status %d, got %+v; want 350 with nothing failed", rec.Code, got)
+	}
+}
+
+func TestLoadConfigNormalizesTokenSymbols(t *testing.T) {
+	loaded, err := loadConfig(writeConfig(t, `{"tokens": [{"name": "Bitcoin", "symbol": " btc", "threshold": 1}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Tokens[0].Symbol != "BTC" {
+		t.Errorf("symbol = %q, want BTC", loaded.Tokens[0].Symbol)
+	}
+	_, err = loadConfig(writeConfig(t, `{"tokens": [
+		{"name": "Bitcoin", "symbol": "BTC", "threshold": 1},
+		{"name": "Bitcoin again", "symbol": "btc", "threshold": 2}
+	]}`))
+	if err == nil {
+		t.Error("symbols differing only in case were both loaded")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// handleTargetPrice solves for the price symbol would need for the
+// portfolio to be worth target, holding every other price constant
+func (s *Server) handleTargetPrice(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	symbol := normalizeSymbol(query.Get("symbol"))
+	if symbol == "" {
+		http.Error(w, "Missing symbol", http.StatusBadRequest)
+		return
+	}
+	target, err := strconv.ParseFloat(query.Get("target"), 64)
+	if err != nil || target <= 0 {
+		http.Error(w, "Invalid target", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := optionalUserID(w, r)
+	if !ok {
+		return
+	}
+	if userID, ok = scopeUserID(w, r, userID); !ok {
+		return
+	}
+	currency, rate, ok := s.requestRate(w, r, userID)
+	if !ok {
+		return
+	}
+	h, err := s.store.Holdings(userID)
+	if err != nil {
+		serverError(w, r, "Error fetching portfolio data", err)
+		return
+	}
+	amount := h.bySymbol[symbol]
+	if amount <= 0 {
+		http.Error(w, "Symbol not held", http.StatusBadRequest)
+		return
+	}
+
+	// Every price is needed: an unpriced holding would make the answer wrong
+	v := s.prices.Value(r.Context(), h.bySymbol)
+	if _, priced := v.Prices[symbol]; !priced || len(v.FailedSymbols) > 0 {
+		unpriced := v.FailedSymbols
+		if !priced && !slices.Contains(unpriced, symbol) {
+			unpriced = append(unpriced, symbol)
+		}
+		writePriceError(w, v, unpriced)
+		return
+	}
+	rest := v.TotalValue - v.Values[symbol]
+	required := (target*rate - rest) / amount
+	reached := required <= v.Prices[symbol]
+	if required < 0 {
+		// The other holdings alone are worth more than the target
+		required = 0
+	}
+	var change float64
+	if price := v.Prices[symbol]; price > 0 {
+		change = (required/price - 1) * 100
+	}
+
+	response := struct {
+		Symbol        string  `json:"symbol"`
+		Amount        float64 `json:"amount"`
+		Currency      string  `json:"currency"`
+		TargetValue   float64 `json:"target_value"`
+		CurrentValue  float64 `json:"current_value"`
+		CurrentPrice  float64 `json:"current_price"`
+		RequiredPrice float64 `json:"required_price"`
+		ChangePercent float64 `json:"change_percent"` // Move from the current price
+		Reached       bool    `json:"reached"`
+	}{
+		Symbol:        symbol,
+		Amount:        amount,
+		Currency:      currency,
+		TargetValue:   target,
+		CurrentValue:  v.TotalValue / rate,
+		CurrentPrice:  v.Prices[symbol] / rate,
+		RequiredPrice: required / rate,
+		ChangePercent: change,
+		Reached:       reached,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
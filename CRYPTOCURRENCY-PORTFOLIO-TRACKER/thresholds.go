@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+)
+
+// thresholdUpdate is the new alert setting for one token: either threshold
+// and direction, or upper_threshold and/or lower_threshold
+type thresholdUpdate struct {
+	Threshold      *decimal `json:"threshold"`
+	Direction      string   `json:"direction"`
+	UpperThreshold *decimal `json:"upper_threshold"`
+	LowerThreshold *decimal `json:"lower_threshold"`
+}
+
+// handleBulkThresholds updates the alert settings of several tokens at once
+// from a map of symbol to threshold and direction, or to upper and lower
+// thresholds, then restarts the affected monitors. Whichever form is given
+// replaces the other. Symbols not yet watched are added to the watchlist.
+// Updates are all-or-nothing: any invalid entry rejects the whole request.
+func (s *Server) handleBulkThresholds(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if cfg.MonitorPortfolio {
+		http.Error(w, "Watchlist follows the portfolio; use /portfolio/thresholds", http.StatusConflict)
+		return
+	}
+
+	var updates map[string]thresholdUpdate
+	err := json.NewDecoder(r.Body).Decode(&updates)
+	if err != nil {
+		http.Error(w, "Error parsing request body", http.StatusBadRequest)
+		return
+	}
+	if len(updates) == 0 {
+		http.Error(w, "No thresholds given", http.StatusBadRequest)
+		return
+	}
+
+	symbols := make([]string, 0, len(updates))
+	for symbol := range updates {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	cfgMu.Lock()
+	tokens := append([]tokenConfig(nil), cfg.Tokens...)
+	index := make(map[string]int, len(tokens))
+	for i, token := range tokens {
+		index[token.Symbol] = i
+	}
+
+	var errs validationErrors
+	for _, raw := range symbols {
+		update := updates[raw]
+		symbol := normalizeSymbol(raw)
+		if symbol == "" {
+			errs.add(raw, "symbol is required")
+			continue
+		}
+		i, exists := index[symbol]
+		if !exists {
+			tokens = append(tokens, tokenConfig{Name: symbol, Symbol: symbol})
+			i = len(tokens) - 1
+			index[symbol] = i
+		}
+		token := tokens[i]
+
+		ranged := update.UpperThreshold != nil || update.LowerThreshold != nil
+		switch {
+		case update.Threshold == nil && !ranged:
+			errs.add(raw+".threshold", "is required unless upper_threshold or lower_threshold is set")
+			continue
+		case update.Threshold != nil && ranged:
+			errs.add(raw+".threshold", "cannot be combined with upper_threshold or lower_threshold")
+			continue
+		case ranged && update.Direction != "":
+			errs.add(raw+".direction", "only applies to threshold")
+			continue
+		}
+		switch update.Direction {
+		case "", directionAbove, directionBelow:
+		default:
+			errs.add(raw+".direction", fmt.Sprintf("invalid direction %q", update.Direction))
+			continue
+		}
+
+		valid := true
+		for _, f := range []struct {
+			name  string
+			value *decimal
+		}{
+			{"threshold", update.Threshold},
+			{"upper_threshold", update.UpperThreshold},
+			{"lower_threshold", update.LowerThreshold},
+		} {
+			if f.value != nil && token.ThresholdMode != thresholdModeCostPercent && f.value.Sign() <= 0 {
+				errs.add(raw+"."+f.name, "must be greater than 0")
+				valid = false
+			}
+		}
+		if !valid {
+			continue
+		}
+		if upper, lower := update.UpperThreshold, update.LowerThreshold; upper != nil && lower != nil && lower.Cmp(*upper) >= 0 {
+			errs.add(raw+".lower_threshold", fmt.Sprintf("must be less than upper_threshold %s", upper))
+			continue
+		}
+
+		if ranged {
+			token.Threshold = decimal{}
+			token.Direction = ""
+			token.UpperThreshold = update.UpperThreshold
+			token.LowerThreshold = update.LowerThreshold
+		} else {
+			token.Threshold = *update.Threshold
+			token.UpperThreshold = nil
+			token.LowerThreshold = nil
+			if update.Direction != "" {
+				token.Direction = update.Direction
+			}
+		}
+		if err := validateToken(token); err != nil {
+			errs.add(raw, err.Error())
+			continue
+		}
+		tokens[i] = token
+	}
+	if limit := cfg.maxTokens(); limit > 0 && len(tokens) > limit {
+		errs.add("tokens", fmt.Sprintf("watchlist would have %d tokens, the maximum is %d", len(tokens), limit))
+	}
+	if len(errs) > 0 {
+		cfgMu.Unlock()
+		writeValidationErrors(w, errs)
+		return
+	}
+	cfg.Tokens = tokens
+	// Reconcile under the lock so concurrent updates apply in order
+	s.reconcileMonitors(appCtx, tokens)
+	cfgMu.Unlock()
+
+	slog.Info("Updated thresholds", "component", "config", "symbols", symbols)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(tokens)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
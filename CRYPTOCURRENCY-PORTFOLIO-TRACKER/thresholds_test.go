@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// postThresholds sends body to /config/thresholds
+func postThresholds(s *Server, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/config/thresholds", bytes.NewBufferString(body))
+	return serve(http.HandlerFunc(s.handleBulkThresholds), req)
+}
+
+func TestBulkThresholdsSwitchesModes(t *testing.T) {
+	s, _ := newTestServer(t, &fakeStore{}, fakePrices{})
+	stopMonitors(t)
+	cfg.Tokens = []tokenConfig{{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(60000)}}
+
+	rec := postThresholds(s, `{"BTC": {"upper_threshold": "70000", "lower_threshold": "50000"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	token := cfg.Tokens[0]
+	if !token.Threshold.IsZero() || token.UpperThreshold == nil || token.UpperThreshold.String() != "70000" ||
+		token.LowerThreshold == nil || token.LowerThreshold.String() != "50000" {
+		t.Errorf("after range update: %+v", token)
+	}
+
+	rec = postThresholds(s, `{"BTC": {"threshold": "40000", "direction": "below"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	token = cfg.Tokens[0]
+	if token.Threshold.String() != "40000" || token.Direction != directionBelow ||
+		token.UpperThreshold != nil || token.LowerThreshold != nil {
+		t.Errorf("after threshold update: %+v", token)
+	}
+}
+
+func TestBulkThresholdsReportsFieldErrors(t *testing.T) {
+	s, _ := newTestServer(t, &fakeStore{}, fakePrices{})
+	stopMonitors(t)
+	cfg.Tokens = []tokenConfig{{Name: "Bitcoin", Symbol: "BTC", Threshold: decimalFromFloat(60000)}}
+
+	cases := []struct {
+		body, field string
+	}{
+		{`{"BTC": {}}`, "BTC.threshold"},
+		{`{"BTC": {"threshold": "1", "upper_threshold": "2"}}`, "BTC.threshold"},
+		{`{"BTC": {"upper_threshold": "2", "direction": "below"}}`, "BTC.direction"},
+		{`{"BTC": {"threshold": "1", "direction": "sideways"}}`, "BTC.direction"},
+		{`{"BTC": {"upper_threshold": "-2"}}`, "BTC.upper_threshold"},
+		{`{"BTC": {"upper_threshold": "50000", "lower_threshold": "60000"}}`, "BTC.lower_threshold"},
+	}
+	for _, c := range cases {
+		rec := postThresholds(s, c.body)
+		var got struct {
+			Errors validationErrors `json:"errors"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("%s: %v", c.body, err)
+		}
+		if rec.Code != http.StatusBadRequest || len(got.Errors) != 1 || got.Errors[0].Field != c.field {
+			t.Errorf("%s: status %d, errors %+v, want one on %s", c.body, rec.Code, got.Errors, c.field)
+		}
+	}
+	if cfg.Tokens[0].Threshold.String() != "60000" {
+		t.Errorf("rejected updates changed the token: %+v", cfg.Tokens[0])
+	}
+}
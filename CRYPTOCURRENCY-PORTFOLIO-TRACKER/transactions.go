@@ -0,0 +1,487 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transaction types stored in the transactions table
+const (
+	txBuy        = "buy"
+	txSell       = "sell"
+	txDeposit    = "deposit"
+	txWithdrawal = "withdrawal"
+)
+
+// Transaction is a single ledger entry that moves a holding up or down
+type Transaction struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	Type       string    `json:"type"`
+	Symbol     string    `json:"symbol"`
+	Amount     float64   `json:"amount"`
+	Price      float64   `json:"price"`
+	Fee        float64   `json:"fee"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// importRowResult reports what happened to one CSV row during an import
+type importRowResult struct {
+	Line          int              `json:"line"`
+	Status        string           `json:"status"`
+	TransactionID int64            `json:"transaction_id,omitempty"`
+	Error         string           `json:"error,omitempty"`
+	Errors        validationErrors `json:"errors,omitempty"` // Per-field problems when the row failed validation
+}
+
+// transactionCSVColumns maps our fields to the header names used by the
+// common export formats (Koinly, CoinTracker and plain spreadsheets).
+// Headers are matched case-insensitively. date, type, symbol and amount
+// are required; price and fee default to 0 when the column is absent.
+var transactionCSVColumns = map[string][]string{
+	"date":   {"date", "date (utc)", "timestamp", "time"},
+	"type":   {"type", "transaction type", "label"},
+	"symbol": {"symbol", "currency", "asset", "coin"},
+	"amount": {"amount", "quantity"},
+	"price":  {"price", "price (usd)", "unit price"},
+	"fee":    {"fee", "fee amount", "fees"},
+}
+
+// transactionTypeAliases maps external transaction types to ours
+var transactionTypeAliases = map[string]string{
+	"buy":        txBuy,
+	"trade":      txBuy,
+	"sell":       txSell,
+	"deposit":    txDeposit,
+	"receive":    txDeposit,
+	"income":     txDeposit,
+	"airdrop":    txDeposit,
+	"reward":     txDeposit,
+	"withdrawal": txWithdrawal,
+	"withdraw":   txWithdrawal,
+	"send":       txWithdrawal,
+}
+
+// transactionDateLayouts are tried in order when parsing the date column
+var transactionDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04 MST",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"01/02/2006 15:04:05",
+	"01/02/2006",
+}
+
+// handleImportTransactions imports a CSV export from another tracker.
+// The file is sent either as the raw request body or as the "file" field
+// of a multipart form, and user_id is passed as a query parameter unless
+// logged in.
+func (s *Server) handleImportTransactions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := optionalUserID(w, r)
+	if !ok {
+		return
+	}
+	if userID, ok = scopeUserID(w, r, userID); !ok {
+		return
+	}
+	if userID == 0 {
+		http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Error reading uploaded file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	results, err := s.importTransactions(userID, body)
+	if err != nil {
+		var headerErr *csvHeaderError
+		if errors.As(err, &headerErr) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Error importing transactions", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Imported int               `json:"imported"`
+		Failed   int               `json:"failed"`
+		Rows     []importRowResult `json:"rows"`
+	}{
+		Rows: results,
+	}
+	for _, res := range results {
+		if res.Status == "imported" {
+			response.Imported++
+		} else {
+			response.Failed++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
+
+// csvHeaderError is returned when the CSV header is missing a required column
+type csvHeaderError struct {
+	msg string
+}
+
+func (e *csvHeaderError) Error() string {
+	return e.msg
+}
+
+// importTransactions parses the CSV, validates every row and applies the
+// valid ones in date order inside a single database transaction. Invalid
+// rows are reported in the results and do not stop the import.
+func (s *Server) importTransactions(userID int, src io.Reader) ([]importRowResult, error) {
+	reader := csv.NewReader(src)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, &csvHeaderError{msg: "CSV file is empty"}
+	}
+	if err != nil {
+		return nil, &csvHeaderError{msg: fmt.Sprintf("error reading CSV header: %v", err)}
+	}
+	columns, err := mapTransactionColumns(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []importRowResult
+	var pending []Transaction
+	var pendingLines []int
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			results = append(results, importRowResult{Line: line, Status: "error", Error: err.Error()})
+			continue
+		}
+		tx, errs := parseTransactionRecord(record, columns)
+		if len(errs) > 0 {
+			results = append(results, importRowResult{Line: line, Status: "error", Error: errs.Error(), Errors: errs})
+			continue
+		}
+		tx.UserID = userID
+		pending = append(pending, tx)
+		pendingLines = append(pendingLines, line)
+	}
+
+	// Exports are often newest-first, so apply rows chronologically to
+	// avoid rejecting a sell that happens after its buy.
+	order := make([]int, len(pending))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return pending[order[a]].OccurredAt.Before(pending[order[b]].OccurredAt)
+	})
+
+	sorted := make([]Transaction, len(order))
+	for n, i := range order {
+		sorted[n] = pending[i]
+	}
+	rowErrs, err := s.store.ApplyTransactions(sorted)
+	if err != nil {
+		return nil, err
+	}
+	for n, i := range order {
+		res := importRowResult{Line: pendingLines[i]}
+		if rowErrs[n] != nil {
+			res.Status = "error"
+			res.Error = rowErrs[n].Error()
+		} else {
+			res.Status = "imported"
+			res.TransactionID = int64(sorted[n].ID)
+		}
+		results = append(results, res)
+	}
+
+	sort.SliceStable(results, func(a, b int) bool {
+		return results[a].Line < results[b].Line
+	})
+	return results, nil
+}
+
+// mapTransactionColumns resolves the index of each known column in the header
+func mapTransactionColumns(header []string) (map[string]int, error) {
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		for field, aliases := range transactionCSVColumns {
+			if _, found := columns[field]; found {
+				continue
+			}
+			for _, alias := range aliases {
+				if name == alias {
+					columns[field] = i
+					break
+				}
+			}
+		}
+	}
+
+	var missing []string
+	for _, field := range []string{"date", "type", "symbol", "amount"} {
+		if _, ok := columns[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &csvHeaderError{msg: "CSV header is missing required columns: " + strings.Join(missing, ", ")}
+	}
+	return columns, nil
+}
+
+// parseTransactionRecord validates one CSV record and converts it to a
+// Transaction. All problems with the record are returned together.
+func parseTransactionRecord(record []string, columns map[string]int) (Transaction, validationErrors) {
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var tx Transaction
+	var errs validationErrors
+
+	occurredAt, err := parseTransactionDate(field("date"))
+	if err != nil {
+		errs.add("date", err.Error())
+	}
+	tx.OccurredAt = occurredAt
+
+	txType, ok := transactionTypeAliases[strings.ToLower(field("type"))]
+	if !ok {
+		errs.add("type", fmt.Sprintf("unsupported transaction type %q", field("type")))
+	}
+	tx.Type = txType
+
+	tx.Symbol = normalizeSymbol(field("symbol"))
+	if tx.Symbol == "" {
+		errs.add("symbol", "is required")
+	}
+
+	// Some exports record outgoing amounts as negative numbers; the type
+	// already carries the direction.
+	if amount, err := parseCSVNumber(field("amount")); err != nil {
+		errs.add("amount", "is not a number")
+	} else if tx.Amount = math.Abs(amount); tx.Amount == 0 {
+		errs.add("amount", "must not be zero")
+	}
+
+	if tx.Price, err = parseCSVNumber(field("price")); err != nil {
+		errs.add("price", "is not a number")
+	} else if tx.Price < 0 {
+		errs.add("price", "must not be negative")
+	}
+
+	if tx.Fee, err = parseCSVNumber(field("fee")); err != nil {
+		errs.add("fee", "is not a number")
+	} else if tx.Fee < 0 {
+		errs.add("fee", "must not be negative")
+	}
+
+	return tx, errs
+}
+
+// parseTransactionDate parses a date in any of the supported layouts
+func parseTransactionDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("is required")
+	}
+	for _, layout := range transactionDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date format %q", value)
+}
+
+// parseCSVNumber parses a number that may contain thousands separators.
+// An empty value is treated as 0.
+func parseCSVNumber(value string) (float64, error) {
+	value = strings.ReplaceAll(value, ",", "")
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+var errInsufficientHoldings = errors.New("insufficient holdings")
+
+func (s *SQLStore) ApplyTransactions(txs []Transaction) ([]error, error) {
+	rowErrs := make([]error, len(txs))
+	err := withTxOn(s.db, func(dbTx *sql.Tx) error {
+		for i := range txs {
+			id, err := applyTransaction(dbTx, txs[i])
+			switch {
+			case errors.Is(err, errInsufficientHoldings):
+				rowErrs[i] = err
+			case err != nil:
+				return err
+			default:
+				txs[i].ID = int(id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rowErrs, nil
+}
+
+// applyTransaction records the transaction and adjusts the user's holding
+func applyTransaction(tx *sql.Tx, t Transaction) (int64, error) {
+	delta := t.Amount
+	if t.Type == txSell || t.Type == txWithdrawal {
+		delta = -t.Amount
+	}
+	if err := adjustHolding(tx, t.UserID, t.Symbol, delta, unitCost(t)); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(
+		"INSERT INTO transactions (user_id, type, symbol, amount, price, fee, occurred_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		t.UserID, t.Type, t.Symbol, t.Amount, t.Price, t.Fee, t.OccurredAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// unitCost returns what each unit of a buy or deposit cost, including its
+// fee if fees_in_cost_basis is set, or nil if it has no price
+func unitCost(t Transaction) *float64 {
+	if (t.Type != txBuy && t.Type != txDeposit) || t.Price <= 0 || t.Amount <= 0 {
+		return nil
+	}
+	cost := t.Amount * t.Price
+	if cfg.FeesInCostBasis {
+		cost += t.Fee
+	}
+	unit := cost / t.Amount
+	return &unit
+}
+
+// adjustHolding adds delta to the user's holding of symbol. Increases go to
+// the oldest existing row (or a new one) and re-average its cost basis with
+// cost, which is unknown if nil; decreases are taken from the rows in order,
+// leave the cost per unit as it was and fail if the user doesn't hold enough.
+func adjustHolding(tx *sql.Tx, userID int, symbol string, delta float64, cost *float64) error {
+	rows, err := tx.Query("SELECT id, amount, cost_basis FROM portfolio WHERE user_id = ? AND symbol = ? ORDER BY id", userID, symbol)
+	if err != nil {
+		return err
+	}
+	type holdingRow struct {
+		id        int
+		amount    float64
+		costBasis sql.NullFloat64
+	}
+	var holdings []holdingRow
+	var held float64
+	for rows.Next() {
+		var h holdingRow
+		if err := rows.Scan(&h.id, &h.amount, &h.costBasis); err != nil {
+			rows.Close()
+			return err
+		}
+		holdings = append(holdings, h)
+		held += h.amount
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if delta > 0 {
+		if len(holdings) == 0 {
+			_, err := tx.Exec("INSERT INTO portfolio (user_id, symbol, amount, cost_basis) VALUES (?, ?, ?, ?)", userID, symbol, delta, cost)
+			return err
+		}
+		first := holdings[0]
+		var averaged *float64
+		if cost != nil && first.costBasis.Valid {
+			a := (first.amount*first.costBasis.Float64 + delta**cost) / (first.amount + delta)
+			averaged = &a
+		} else if cost != nil && first.amount <= 0 {
+			averaged = cost
+		}
+		_, err := tx.Exec("UPDATE portfolio SET amount = amount + ?, cost_basis = ?, updated_at = ? WHERE id = ?", delta, averaged, now, first.id)
+		return err
+	}
+
+	remaining := -delta
+	if held < remaining {
+		return fmt.Errorf("%w: holding %g %s, cannot remove %g", errInsufficientHoldings, held, symbol, remaining)
+	}
+	for _, h := range holdings {
+		if remaining <= 0 {
+			break
+		}
+		take := math.Min(h.amount, remaining)
+		if _, err := tx.Exec("UPDATE portfolio SET amount = amount - ?, updated_at = ? WHERE id = ?", take, now, h.id); err != nil {
+			return err
+		}
+		remaining -= take
+	}
+	return nil
+}
+
+var errNoCostBasis = errors.New("no cost basis")
+
+// averageCost returns the average cost basis of everything held in symbol
+func (s *Server) averageCost(symbol string) (float64, error) {
+	costs, err := s.store.HoldingCosts(0)
+	if err != nil {
+		return 0, err
+	}
+	cost, ok := costs[symbol]
+	if !ok {
+		return 0, fmt.Errorf("%w recorded for %s", errNoCostBasis, symbol)
+	}
+	return cost, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// newSQLStore returns a SQLStore on a fresh, migrated database file
+func newSQLStore(t *testing.T) *SQLStore {
+	t.Helper()
+	handle := openTestDB(t)
+	if err := migrate(handle); err != nil {
+		t.Fatal(err)
+	}
+	return NewSQLStore(handle)
+}
+
+func applyAll(t *testing.T, store *SQLStore, txs ...Transaction) {
+	t.Helper()
+	rowErrs, err := store.ApplyTransactions(txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, err := range rowErrs {
+		if err != nil {
+			t.Fatalf("transaction %d: %v", i, err)
+		}
+	}
+}
+
+func TestTransactionsAverageHoldingCost(t *testing.T) {
+	cases := []struct {
+		name        string
+		includeFees bool
+		want        float64
+	}{
+		// 1 BTC at 100 and 3 BTC at 200, then a sell that keeps the average
+		{"without fees", false, 175},
+		{"with fees", true, 176},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			useConfig(t, &config{FeesInCostBasis: c.includeFees})
+			store := newSQLStore(t)
+
+			now := time.Now().UTC()
+			applyAll(t, store,
+				Transaction{UserID: 1, Type: txBuy, Symbol: "BTC", Amount: 1, Price: 100, Fee: 2, OccurredAt: now},
+				Transaction{UserID: 1, Type: txBuy, Symbol: "BTC", Amount: 3, Price: 200, Fee: 2, OccurredAt: now},
+				Transaction{UserID: 1, Type: txSell, Symbol: "BTC", Amount: 2, Price: 300, Fee: 1, OccurredAt: now},
+			)
+
+			costs, err := store.HoldingCosts(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(costs["BTC"]-c.want) > 1e-9 {
+				t.Errorf("cost basis = %v, want %v", costs["BTC"], c.want)
+			}
+
+			// The ledger's view of the remaining cost must agree
+			txs, err := store.Transactions(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries := realizedPnL(txs, c.includeFees)
+			if len(entries) != 1 || math.Abs(entries[0].CostBasis/entries[0].Amount-c.want) > 1e-9 {
+				t.Errorf("ledger entries %+v disagree with cost basis %v", entries, c.want)
+			}
+		})
+	}
+}
+
+func TestTransactionsUnpricedDepositLeavesCostUnknown(t *testing.T) {
+	useConfig(t, &config{})
+	store := newSQLStore(t)
+
+	now := time.Now().UTC()
+	applyAll(t, store,
+		Transaction{UserID: 1, Type: txBuy, Symbol: "ETH", Amount: 1, Price: 10, OccurredAt: now},
+		Transaction{UserID: 1, Type: txDeposit, Symbol: "ETH", Amount: 1, OccurredAt: now},
+	)
+	costs, err := store.HoldingCosts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, ok := costs["ETH"]; ok {
+		t.Errorf("cost basis = %v, want unknown", cost)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+)
+
+const defaultTWRDays = 30
+
+// cashFlow is money moved into (positive) or out of (negative) the
+// portfolio by a transaction
+type cashFlow struct {
+	At     time.Time
+	Amount float64
+}
+
+// ledgerCashFlows converts transactions into cash flows valued at their
+// price: buys and deposits flow in, sells and withdrawals flow out.
+// Transfers recorded without a price carry no value and so show up in
+// the returns instead.
+func ledgerCashFlows(txs []Transaction) []cashFlow {
+	flows := make([]cashFlow, 0, len(txs))
+	for _, t := range txs {
+		value := t.Amount * t.Price
+		switch t.Type {
+		case txSell, txWithdrawal:
+			value = -value
+		case txBuy, txDeposit:
+		default:
+			continue
+		}
+		flows = append(flows, cashFlow{At: t.OccurredAt, Amount: value})
+	}
+	return flows
+}
+
+// timeWeightedReturn chains the returns of each interval between
+// consecutive value snapshots so that deposits and withdrawals don't count
+// as performance. All flows within an interval are assumed to happen at
+// its end, so each interval's return is
+//
+//	(end value - net flows) / start value - 1
+//
+// and the time-weighted return is the product of (1 + return) over all
+// intervals, minus one. Intervals starting from a zero value are skipped.
+// It returns the number of intervals used and false if there were none.
+func timeWeightedReturn(snapshots []valueSnapshot, flows []cashFlow) (float64, int, bool) {
+	growth := 1.0
+	periods := 0
+	next := 0
+	for i := 1; i < len(snapshots); i++ {
+		start, end := snapshots[i-1], snapshots[i]
+		var net float64
+		for next < len(flows) && !flows[next].At.After(end.RecordedAt) {
+			if flows[next].At.After(start.RecordedAt) {
+				net += flows[next].Amount
+			}
+			next++
+		}
+		if start.TotalValue <= 0 {
+			continue
+		}
+		growth *= (end.TotalValue - net) / start.TotalValue
+		periods++
+	}
+	if periods == 0 {
+		return 0, 0, false
+	}
+	return growth - 1, periods, true
+}
+
+// handleTWR returns the time-weighted return of the whole portfolio over
+// ?from..?to (default the last 30 days), using the recorded value history
+// and the transaction ledger as cash flows. Holdings added directly through
+// /portfolio/add aren't ledger entries and so count as returns.
+func (s *Server) handleTWR(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -defaultTWRDays)
+	var err error
+	if query.Get("from") != "" {
+		if from, err = parseDateParam(query.Get("from")); err != nil {
+			http.Error(w, "Invalid from date", http.StatusBadRequest)
+			return
+		}
+	}
+	if query.Get("to") != "" {
+		if to, err = parseDateParam(query.Get("to")); err != nil {
+			http.Error(w, "Invalid to date", http.StatusBadRequest)
+			return
+		}
+	}
+	if !to.After(from) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	snapshots, err := s.store.ValueHistory(from)
+	if err != nil {
+		serverError(w, r, "Error fetching value history", err)
+		return
+	}
+	for len(snapshots) > 0 && snapshots[len(snapshots)-1].RecordedAt.After(to) {
+		snapshots = snapshots[:len(snapshots)-1]
+	}
+	txs, err := s.store.Transactions(0)
+	if err != nil {
+		serverError(w, r, "Error fetching transactions", err)
+		return
+	}
+	twr, periods, ok := timeWeightedReturn(snapshots, ledgerCashFlows(txs))
+
+	response := struct {
+		From       time.Time `json:"from"`
+		To         time.Time `json:"to"`
+		Periods    int       `json:"periods"`
+		TWRPercent *float64  `json:"twr_percent"` // Null without at least two snapshots
+
+		// AnnualizedPercent compounds the return over a year; only set for
+		// ranges of at least a day, where it is meaningful
+		AnnualizedPercent *float64 `json:"annualized_percent,omitempty"`
+	}{
+		From:    from,
+		To:      to,
+		Periods: periods,
+	}
+	if ok {
+		percent := roundPercent(twr * 100)
+		response.TWRPercent = &percent
+		span := snapshots[len(snapshots)-1].RecordedAt.Sub(snapshots[0].RecordedAt)
+		if span >= 24*time.Hour && twr > -1 {
+			annualized := roundPercent((math.Pow(1+twr, float64(hoursPerYear)/span.Hours()) - 1) * 100)
+			if !math.IsInf(annualized, 0) {
+				response.AnnualizedPercent = &annualized
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
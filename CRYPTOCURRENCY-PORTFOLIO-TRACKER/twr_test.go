@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeWeightedReturnIgnoresCashFlows(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	day := func(n int) time.Time { return start.AddDate(0, 0, n) }
+	snapshots := []valueSnapshot{
+		{TotalValue: 100, RecordedAt: day(0)},
+		{TotalValue: 110, RecordedAt: day(1)}, // +10%
+		{TotalValue: 220, RecordedAt: day(2)}, // 100 deposited, then 0%
+		{TotalValue: 110, RecordedAt: day(3)}, // 110 withdrawn, then 0%
+		{TotalValue: 121, RecordedAt: day(4)}, // +10%
+	}
+	flows := []cashFlow{
+		{At: day(1).Add(time.Hour), Amount: 110},
+		{At: day(3), Amount: -110},
+	}
+
+	twr, periods, ok := timeWeightedReturn(snapshots, flows)
+	if !ok || periods != 4 || math.Abs(twr-0.21) > 1e-9 {
+		t.Errorf("got %v over %d periods (%v), want 0.21 over 4", twr, periods, ok)
+	}
+}
+
+func TestTimeWeightedReturnSkipsEmptyIntervals(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	snapshots := []valueSnapshot{
+		{TotalValue: 0, RecordedAt: start},
+		{TotalValue: 100, RecordedAt: start.Add(time.Hour)},
+		{TotalValue: 150, RecordedAt: start.Add(2 * time.Hour)},
+	}
+	flows := []cashFlow{{At: start.Add(30 * time.Minute), Amount: 100}}
+
+	twr, periods, ok := timeWeightedReturn(snapshots, flows)
+	if !ok || periods != 1 || math.Abs(twr-0.5) > 1e-9 {
+		t.Errorf("got %v over %d periods (%v), want 0.5 over 1", twr, periods, ok)
+	}
+	if _, _, ok := timeWeightedReturn(snapshots[:1], nil); ok {
+		t.Error("a single snapshot gave a return")
+	}
+}
+
+func TestLedgerCashFlowSigns(t *testing.T) {
+	txs := []Transaction{
+		{Type: txBuy, Amount: 2, Price: 10},
+		{Type: txSell, Amount: 1, Price: 30},
+		{Type: txDeposit, Amount: 1, Price: 5},
+		{Type: txWithdrawal, Amount: 1, Price: 4},
+	}
+	want := []float64{20, -30, 5, -4}
+	flows := ledgerCashFlows(txs)
+	if len(flows) != len(want) {
+		t.Fatalf("got %d flows, want %d", len(flows), len(want))
+	}
+	for i, f := range flows {
+		if f.Amount != want[i] {
+			t.Errorf("flow %d (%s) = %v, want %v", i, txs[i].Type, f.Amount, want[i])
+		}
+	}
+}
+
+func TestTWRFromRecordedHistory(t *testing.T) {
+	store := newSQLStore(t)
+	_, mux := newTestServer(t, store, fakePrices{})
+
+	start := time.Now().UTC().AddDate(0, 0, -3).Truncate(time.Hour)
+	for i, value := range []float64{100, 110, 220} {
+		if err := store.RecordValue(valueSnapshot{TotalValue: value, RecordedAt: start.AddDate(0, 0, i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The second day's growth came entirely from a deposit
+	applyAll(t, store, Transaction{UserID: 1, Type: txBuy, Symbol: "BTC", Amount: 1, Price: 110, OccurredAt: start.AddDate(0, 0, 2)})
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/twr", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		Periods    int      `json:"periods"`
+		TWRPercent *float64 `json:"twr_percent"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Periods != 2 || got.TWRPercent == nil || math.Abs(*got.TWRPercent-10) > 1e-6 {
+		t.Errorf("got %+v, want 10%% over 2 periods", got)
+	}
+}
+
+func TestTWRRejectsReversedRange(t *testing.T) {
+	_, mux := newTestServer(t, newSQLStore(t), fakePrices{})
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/twr?from=2026-03-02&to=2026-03-01", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
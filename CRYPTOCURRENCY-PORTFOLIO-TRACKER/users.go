@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	passwordIterations  = 600000 // PBKDF2-HMAC-SHA256 rounds, as OWASP recommends
+	passwordSaltBytes   = 16
+	passwordKeyBytes    = 32
+	minPasswordLength   = 8
+	sessionTokenBytes   = 32
+	defaultSessionHours = 24
+)
+
+var (
+	// errUsernameTaken means another user already registered the username
+	errUsernameTaken = errors.New("username already taken")
+
+	// usernamePattern is what /register accepts as a username
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,32}$`)
+)
+
+// user is a registered account
+type user struct {
+	ID           int       `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// hashPassword derives a salted PBKDF2 hash of password, encoded with the
+// parameters needed to check it later
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeyBytes)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// checkPassword reports whether password matches a hash from hashPassword
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := enc.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// dummyPasswordHash is checked against when a login names an unknown user,
+// so the response takes as long as for a wrong password
+var dummyPasswordHash, _ = hashPassword("not a real password")
+
+// newSessionToken returns a random session token and the hash of it that
+// is stored, so a leaked database doesn't hand out live sessions
+func newSessionToken() (token, tokenHash string, err error) {
+	b := make([]byte, sessionTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashSessionToken(token), nil
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sessionTTL is how long a login lasts
+func (c authConfig) sessionTTL() time.Duration {
+	if c.SessionHours > 0 {
+		return time.Duration(c.SessionHours) * time.Hour
+	}
+	return defaultSessionHours * time.Hour
+}
+
+func (s *SQLStore) CreateUser(username, passwordHash string) (user, error) {
+	u := user{Username: username, PasswordHash: passwordHash, CreatedAt: time.Now().UTC()}
+	err := withTxOn(s.db, func(tx *sql.Tx) error {
+		var taken int
+		err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			return errUsernameTaken
+		}
+		// Holdings recorded before accounts existed carry bare user ids. A
+		// new account starts above all of them so it can't inherit any.
+		err = tx.QueryRow(`
+			SELECT MAX(COALESCE((SELECT MAX(id) FROM users), 0),
+			           COALESCE((SELECT MAX(user_id) FROM portfolio), 0),
+			           COALESCE((SELECT MAX(user_id) FROM transactions), 0),
+			           COALESCE((SELECT MAX(user_id) FROM user_settings), 0)) + 1`).Scan(&u.ID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			"INSERT INTO users (id, username, password_hash, created_at) VALUES (?, ?, ?, ?)",
+			u.ID, u.Username, u.PasswordHash, u.CreatedAt,
+		)
+		return err
+	})
+	return u, err
+}
+
+func (s *SQLStore) UserByName(username string) (user, error) {
+	var u user
+	err := s.db.QueryRow(
+		"SELECT id, username, password_hash, created_at FROM users WHERE username = ?", username,
+	).Scan(&u.ID, &u.Username, &u.PasswordHash, &u.CreatedAt)
+	return u, err
+}
+
+func (s *SQLStore) CreateSession(tokenHash string, userID int, expiresAt time.Time) error {
+	return withTxOn(s.db, func(tx *sql.Tx) error {
+		// Expired sessions are of no use to anyone
+		if _, err := tx.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now().UTC()); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)", tokenHash, userID, expiresAt.UTC())
+		return err
+	})
+}
+
+func (s *SQLStore) SessionUser(tokenHash string, now time.Time) (int, error) {
+	var userID int
+	err := s.db.QueryRow("SELECT user_id FROM sessions WHERE token_hash = ? AND expires_at > ?", tokenHash, now.UTC()).Scan(&userID)
+	return userID, err
+}
+
+// credentials is the body of /register and /login
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// handleRegister creates an account
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var c credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Error parsing request body", http.StatusBadRequest)
+		return
+	}
+	c.Username = strings.TrimSpace(c.Username)
+	var errs validationErrors
+	if !usernamePattern.MatchString(c.Username) {
+		errs.add("username", "must be 3 to 32 letters, digits, '.', '_' or '-'")
+	}
+	if len(c.Password) < minPasswordLength {
+		errs.add("password", fmt.Sprintf("must be at least %d characters", minPasswordLength))
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	hash, err := hashPassword(c.Password)
+	if err != nil {
+		serverError(w, r, "Error registering user", err)
+		return
+	}
+	u, err := s.store.CreateUser(c.Username, hash)
+	if errors.Is(err, errUsernameTaken) {
+		http.Error(w, "Username already taken", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error registering user", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(u)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleLogin checks a username and password and issues a session token,
+// to be sent as "Authorization: Bearer <token>"
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var c credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Error parsing request body", http.StatusBadRequest)
+		return
+	}
+
+	u, err := s.store.UserByName(strings.TrimSpace(c.Username))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		serverError(w, r, "Error logging in", err)
+		return
+	}
+	if err != nil {
+		checkPassword(dummyPasswordHash, c.Password)
+	}
+	if err != nil || !checkPassword(u.PasswordHash, c.Password) {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	token, tokenHash, err := newSessionToken()
+	if err != nil {
+		serverError(w, r, "Error logging in", err)
+		return
+	}
+	expiresAt := time.Now().UTC().Add(cfg.Auth.sessionTTL())
+	if err := s.store.CreateSession(tokenHash, u.ID, expiresAt); err != nil {
+		serverError(w, r, "Error logging in", err)
+		return
+	}
+
+	response := struct {
+		Token     string    `json:"token"`
+		UserID    int       `json:"user_id"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{
+		Token:     token,
+		UserID:    u.ID,
+		ExpiresAt: expiresAt,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
+
+// sessionUserKey is the context key of the logged-in user's id
+type sessionUserKey struct{}
+
+// sessionUserID returns the id of the user a request is logged in as
+func sessionUserID(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(sessionUserKey{}).(int)
+	return id, ok
+}
+
+// authenticate resolves a bearer session token to its user. A token that
+// isn't a live session is passed on untouched, since it may be the API key.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := requestAPIKey(r)
+		if token == "" || r.Header.Get("X-API-Key") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		userID, err := s.store.SessionUser(hashSessionToken(token), time.Now())
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				serverError(w, r, "Error checking session", err)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionUserKey{}, userID)))
+	})
+}
+
+// scopeUserID returns the user a portfolio request acts for: the logged-in
+// user if there is one, ignoring requested. Otherwise requested is trusted
+// unless auth.require_login is set, in which case a 401 is written and
+// false returned.
+func scopeUserID(w http.ResponseWriter, r *http.Request, requested int) (int, bool) {
+	if id, ok := sessionUserID(r.Context()); ok {
+		return id, true
+	}
+	if cfg.Auth.RequireLogin {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="portfolio"`)
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return 0, false
+	}
+	return requested, true
+}
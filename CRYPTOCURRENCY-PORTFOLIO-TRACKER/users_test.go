@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// asUser returns req as logged in as userID
+func asUser(req *http.Request, userID int) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), sessionUserKey{}, userID))
+}
+
+func twoUserStore() *fakeStore {
+	return &fakeStore{
+		rows: []Portfolio{
+			{ID: 1, UserID: 1, Symbol: "BTC", Amount: 1},
+			{ID: 2, UserID: 2, Symbol: "ETH", Amount: 100},
+		},
+		costs: map[string]float64{"BTC": 10, "ETH": 10},
+	}
+}
+
+func TestLoggedInUserCannotReadAnotherUsersHoldings(t *testing.T) {
+	_, mux := newTestServer(t, twoUserStore(), fakePrices{"BTC": 100, "ETH": 10})
+
+	for _, path := range []string{
+		"/portfolio?user_id=2",
+		"/portfolio/value?user_id=2",
+		"/portfolio/pnl?user_id=2",
+		"/portfolio/performers?user_id=2",
+	} {
+		rec := serve(mux, asUser(httptest.NewRequest(http.MethodGet, path, nil), 1))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, body %s", path, rec.Code, rec.Body)
+			continue
+		}
+		body := rec.Body.String()
+		if strings.Contains(body, "ETH") {
+			t.Errorf("%s: user 1 saw user 2's holdings: %s", path, body)
+		}
+		if !strings.Contains(body, "BTC") {
+			t.Errorf("%s: user 1's own holdings are missing: %s", path, body)
+		}
+	}
+}
+
+func TestRequireLoginRejectsAnonymousReads(t *testing.T) {
+	_, mux := newTestServer(t, twoUserStore(), fakePrices{"BTC": 100, "ETH": 10})
+	cfg.Auth.RequireLogin = true
+
+	for _, path := range []string{
+		"/portfolio/value?user_id=2",
+		"/portfolio/pnl?user_id=2",
+		"/portfolio/performers?user_id=2",
+		"/portfolio/preview-add?user_id=2&symbol=BTC&amount=1",
+		"/portfolio/target?user_id=2&symbol=ETH&target=5000",
+		"/users/settings?user_id=2",
+	} {
+		rec := serve(mux, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want %d", path, rec.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestPreferredCurrencyFollowsSessionUser(t *testing.T) {
+	store := twoUserStore()
+	store.currency = map[int]string{2: "BTC"}
+	_, mux := newTestServer(t, store, fakePrices{"BTC": 100, "ETH": 10})
+
+	// user_id=2 would pick user 2's BTC preference, which needs a live BTC
+	// price; scoped to user 1 the default USD is used instead
+	rec := serve(mux, asUser(httptest.NewRequest(http.MethodGet, "/portfolio/value?user_id=2", nil), 1))
+	var got struct {
+		Currency string `json:"currency"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Currency != "USD" {
+		t.Errorf("currency = %q, want USD", got.Currency)
+	}
+}
+
+// login logs username in and returns the session token
+func login(t *testing.T, handler http.Handler, username, password string) string {
+	t.Helper()
+	rec := postJSON(handler, "/login", fmt.Sprintf(`{"username": %q, "password": %q}`, username, password))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login as %s: status = %d, body %s", username, rec.Code, rec.Body)
+	}
+	var got struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || got.Token == "" {
+		t.Fatalf("login as %s: token %q, err %v", username, got.Token, err)
+	}
+	return got.Token
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	store := newSQLStore(t)
+	s, mux := newTestServer(t, store, fakePrices{"BTC": 100, "ETH": 10})
+	handler := s.authenticate(mux)
+
+	rec := postJSON(handler, "/register", `{"username": "alice", "password": "correct horse"}`)
+	if rec.Code != http.StatusCreated || strings.Contains(rec.Body.String(), "pbkdf2") {
+		t.Fatalf("register: status = %d, body %s", rec.Code, rec.Body)
+	}
+	var alice user
+	if err := json.NewDecoder(rec.Body).Decode(&alice); err != nil || alice.ID == 0 || alice.Username != "alice" {
+		t.Fatalf("registered %+v, err %v", alice, err)
+	}
+	if got := columnValues(t, store.db, "SELECT password_hash FROM users"); strings.Contains(got, "correct horse") {
+		t.Errorf("password stored in the clear: %s", got)
+	}
+
+	for _, c := range []struct{ name, body string }{
+		{"wrong password", `{"username": "alice", "password": "wrong horse"}`},
+		{"unknown user", `{"username": "bob", "password": "correct horse"}`},
+	} {
+		if rec := postJSON(handler, "/login", c.body); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", c.name, rec.Code)
+		}
+	}
+
+	// The session token scopes requests to alice, whatever user_id they name
+	addHoldings(t, store, Portfolio{UserID: alice.ID, Symbol: "BTC", Amount: 1}, Portfolio{UserID: alice.ID + 1, Symbol: "ETH", Amount: 1})
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/portfolio?user_id=%d", alice.ID+1), nil)
+	req.Header.Set("Authorization", "Bearer "+login(t, handler, "alice", "correct horse"))
+	rec = serve(handler, req)
+	if body := rec.Body.String(); rec.Code != http.StatusOK || !strings.Contains(body, "BTC") || strings.Contains(body, "ETH") {
+		t.Errorf("portfolio as alice: status = %d, body %s; want only alice's BTC", rec.Code, body)
+	}
+}
+
+func TestRegisterRejectsDuplicateUsernames(t *testing.T) {
+	_, mux := newTestServer(t, newSQLStore(t), fakePrices{})
+	if rec := postJSON(mux, "/register", `{"username": "alice", "password": "correct horse"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("first registration: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if rec := postJSON(mux, "/register", `{"username": "alice", "password": "another horse"}`); rec.Code != http.StatusConflict {
+		t.Errorf("same username again: status = %d, want 409", rec.Code)
+	}
+	for _, body := range []string{
+		`{"username": "al", "password": "correct horse"}`,
+		`{"username": "bob", "password": "short"}`,
+	} {
+		if rec := postJSON(mux, "/register", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkPassword(hash, "correct horse") || checkPassword(hash, "wrong horse") {
+		t.Error("checkPassword doesn't tell the right password from a wrong one")
+	}
+	if other, _ := hashPassword("correct horse"); other == hash {
+		t.Error("hashes aren't salted")
+	}
+	for _, bad := range []string{"", "plain", "bcrypt$1$a$b", "pbkdf2-sha256$x$a$b"} {
+		if checkPassword(bad, "correct horse") {
+			t.Errorf("malformed hash %q accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// fieldError describes a problem with a single request field
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationErrors collects every problem found in a request so clients can
+// show them all at once
+type validationErrors []fieldError
+
+func (v *validationErrors) add(field, message string) {
+	*v = append(*v, fieldError{Field: field, Message: message})
+}
+
+func (v validationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// writeValidationErrors responds with 400 and the list of field errors
+func writeValidationErrors(w http.ResponseWriter, errs validationErrors) {
+	response := struct {
+		Errors validationErrors `json:"errors"`
+	}{
+		Errors: errs,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error encoding response data", http.StatusInternalServerError)
+		return
+	}
+}
+
+// normalizeSymbol returns the canonical, upper case form of a ticker
+// symbol. Symbols are normalized wherever they enter the tracker, from
+// clients, the config or CoinCap, so they always compare equal.
+func normalizeSymbol(symbol string) string {
+	return strings.ToUpper(strings.TrimSpace(symbol))
+}
+
+// validateHolding checks a portfolio entry submitted by a client
+func validateHolding(p Portfolio) validationErrors {
+	var errs validationErrors
+	if strings.TrimSpace(p.Symbol) == "" {
+		errs.add("symbol", "is required")
+	}
+	if p.Amount <= 0 {
+		errs.add("amount", "must be greater than 0")
+	}
+	return errs
+}
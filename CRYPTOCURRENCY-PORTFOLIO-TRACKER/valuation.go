@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// holdings is the amount held per symbol, in total and per user
+type holdings struct {
+	bySymbol map[string]float64
+	byUser   map[int]map[string]float64
+}
+
+// valuation is the result of pricing a set of holdings in USD
+type valuation struct {
+	TotalValue    float64
+	Prices        map[string]float64
+	Quotes        map[string]priceQuote
+	Values        map[string]float64
+	FailedSymbols []string
+	MarketClosed  []string
+
+	// NotFound are the FailedSymbols no price source lists at all, which
+	// is the request's problem rather than the source's
+	NotFound []string
+
+	// Unpriced are symbols CoinCap lists without a price, typically thinly
+	// traded ones. Unlike failures they are expected and not retried.
+	Unpriced []string
+
+	// Holdings worth less than the dust threshold, removed from Values
+	// but still counted in TotalValue
+	DustSymbols []string
+	DustValue   float64
+
+	// AsOf is when the prices were observed, set by valueHoldingsSnapshot
+	// if they all come from one observation
+	AsOf time.Time
+}
+
+// allocationEntry is a holding's share of the portfolio value
+type allocationEntry struct {
+	Symbol  string  `json:"symbol"`
+	Amount  float64 `json:"amount"`
+	Value   float64 `json:"value"`
+	Percent float64 `json:"percent"`
+}
+
+// queryHoldings reads the portfolio amounts from handle. A userID of 0
+// loads every user.
+func queryHoldings(handle *sql.DB, userID int) (holdings, error) {
+	query := "SELECT user_id, symbol, amount FROM portfolio"
+	var args []any
+	if userID != 0 {
+		query += " WHERE user_id = ?"
+		args = append(args, userID)
+	}
+	rows, err := handle.Query(query, args...)
+	if err != nil {
+		return holdings{}, err
+	}
+	defer rows.Close()
+
+	h := holdings{
+		bySymbol: make(map[string]float64),
+		byUser:   make(map[int]map[string]float64),
+	}
+	for rows.Next() {
+		var uid int
+		var symbol string
+		var amount float64
+		if err := rows.Scan(&uid, &symbol, &amount); err != nil {
+			return holdings{}, err
+		}
+		h.bySymbol[symbol] += amount
+		if h.byUser[uid] == nil {
+			h.byUser[uid] = make(map[string]float64)
+		}
+		h.byUser[uid][symbol] += amount
+	}
+	return h, rows.Err()
+}
+
+// valueHoldings prices each non-zero holding through priceProvider, in as
+// few requests as it allows, and totals them. Symbols that can't be priced
+// are listed in FailedSymbols and left out of the total; if no prices can be
+// fetched after retries, that is every symbol.
+func valueHoldings(ctx context.Context, amounts map[string]float64) valuation {
+	var symbols []string
+	for symbol, amount := range amounts {
+		if amount != 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	var failures PriceErrors
+	quotes, err := withPriceRetry(ctx, func(ctx context.Context) (map[string]priceQuote, error) {
+		quotes, err := providerQuotes(ctx, priceProvider, symbols)
+		failures = nil
+		if errors.As(err, &failures) {
+			// The prices were fetched; the missing ones won't appear by
+			// asking again straight away
+			return quotes, nil
+		}
+		return quotes, err
+	})
+	v := valueHoldingsWith(amounts, func(symbol string) (priceQuote, error) {
+		if err != nil {
+			return priceQuote{}, err
+		}
+		if err := failures[symbol]; err != nil {
+			return priceQuote{}, err
+		}
+		return quotes[symbol], nil
+	})
+	v.recordPrices()
+	return v
+}
+
+// valueHoldingsSnapshot values the holdings against a single observation,
+// taken by the first provider along priceProvider's chain that can, so every
+// price is from the same moment. If none can, the holdings are valued as by
+// valueHoldings and AsOf is left zero to show the prices may be from
+// different moments.
+func valueHoldingsSnapshot(ctx context.Context, amounts map[string]float64) valuation {
+	sp, ok := priceProvider.(snapshotProvider)
+	if !ok {
+		return valueHoldings(ctx, amounts)
+	}
+	var symbols []string
+	for symbol, amount := range amounts {
+		if amount != 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	type snapshot struct {
+		quotes map[string]priceQuote
+		asOf   time.Time
+	}
+	var failures PriceErrors
+	snap, err := withPriceRetry(ctx, func(ctx context.Context) (snapshot, error) {
+		quotes, asOf, err := sp.Snapshot(ctx, symbols)
+		failures = nil
+		if errors.As(err, &failures) {
+			// As in valueHoldings, the missing prices won't appear by
+			// asking again straight away
+			return snapshot{quotes, asOf}, nil
+		}
+		return snapshot{quotes, asOf}, err
+	})
+	if err != nil {
+		reportError("valuation", "Error taking price snapshot, valuing symbols separately", "provider", providerName(priceProvider), "err", err)
+		return valueHoldings(ctx, amounts)
+	}
+	v := valueHoldingsWith(amounts, func(symbol string) (priceQuote, error) {
+		if err := failures[symbol]; err != nil {
+			return priceQuote{}, err
+		}
+		return snap.quotes[symbol], nil
+	})
+	v.recordPrices()
+	v.AsOf = snap.asOf
+	return v
+}
+
+// recordPrices updates the price gauges from live prices
+func (v valuation) recordPrices() {
+	for symbol, price := range v.Prices {
+		priceGauge.set(price, symbol)
+	}
+}
+
+// heldSymbols counts the symbols with a non-zero amount, which are the ones
+// a valuation prices
+func heldSymbols(amounts map[string]float64) int {
+	n := 0
+	for _, amount := range amounts {
+		if amount != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// valueHoldingsWith values the holdings using quote to price each symbol
+func valueHoldingsWith(amounts map[string]float64, quote func(string) (priceQuote, error)) valuation {
+	v := valuation{
+		Prices: make(map[string]float64),
+		Quotes: make(map[string]priceQuote),
+		Values: make(map[string]float64),
+	}
+	now := time.Now()
+	for symbol, amount := range amounts {
+		if amount == 0 {
+			// Fully sold holdings contribute nothing and aren't worth a fetch
+			continue
+		}
+		if marketClosed(symbol, now) {
+			v.MarketClosed = append(v.MarketClosed, symbol)
+		}
+		q, err := quote(symbol)
+		if errors.Is(err, ErrPriceUnavailable) {
+			v.Unpriced = append(v.Unpriced, symbol)
+			continue
+		}
+		if err != nil {
+			reportError("valuation", "Error retrieving price", "symbol", symbol, "provider", providerName(priceProvider), "err", err)
+			v.FailedSymbols = append(v.FailedSymbols, symbol)
+			if errors.Is(err, ErrSymbolNotFound) {
+				v.NotFound = append(v.NotFound, symbol)
+			}
+			continue
+		}
+		price := q.Price
+		v.Prices[symbol] = price
+		v.Quotes[symbol] = q
+		v.Values[symbol] = price * amount
+		v.TotalValue += price * amount
+	}
+	sort.Strings(v.FailedSymbols)
+	sort.Strings(v.NotFound)
+	sort.Strings(v.MarketClosed)
+	sort.Strings(v.Unpriced)
+	return v
+}
+
+// excludeDust moves holdings worth less than minUSD out of Values so they
+// don't clutter breakdowns and allocations
+func (v *valuation) excludeDust(minUSD float64) {
+	if minUSD <= 0 {
+		return
+	}
+	for symbol, value := range v.Values {
+		if value < minUSD {
+			v.DustSymbols = append(v.DustSymbols, symbol)
+			v.DustValue += value
+			delete(v.Values, symbol)
+		}
+	}
+	sort.Strings(v.DustSymbols)
+}
+
+// allocation returns each non-dust holding's share of the non-dust total,
+// largest first, with values converted by rate
+func (v valuation) allocation(amounts map[string]float64, rate float64) []allocationEntry {
+	entries := make([]allocationEntry, 0, len(v.Values))
+	total := v.TotalValue - v.DustValue
+	for symbol, value := range v.Values {
+		entry := allocationEntry{Symbol: symbol, Amount: amounts[symbol], Value: value / rate}
+		if total > 0 {
+			entry.Percent = value / total * 100
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Value != entries[j].Value {
+			return entries[i].Value > entries[j].Value
+		}
+		return entries[i].Symbol < entries[j].Symbol
+	})
+	return entries
+}
+
+// quoteMap is the price quotes of a valuation by symbol. It encodes to XML
+// as a <quote symbol="..."> element per symbol, in symbol order, since
+// encoding/xml can't encode maps.
+type quoteMap map[string]priceQuote
+
+func (m quoteMap) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	symbols := make([]string, 0, len(m))
+	for symbol := range m {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, symbol := range symbols {
+		quote := xml.StartElement{
+			Name: xml.Name{Local: "quote"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "symbol"}, Value: symbol}},
+		}
+		if err := e.EncodeElement(m[symbol], quote); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// symbolList is a list of symbols in a response, encoded to XML as one
+// <symbol> element each inside the field's element
+type symbolList []string
+
+func (l symbolList) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(struct {
+		Symbols []string `xml:"symbol"`
+	}{l}, start)
+}
+
+// holdingValue is one holding's contribution to the portfolio value
+type holdingValue struct {
+	Symbol string  `json:"symbol" xml:"symbol"`
+	Amount float64 `json:"amount" xml:"amount"`
+	Price  money   `json:"price" xml:"price"` // Unit price
+	Value  money   `json:"value" xml:"value"`
+}
+
+// breakdown returns each priced, non-dust holding with its unit price and
+// value converted by rate and built by amount, largest value first
+func (v valuation) breakdown(amounts map[string]float64, rate float64, amount func(float64) money) []holdingValue {
+	symbols := make([]string, 0, len(v.Values))
+	for symbol := range v.Values {
+		symbols = append(symbols, symbol)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		a, b := symbols[i], symbols[j]
+		if v.Values[a] != v.Values[b] {
+			return v.Values[a] > v.Values[b]
+		}
+		return a < b
+	})
+	entries := make([]holdingValue, 0, len(symbols))
+	for _, symbol := range symbols {
+		entries = append(entries, holdingValue{
+			Symbol: symbol,
+			Amount: amounts[symbol],
+			Price:  amount(v.Prices[symbol] / rate),
+			Value:  amount(v.Values[symbol] / rate),
+		})
+	}
+	return entries
+}
+
+// writePriceError responds to a valuation that couldn't price symbols.
+// When every one of them is unknown or listed without a price, asking again
+// won't help, so that is 422 Unprocessable Entity; otherwise fetching
+// failed and it is a 500.
+func writePriceError(w http.ResponseWriter, v valuation, symbols []string) {
+	var unknown, unpriced []string
+	for _, symbol := range symbols {
+		switch {
+		case slices.Contains(v.NotFound, symbol):
+			unknown = append(unknown, symbol)
+		case slices.Contains(v.Unpriced, symbol):
+			unpriced = append(unpriced, symbol)
+		default:
+			http.Error(w, "Error fetching cryptocurrency price for "+strings.Join(symbols, ", "), http.StatusInternalServerError)
+			return
+		}
+	}
+	var problems []string
+	if len(unknown) > 0 {
+		problems = append(problems, "Unknown symbol "+strings.Join(unknown, ", "))
+	}
+	if len(unpriced) > 0 {
+		problems = append(problems, "No price available for "+strings.Join(unpriced, ", "))
+	}
+	http.Error(w, strings.Join(problems, "; "), http.StatusUnprocessableEntity)
+}
+
+// optionalUserID parses the optional user_id query parameter, returning 0
+// when it is absent. It writes a 400 and returns false if it is invalid.
+func optionalUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("user_id")
+	if value == "" {
+		return 0, true
+	}
+	userID, err := strconv.Atoi(value)
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid user_id", http.StatusBadRequest)
+		return 0, false
+	}
+	return userID, true
+}
+
+// dustThreshold returns the USD value below which holdings are hidden from
+// breakdowns, or 0 if the request asked to include dust
+func dustThreshold(r *http.Request) float64 {
+	if r.URL.Query().Get("include_dust") == "true" {
+		return 0
+	}
+	return cfg.DustThresholdUSD
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValueHoldingsFetchesAssetListOnce(t *testing.T) {
+	// With the cache off, any per-symbol lookup would show as extra requests
+	useConfig(t, &config{PriceCacheSeconds: -1})
+	stub := newCoinCapStub(t, map[string]string{"BTC": "65000", "ETH": "3000", "SOL": "150"})
+	useProvider(t, CoinCapProvider{})
+
+	v := valueHoldings(context.Background(), map[string]float64{"BTC": 1, "ETH": 2, "SOL": 10, "NOPE": 1, "DOGE": 5})
+	if n := stub.calls("/assets"); n != 1 {
+		t.Errorf("asset list fetched %d times, want 1", n)
+	}
+	if v.TotalValue != 72500 {
+		t.Errorf("total = %v, want 72500", v.TotalValue)
+	}
+	if want := []string{"DOGE", "NOPE"}; !reflect.DeepEqual(v.FailedSymbols, want) {
+		t.Errorf("failed symbols = %v, want every unpriced one, %v", v.FailedSymbols, want)
+	}
+}
+
+func BenchmarkValueHoldings(b *testing.B) {
+	useConfig(b, &config{PriceCacheSeconds: -1})
+	prices := make(map[string]string)
+	amounts := make(map[string]float64)
+	for i := 0; i < 50; i++ {
+		symbol := fmt.Sprintf("C%d", i)
+		prices[symbol] = "1.5"
+		amounts[symbol] = 2
+	}
+	newCoinCapStub(b, prices)
+	useProvider(b, CoinCapProvider{})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		valueHoldings(context.Background(), amounts)
+	}
+}
+
+func TestPriceErrorsPropagateFromCoinCap(t *testing.T) {
+	useConfig(t, &config{})
+	newCoinCapStub(t, map[string]string{"BTC": "65000", "ILLQ": ""})
+
+	if _, err := getCoinCapPrice(context.Background(), "NOPE"); !errors.Is(err, ErrSymbolNotFound) || errors.Is(err, ErrPriceUnavailable) {
+		t.Errorf("unlisted: err = %v, want ErrSymbolNotFound", err)
+	}
+	if _, err := getCoinCapPrice(context.Background(), "ILLQ"); !errors.Is(err, ErrPriceUnavailable) || errors.Is(err, ErrSymbolNotFound) {
+		t.Errorf("listed without a price: err = %v, want ErrPriceUnavailable", err)
+	}
+
+	// Through a fallback chain the sentinel still shows
+	useProvider(t, FallbackProvider{Providers: []PriceProvider{CoinCapProvider{}, staticProvider{}}})
+	if _, err := priceProvider.Price(context.Background(), "NOPE"); !errors.Is(err, ErrSymbolNotFound) {
+		t.Errorf("through FallbackProvider: err = %v, want ErrSymbolNotFound", err)
+	}
+}
+
+func TestPortfolioValueStatusForPriceErrors(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		provider PriceProvider
+		symbol   string
+		want     int
+		message  string
+	}{
+		{"unknown symbol", staticProvider{}, "NOPE", http.StatusUnprocessableEntity, "Unknown symbol NOPE"},
+		{"provider down", downProvider{errors.New("connection refused")}, "BTC", http.StatusInternalServerError, "Error fetching cryptocurrency price for BTC"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			store := &fakeStore{rows: []Portfolio{{ID: 1, UserID: 1, Symbol: c.symbol, Amount: 1}}}
+			_, mux := newTestServer(t, store, providerPriceClient{})
+			useProvider(t, c.provider)
+
+			rec := serve(mux, httptest.NewRequest(http.MethodGet, "/portfolio/value", nil))
+			if rec.Code != c.want || !strings.Contains(rec.Body.String(), c.message) {
+				t.Errorf("status = %d, body %q; want %d saying %q", rec.Code, rec.Body, c.want, c.message)
+			}
+		})
+	}
+}
+
+func TestValueHoldingsAggregatesEverySymbol(t *testing.T) {
+	useConfig(t, &config{PriceCacheSeconds: -1})
+	prices := make(map[string]string)
+	amounts := make(map[string]float64)
+	var wantFailed []string
+	for i := 0; i < 40; i++ {
+		symbol := fmt.Sprintf("C%02d", i)
+		amounts[symbol] = float64(i)
+		if i%10 == 9 {
+			wantFailed = append(wantFailed, symbol) // Not listed
+			continue
+		}
+		prices[symbol] = fmt.Sprint(i)
+	}
+	newCoinCapStub(t, prices)
+	useProvider(t, CoinCapProvider{})
+
+	v := valueHoldings(context.Background(), amounts)
+	var wantTotal float64
+	for symbol, amount := range amounts {
+		if _, ok := prices[symbol]; !ok || amount == 0 {
+			continue
+		}
+		wantTotal += amount * amount
+		if v.Values[symbol] != amount*amount {
+			t.Errorf("%s worth %v, want %v", symbol, v.Values[symbol], amount*amount)
+		}
+	}
+	if v.TotalValue != wantTotal || len(v.Values) != 35 {
+		t.Errorf("total %v over %d symbols, want %v over 35", v.TotalValue, len(v.Values), wantTotal)
+	}
+	if !reflect.DeepEqual(v.FailedSymbols, wantFailed) || !reflect.DeepEqual(v.NotFound, wantFailed) {
+		t.Errorf("failed %v, not found %v; want %v", v.FailedSymbols, v.NotFound, wantFailed)
+	}
+}
+
+func TestValueHoldingsStopsWithContext(t *testing.T) {
+	useConfig(t, &config{})
+	hungCoinCap(t)
+	useProvider(t, CoinCapProvider{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	v := valueHoldings(ctx, map[string]float64{"BTC": 1, "ETH": 1})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v after the context ended", elapsed)
+	}
+	if len(v.FailedSymbols) != 2 || v.TotalValue != 0 {
+		t.Errorf("got total %v, failed %v; want both failed", v.TotalValue, v.FailedSymbols)
+	}
+}
+
+// BenchmarkSerialVsBatchedPricing compares fetching each symbol's price in
+// turn, as valuations once did, with the single batched fetch they use now
+func BenchmarkSerialVsBatchedPricing(b *testing.B) {
+	useConfig(b, &config{PriceCacheSeconds: -1})
+	prices := make(map[string]string)
+	amounts := make(map[string]float64)
+	for i := 0; i < 20; i++ {
+		symbol := fmt.Sprintf("C%d", i)
+		prices[symbol] = "1.5"
+		amounts[symbol] = 2
+	}
+	newCoinCapStub(b, prices)
+	useProvider(b, CoinCapProvider{})
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			valueHoldingsWith(amounts, func(symbol string) (priceQuote, error) {
+				price, err := priceProvider.Price(context.Background(), symbol)
+				return priceQuote{Price: price}, err
+			})
+		}
+	})
+	b.Run("batched", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			valueHoldings(context.Background(), amounts)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import "fmt"
+
+// positionWeightingConfig ranks alerts by the USD value of the position
+// they concern, so moves in large holdings stand out and dust stays quiet.
+// Both bounds default to 0, which disables them.
+type positionWeightingConfig struct {
+	MinValueUSD  float64 `json:"min_value_usd"`  // Alerts for positions worth less are suppressed
+	HighValueUSD float64 `json:"high_value_usd"` // Alerts for positions worth at least this are high priority
+}
+
+// enabled reports whether either bound is set
+func (c positionWeightingConfig) enabled() bool {
+	return c.MinValueUSD > 0 || c.HighValueUSD > 0
+}
+
+// positionValue returns the USD value of everything held in symbol at price
+func (s *Server) positionValue(symbol string, price float64) (float64, error) {
+	h, err := s.store.Holdings(0)
+	if err != nil {
+		return 0, err
+	}
+	return h.bySymbol[symbol] * price, nil
+}
+
+// weightAlert applies position weighting to an alert about symbol. It
+// returns the message to send, prefixed when high priority, or false when
+// the position is too small to alert on. Without weighting configured, or
+// if the position can't be read, msg is passed through unchanged.
+func (s *Server) weightAlert(symbol string, price float64, msg string) (string, bool) {
+	weighting := cfg.PositionWeighting
+	if !weighting.enabled() {
+		return msg, true
+	}
+	value, err := s.positionValue(symbol, price)
+	if err != nil {
+		reportError("monitor", "Error reading position for alert weighting", "symbol", symbol, "err", err)
+		return msg, true
+	}
+	if weighting.MinValueUSD > 0 && value < weighting.MinValueUSD {
+		return "", false
+	}
+	if weighting.HighValueUSD > 0 && value >= weighting.HighValueUSD {
+		return fmt.Sprintf("[HIGH PRIORITY, position $%.2f] %s", value, msg), true
+	}
+	return msg, true
+}